@@ -19,12 +19,15 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 
 	_ "embed"
 
 	template "html/template"
 
 	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap"
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
 	"github.com/google/logger"
 	"github.com/gorilla/websocket"
 )
@@ -52,6 +55,81 @@ var upgrader = websocket.Upgrader{
 //go:embed web/templates/statusz.tmpl
 var statuszTemplate string
 
+const insertJobSql = `
+  INSERT INTO transcode_queue(source, destination, crf, srt_files, autocrop, video_filters, audio_filters, codec, audio_settings, stream_selection, audio_files, outputs, packaging, container, metadata, job_type, artifacts, trim_start, trim_end, trim_duration, sources, scan_type, deinterlacer, max_width, max_height, tonemap, hdr_policy, crop_alignment, crop_confidence, crop_fallback, aspect_policy, review_crop, frame_rate_mode, frame_rate)
+  VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+  `
+
+// insertJob marshals the structured fields of a request and inserts it using a
+// statement prepared from insertJobSql.
+func insertJob(stmt *sql.Stmt, j ffwrap.TranscodeRequest) (sql.Result, error) {
+	s, err := json.Marshal(j.Srt_files)
+	if err != nil {
+		return nil, err
+	}
+	a, err := json.Marshal(j.Audio)
+	if err != nil {
+		return nil, err
+	}
+	sel, err := json.Marshal(j.Streams)
+	if err != nil {
+		return nil, err
+	}
+	af, err := json.Marshal(j.Audio_files)
+	if err != nil {
+		return nil, err
+	}
+	o, err := json.Marshal(j.Outputs)
+	if err != nil {
+		return nil, err
+	}
+	p, err := json.Marshal(j.Packaging)
+	if err != nil {
+		return nil, err
+	}
+	m, err := json.Marshal(j.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	art, err := json.Marshal(j.Artifacts)
+	if err != nil {
+		return nil, err
+	}
+	src, err := json.Marshal(j.Sources)
+	if err != nil {
+		return nil, err
+	}
+	tm, err := json.Marshal(j.Tonemap)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.Exec(j.Source, j.Destination, j.Crf, s, j.Autocrop, j.Video_filters, j.Audio_filters, j.Codec, a, sel, af, o, p, j.Container, m, j.Job_type, art, j.Start, j.End, j.Duration, src, j.Scan_type, j.Deinterlacer, j.Max_width, j.Max_height, tm, j.Hdr_policy, j.Crop_alignment, j.Crop_confidence, j.Crop_fallback, j.Aspect_policy, j.Review_crop, j.Frame_rate_mode, j.Frame_rate)
+}
+
+// prepareRequest applies the named profile and the default codec to a
+// submitted request and validates the result. Metadata jobs always copy and
+// are never cropped, concat jobs take their source from the first of their
+// sources.
+func prepareRequest(j *ffwrap.TranscodeRequest) error {
+	if j.Profile != "" {
+		p, ok := tfConfig.Profiles[j.Profile]
+		if !ok {
+			return fmt.Errorf("%w: unknown profile %q", ffwrap.ErrInvalidRequest, j.Profile)
+		}
+		j.ApplyProfile(p)
+	}
+	if strings.EqualFold(j.Job_type, ffwrap.JobTypeConcat) && j.Source == "" && len(j.Sources) > 0 {
+		j.Source = j.Sources[0]
+	}
+	if strings.EqualFold(j.Job_type, ffwrap.JobTypeMetadata) && j.Codec == "" {
+		j.Codec = "copy"
+	}
+	if len(j.Codec) == 0 {
+		j.Codec = ffwrap.DefaultCodec
+	}
+	return j.Validate()
+}
+
 // queryQueued fetches all jobs that are currently queued (not in active_jobs) from the database.
 // The function returns a slice of PageQueueInfo objects representing the queued jobs if successful, or an error if something goes wrong.
 func queryQueued() ([]PageQueueInfo, error) {
@@ -62,7 +140,7 @@ func queryQueued() ([]PageQueueInfo, error) {
 	defer tx.Commit()
 
 	var queuedJobs []PageQueueInfo
-	var srtJsonBlob []byte
+	var srtJsonBlob, audioJsonBlob, selectionJsonBlob, audioFilesJsonBlob, outputsJsonBlob, packagingJsonBlob, metadataJsonBlob, artifactsJsonBlob, sourcesJsonBlob, tonemapJsonBlob []byte
 
 	q, err := tx.Query(`
   SELECT id,
@@ -71,12 +149,32 @@ func queryQueued() ([]PageQueueInfo, error) {
 		codec,
 		IFNULL(crf,18),
 		CASE
+			WHEN crop_complete = 2 THEN 'held for review'
 			WHEN autocrop = 1 AND crop_complete = 1 THEN 'complete'
 			WHEN autocrop = 1 AND crop_complete = 0 THEN 'pending'
 			WHEN autocrop IS NULL THEN 'pending'
 			ELSE 'disabled'
 		END AS autocrop,
-		srt_files
+		srt_files,
+		audio_settings,
+		stream_selection,
+		audio_files,
+		outputs,
+		packaging,
+		IFNULL(container, ''),
+		metadata,
+		IFNULL(job_type, ''),
+		artifacts,
+		IFNULL(trim_start, 0),
+		IFNULL(trim_end, 0),
+		IFNULL(trim_duration, 0),
+		sources,
+		IFNULL(scan_type, ''),
+		IFNULL(deinterlacer, ''),
+		IFNULL(max_width, 0),
+		IFNULL(max_height, 0),
+		tonemap,
+		IFNULL(hdr_policy, '')
   FROM transcode_queue
 	WHERE id not in (SELECT id FROM active_jobs)
   ORDER BY id ASC
@@ -88,7 +186,7 @@ func queryQueued() ([]PageQueueInfo, error) {
 
 	for q.Next() {
 		var jobRow PageQueueInfo
-		err := q.Scan(&jobRow.Id, &jobRow.JobDefinition.Source, &jobRow.JobDefinition.Destination, &jobRow.JobDefinition.Codec, &jobRow.JobDefinition.Crf, &jobRow.CropState, &srtJsonBlob)
+		err := q.Scan(&jobRow.Id, &jobRow.JobDefinition.Source, &jobRow.JobDefinition.Destination, &jobRow.JobDefinition.Codec, &jobRow.JobDefinition.Crf, &jobRow.CropState, &srtJsonBlob, &audioJsonBlob, &selectionJsonBlob, &audioFilesJsonBlob, &outputsJsonBlob, &packagingJsonBlob, &jobRow.JobDefinition.Container, &metadataJsonBlob, &jobRow.JobDefinition.Job_type, &artifactsJsonBlob, &jobRow.JobDefinition.Start, &jobRow.JobDefinition.End, &jobRow.JobDefinition.Duration, &sourcesJsonBlob, &jobRow.JobDefinition.Scan_type, &jobRow.JobDefinition.Deinterlacer, &jobRow.JobDefinition.Max_width, &jobRow.JobDefinition.Max_height, &tonemapJsonBlob, &jobRow.JobDefinition.Hdr_policy)
 		if err != nil && err != sql.ErrNoRows {
 			return nil, fmt.Errorf("failed scanning rows: %v", err)
 		}
@@ -96,6 +194,15 @@ func queryQueued() ([]PageQueueInfo, error) {
 		if err := json.Unmarshal(srtJsonBlob, &jobRow.JobDefinition.Srt_files); err != nil {
 			logger.Error("failed to unmarshall queue srt file")
 		}
+		unmarshalBlob("queue audio settings", audioJsonBlob, &jobRow.JobDefinition.Audio)
+		unmarshalBlob("queue stream selection", selectionJsonBlob, &jobRow.JobDefinition.Streams)
+		unmarshalBlob("queue audio files", audioFilesJsonBlob, &jobRow.JobDefinition.Audio_files)
+		unmarshalBlob("queue outputs", outputsJsonBlob, &jobRow.JobDefinition.Outputs)
+		unmarshalBlob("queue packaging", packagingJsonBlob, &jobRow.JobDefinition.Packaging)
+		unmarshalBlob("queue metadata", metadataJsonBlob, &jobRow.JobDefinition.Metadata)
+		unmarshalBlob("queue artifacts", artifactsJsonBlob, &jobRow.JobDefinition.Artifacts)
+		unmarshalBlob("queue sources", sourcesJsonBlob, &jobRow.JobDefinition.Sources)
+		unmarshalBlob("queue tonemap", tonemapJsonBlob, &jobRow.JobDefinition.Tonemap)
 
 		queuedJobs = append(queuedJobs, jobRow)
 	}
@@ -105,7 +212,7 @@ func queryQueued() ([]PageQueueInfo, error) {
 // queryActive fetches all active transcode jobs from the database.
 // The function returns a slice of TranscodeJob objects representing the active jobs if successful, or an error if something goes wrong.
 func queryActive() ([]TranscodeJob, error) {
-	var srtJsonBlob []byte
+	var srtJsonBlob, audioJsonBlob, selectionJsonBlob, audioFilesJsonBlob, outputsJsonBlob, packagingJsonBlob, metadataJsonBlob, artifactsJsonBlob, sourcesJsonBlob, scanJsonBlob, tonemapJsonBlob, cropJsonBlob, inventoryJsonBlob []byte
 	tx, err := db.Begin()
 	if err != nil {
 		return nil, err
@@ -125,7 +232,34 @@ func queryActive() ([]TranscodeJob, error) {
 		IIF(transcode_queue.codec = 'copy', 0, crf),
 		IFNULL(source_metadata.codec, 'unknown') as source_codec,
 		IFNULL(transcode_queue.codec, 'libx265') as destination_codec,
-		IFNULL(source_metadata.duration, 'unknown') as duration
+		IFNULL(source_metadata.duration, 'unknown') as duration,
+		audio_settings,
+		stream_selection,
+		audio_files,
+		outputs,
+		packaging,
+		IFNULL(container, ''),
+		metadata,
+		IFNULL(job_type, ''),
+		artifacts,
+		IFNULL(trim_start, 0),
+		IFNULL(trim_end, 0),
+		IFNULL(trim_duration, 0),
+		sources,
+		IFNULL(scan_type, ''),
+		IFNULL(deinterlacer, ''),
+		scan_analysis,
+		IFNULL(max_width, 0),
+		IFNULL(max_height, 0),
+		tonemap,
+		IFNULL(hdr_policy, ''),
+		IFNULL(source_metadata.hdr_format, ''),
+		crop_analysis,
+		source_metadata.inventory,
+		IFNULL(frame_rate_mode, ''),
+		IFNULL(transcode_queue.frame_rate, ''),
+		IFNULL(source_metadata.frame_rate, ''),
+		IFNULL(source_metadata.vfr, 0)
 	FROM transcode_queue
 		JOIN (active_jobs
 			LEFT JOIN source_metadata
@@ -138,17 +272,38 @@ func queryActive() ([]TranscodeJob, error) {
 
 	for a.Next() {
 		var jobRow TranscodeJob
-		err = a.Scan(&jobRow.Id, &jobRow.JobDefinition.Source, &jobRow.JobDefinition.Destination, &jobRow.State, &jobRow.JobDefinition.Video_filters, &srtJsonBlob, &jobRow.JobDefinition.Crf, &jobRow.SourceMeta.Codec, &jobRow.JobDefinition.Codec, &jobRow.SourceMeta.Duration)
+		err = a.Scan(&jobRow.Id, &jobRow.JobDefinition.Source, &jobRow.JobDefinition.Destination, &jobRow.State, &jobRow.JobDefinition.Video_filters, &srtJsonBlob, &jobRow.JobDefinition.Crf, &jobRow.SourceMeta.Codec, &jobRow.JobDefinition.Codec, &jobRow.SourceMeta.Duration, &audioJsonBlob, &selectionJsonBlob, &audioFilesJsonBlob, &outputsJsonBlob, &packagingJsonBlob, &jobRow.JobDefinition.Container, &metadataJsonBlob, &jobRow.JobDefinition.Job_type, &artifactsJsonBlob, &jobRow.JobDefinition.Start, &jobRow.JobDefinition.End, &jobRow.JobDefinition.Duration, &sourcesJsonBlob, &jobRow.JobDefinition.Scan_type, &jobRow.JobDefinition.Deinterlacer, &scanJsonBlob, &jobRow.JobDefinition.Max_width, &jobRow.JobDefinition.Max_height, &tonemapJsonBlob, &jobRow.JobDefinition.Hdr_policy, &jobRow.SourceMeta.Hdr_format, &cropJsonBlob, &inventoryJsonBlob, &jobRow.JobDefinition.Frame_rate_mode, &jobRow.JobDefinition.Frame_rate, &jobRow.SourceMeta.Frame_rate, &jobRow.SourceMeta.Vfr)
 
 		if err := json.Unmarshal(srtJsonBlob, &jobRow.JobDefinition.Srt_files); err != nil {
 			logger.Error("failed to unmarshall queue srt source(s)")
 		}
+		unmarshalBlob("active audio settings", audioJsonBlob, &jobRow.JobDefinition.Audio)
+		unmarshalBlob("active stream selection", selectionJsonBlob, &jobRow.JobDefinition.Streams)
+		unmarshalBlob("active audio files", audioFilesJsonBlob, &jobRow.JobDefinition.Audio_files)
+		unmarshalBlob("active outputs", outputsJsonBlob, &jobRow.JobDefinition.Outputs)
+		unmarshalBlob("active packaging", packagingJsonBlob, &jobRow.JobDefinition.Packaging)
+		unmarshalBlob("active metadata", metadataJsonBlob, &jobRow.JobDefinition.Metadata)
+		unmarshalBlob("active artifacts", artifactsJsonBlob, &jobRow.JobDefinition.Artifacts)
+		unmarshalBlob("active sources", sourcesJsonBlob, &jobRow.JobDefinition.Sources)
+		unmarshalBlob("active scan analysis", scanJsonBlob, &jobRow.JobDefinition.Scan_analysis)
+		unmarshalBlob("active tonemap", tonemapJsonBlob, &jobRow.JobDefinition.Tonemap)
+		unmarshalBlob("active crop analysis", cropJsonBlob, &jobRow.JobDefinition.Crop_analysis)
+		unmarshalBlob("active source inventory", inventoryJsonBlob, &jobRow.Inventory)
 
 		activeJobs = append(activeJobs, jobRow)
 	}
 	if err != nil && err != sql.ErrNoRows {
 		return nil, fmt.Errorf("fatal error scanning db response for active job: %#v", err)
 	}
+	a.Close()
+	for i := range activeJobs {
+		if activeJobs[i].State != JOB_CROPREVIEW {
+			continue
+		}
+		if activeJobs[i].CropPreviews, err = queryCropPreviews(tx, activeJobs[i].Id); err != nil {
+			logger.Errorf("job id %d: failed to query crop previews: %v", activeJobs[i].Id, err)
+		}
+	}
 	return activeJobs, nil
 }
 
@@ -170,7 +325,10 @@ func statuszHandler(w http.ResponseWriter, statuszTemplate string) {
 		logger.Errorf("failed to retrieve active jobs: %v", err)
 	}
 
-	t, err := template.New("results").Parse(statuszTemplate)
+	t, err := template.New("results").Funcs(template.FuncMap{
+		// inc numbers the crop previews from 1 as their handler does
+		"inc": func(i int) int { return i + 1 },
+	}).Parse(statuszTemplate)
 	if err != nil {
 		logger.Errorf("fatal error parsing template: %v", err)
 		errString := fmt.Sprintf("{error: %v}", err)
@@ -197,37 +355,29 @@ func addHandler(w http.ResponseWriter, req *http.Request, refreshChannel chan<-
 		return
 	}
 
-	if j.Source == "" || j.Destination == "" {
+	if (j.Source == "" && len(j.Sources) == 0) || j.Destination == "" {
 		http.Error(w, "{error: source or destination cannot be empty}", http.StatusBadRequest)
 		return
 	}
 
-	s, err := json.Marshal(j.Srt_files)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := prepareRequest(&j); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusBadRequest)
 		return
 	}
 
-	if len(j.Codec) == 0 {
-		j.Codec = "libx265"
-	}
-
 	tx, err := db.Begin()
 	if err != nil {
 		logger.Errorf("failed to begin transaction: %q", err)
 		return
 	}
 
-	stmt, err := tx.Prepare(`
-  INSERT INTO transcode_queue(source, destination, crf, srt_files, autocrop, video_filters, audio_filters, codec)
-  VALUES(?, ?, ?, ?, ?, ?, ?, ?)
-  `)
+	stmt, err := tx.Prepare(insertJobSql)
 	if err != nil {
 		tx.Rollback()
 		fmt.Fprintf(w, "failed to prepare sql: %v", err)
 	}
 
-	i, err := stmt.Exec(j.Source, j.Destination, j.Crf, s, j.Autocrop, j.Video_filters, j.Audio_filters, j.Codec)
+	i, err := insertJob(stmt, j)
 	if err != nil {
 		tx.Rollback()
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -261,10 +411,7 @@ func bulkAddHandler(w http.ResponseWriter, req *http.Request, refreshChannel cha
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`
-	  INSERT INTO transcode_queue(source, destination, crf, srt_files, autocrop, video_filters, audio_filters, codec)
-	  VALUES(?, ?, ?, ?, ?, ?, ?, ?)
-	  `)
+	stmt, err := tx.Prepare(insertJobSql)
 	if err != nil {
 		logger.Errorf("failed to prepare sql: %v", err)
 		fmt.Fprintf(w, `{"error": "%v"}`, err)
@@ -274,26 +421,21 @@ func bulkAddHandler(w http.ResponseWriter, req *http.Request, refreshChannel cha
 	insertedJobs := make(map[int64]ffwrap.TranscodeRequest)
 
 	for _, j := range jobs {
-		if j.Source == "" || j.Destination == "" {
+		if (j.Source == "" && len(j.Sources) == 0) || j.Destination == "" {
 			http.Error(w, `{"error": "source or destination cannot be empty"}`, http.StatusBadRequest)
 			return
 		}
 
-		s, err := json.Marshal(j.Srt_files)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+		if err := prepareRequest(&j); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusBadRequest)
 			return
 		}
 
-		if len(j.Codec) == 0 {
-			j.Codec = "libx265"
-		}
-
 		if j.Crf == 0 && j.Codec != "copy" {
 			j.Crf = 17
 		}
 
-		ins, err := stmt.Exec(j.Source, j.Destination, j.Crf, s, j.Autocrop, j.Video_filters, j.Audio_filters, j.Codec)
+		ins, err := insertJob(stmt, j)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
@@ -316,6 +458,152 @@ func bulkAddHandler(w http.ResponseWriter, req *http.Request, refreshChannel cha
 	refreshChannel <- true
 }
 
+// probeHandler responds with the stored ffprobe inventory of a job's source:
+// every stream, the chapters and the container format. The inventory is
+// available once the job has been probed and until it completes.
+func probeHandler(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "invalid job id %q"}`, req.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+	inv, err := queryInventory(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf(`{"error": "no probe results for job %d"}`, id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Errorf("job id %d: failed to query inventory: %v", id, err)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(inv); err != nil {
+		logger.Errorf("job id %d: failed to encode inventory: %v", id, err)
+	}
+}
+
+// artifactsHandler responds with the thumbnails, sprite sheets and previews
+// generated for a job, an empty list until they have been generated.
+func artifactsHandler(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "invalid job id %q"}`, req.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+	artifacts, err := queryArtifacts(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf(`{"error": "no job with id %d"}`, id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Errorf("job id %d: failed to query artifacts: %v", id, err)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(artifacts); err != nil {
+		logger.Errorf("job id %d: failed to encode artifacts: %v", id, err)
+	}
+}
+
+// codecsHandler responds with the video encoders requests can name: their
+// aliases, pixel formats, HDR support and parameters.
+func codecsHandler(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(codec.Encoders()); err != nil {
+		logger.Errorf("failed to encode codecs: %v", err)
+	}
+}
+
+// cropReviewHandler applies an operator's decision to the crop of a job held
+// for review: approve the detected crop, adjust it or disable cropping. The
+// decision is read from a JSON body, or from the form posted by the status
+// page which is then redirected back to it.
+func cropReviewHandler(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "invalid job id %q"}`, req.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+	var review ffwrap.CropReview
+	form := strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded")
+	if form {
+		review.Action, review.Crop = req.FormValue("action"), req.FormValue("crop")
+	} else if err := json.NewDecoder(req.Body).Decode(&review); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusBadRequest)
+		return
+	}
+
+	tj, err := pullHeldCrop(id)
+	if err == sql.ErrNoRows {
+		http.Error(w, fmt.Sprintf(`{"error": "job %d is not awaiting crop approval"}`, id), http.StatusNotFound)
+		return
+	} else if err != nil {
+		logger.Errorf("job id %d: failed to query held job: %v", id, err)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusInternalServerError)
+		return
+	}
+	if err := review.Validate(0, 0); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusBadRequest)
+		return
+	}
+	// an adjusted crop is only applied once it is known to fit the source
+	adjust := strings.EqualFold(review.Action, ffwrap.CropReviewAdjust)
+	if err := updateSourceMetadata(&tj); err != nil {
+		logger.Errorf("job id %d: failed to determine source metadata: %q", id, err)
+		if adjust {
+			http.Error(w, fmt.Sprintf(`{"error": "failed to determine the source dimensions of job %d"}`, id), http.StatusInternalServerError)
+			return
+		}
+	}
+	if adjust && (tj.SourceMeta.Width <= 0 || tj.SourceMeta.Height <= 0) {
+		http.Error(w, fmt.Sprintf(`{"error": "the source dimensions of job %d are unknown"}`, id), http.StatusConflict)
+		return
+	}
+	if err := review.Validate(tj.SourceMeta.Width, tj.SourceMeta.Height); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusBadRequest)
+		return
+	}
+	if err := reviewCrop(&tj, review); err != nil {
+		logger.Errorf("job id %d: failed to apply crop review: %v", id, err)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if form {
+		http.Redirect(w, req, "/statusz", http.StatusSeeOther)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]string{"video_filters": tj.JobDefinition.Video_filters}); err != nil {
+		logger.Errorf("job id %d: failed to encode crop review: %v", id, err)
+	}
+}
+
+// cropPreviewHandler serves the nth crop preview of a job, counting from 1.
+func cropPreviewHandler(w http.ResponseWriter, req *http.Request) {
+	id, err := strconv.Atoi(req.PathValue("id"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "invalid job id %q"}`, req.PathValue("id")), http.StatusBadRequest)
+		return
+	}
+	n, err := strconv.Atoi(req.PathValue("n"))
+	if err != nil || n < 1 {
+		http.Error(w, fmt.Sprintf(`{"error": "invalid preview %q"}`, req.PathValue("n")), http.StatusBadRequest)
+		return
+	}
+	previews, err := queryCropPreviews(db, id)
+	if err != nil {
+		logger.Errorf("job id %d: failed to query crop previews: %v", id, err)
+		http.Error(w, fmt.Sprintf(`{"error": %q}`, err), http.StatusInternalServerError)
+		return
+	}
+	if n > len(previews) {
+		http.Error(w, fmt.Sprintf(`{"error": "job %d has no crop preview %d"}`, id, n), http.StatusNotFound)
+		return
+	}
+	http.ServeFile(w, req, previews[n-1].Path)
+}
+
 // logStream upgrades an HTTP connection to a WebSocket and registers it with the websocket hub.
 // The readPump and writePump goroutines are started for handling incoming and outgoing messages respectively.
 func logStream(w http.ResponseWriter, r *http.Request) {
@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap"
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -28,6 +29,42 @@ const (
 	noSourceJsonSlice       = `[{"destination":"/path/to/destination.mkv","autocrop":true,"crf":18,"srt_files":["/path/to/srt/1.srt","/path/to/srt/2.srt"],"codec":"libx265","video_filters":""}]`
 	noDestinationJsonSingle = `{"source":"/path/to/source.mkv","autocrop":true,"crf":18,"srt_files":["/path/to/srt/1.srt","/path/to/srt/2.srt"],"codec":"libx265","video_filters":""}`
 	noDestinationJsonSlice  = `[{"source":"/path/to/source.mkv","autocrop":true,"crf":18,"srt_files":["/path/to/srt/1.srt","/path/to/srt/2.srt"],"codec":"libx265","video_filters":""}]`
+	audioJsonSingle         = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"codec":"libx265","audio":{"codec":"copy","rules":[{"match_commentary":true,"codec":"opus","bitrate":"96k"}]},"audio_filters":"loudnorm"}`
+	badAudioJsonSingle      = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"codec":"libx265","audio":{"codec":"mp3"}}`
+	copyAudioFilterJson     = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"codec":"libx265","audio_filters":"loudnorm"}`
+	languagesJsonSingle     = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"stream_selection":{"audio_languages":["original","eng"],"subtitle_languages":["eng"],"keep_undetermined":true,"forced_subtitle":"eng"}}`
+	badLanguageJsonSingle   = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"stream_selection":{"audio_languages":["english"]}}`
+	richSubtitlesJsonSingle = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mp4","crf":18,"srt_files":[{"path":"/path/to/signs.ass","language":"eng","title":"Signs","forced":true,"offset":-0.5},"/path/to/full.srt"]}`
+	pgsInMp4JsonSingle      = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mp4","crf":18,"srt_files":[{"path":"/path/to/subs.sup"}]}`
+	audioFilesJsonSingle    = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"audio_files":[{"path":"/path/to/dub.ac3","language":"ger","title":"Dub","delay":0.5,"codec":"opus","bitrate":"128k"}]}`
+	badAudioFileJsonSingle  = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"audio_files":[{"path":"/path/to/dub.ac3","codec":"mp3"}]}`
+	renditionsJsonSingle    = `{"source":"/path/to/source.mkv","destination":"/path/to/2160p.mkv","crf":18,"outputs":[{"destination":"/path/to/1080p.mkv","crf":20,"video_filters":"scale=-2:1080"},{"destination":"/path/to/720p.mp4","codec":"libsvtav1","crf":30,"video_filters":"scale=-2:720"}]}`
+	dupRenditionJsonSingle  = `{"source":"/path/to/source.mkv","destination":"/path/to/2160p.mkv","crf":18,"outputs":[{"destination":"/path/to/2160p.mkv","crf":20}]}`
+	hlsJsonSingle           = `{"source":"/path/to/source.mkv","destination":"/srv/www/title","crf":18,"packaging":{"format":"hls","segment_seconds":4},"outputs":[{"destination":"720p","crf":22,"video_filters":"scale=-2:720"}]}`
+	badPackagingJsonSingle  = `{"source":"/path/to/source.mkv","destination":"/srv/www/title","codec":"copy","packaging":{"format":"hls"}}`
+	webmJsonSingle          = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.webm","crf":30,"codec":"libsvtav1","container":"webm","audio":{"codec":"opus","bitrate":"128k"}}`
+	webmHevcJsonSingle      = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.webm","crf":18,"codec":"libx265"}`
+	metadataJobJsonSingle   = `{"source":"/path/to/source.mkv","destination":"/path/to/tagged.mkv","job_type":"metadata","metadata":{"clear_tags":true,"tags":{"title":"A Film"},"chapters_file":"/path/to/chapters.txt","cover_image":"/path/to/cover.jpg"}}`
+	metadataEncodeJson      = `{"source":"/path/to/source.mkv","destination":"/path/to/tagged.mkv","codec":"libx265","job_type":"metadata","metadata":{"tags":{"title":"A Film"}}}`
+	artifactsJsonSingle     = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"artifacts":{"thumbnails":{"timestamps":[30,60],"scene_count":5,"width":640},"sprite":{"interval":5},"preview":{"format":"webp","duration":4}}}`
+	badArtifactsJsonSingle  = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"artifacts":{"preview":{"format":"avi"}}}`
+	trimJsonSingle          = `{"source":"/path/to/source.mkv","destination":"/path/to/sample.mkv","codec":"copy","start":95.5,"duration":60}`
+	badTrimJsonSingle       = `{"source":"/path/to/source.mkv","destination":"/path/to/sample.mkv","crf":18,"start":120,"end":60}`
+	concatJsonSingle        = `{"job_type":"concat","sources":["/path/to/disc1.mkv","/path/to/disc2.mkv"],"destination":"/path/to/film.mkv","codec":"copy"}`
+	badConcatJsonSingle     = `{"job_type":"concat","sources":["/path/to/disc1.mkv"],"destination":"/path/to/film.mkv","codec":"copy"}`
+	telecineJsonSingle      = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"scan_type":"telecined","deinterlacer":"yadif"}`
+	badScanJsonSingle       = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","codec":"copy","scan_type":"auto"}`
+	scaledCopyJsonSingle    = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","codec":"copy","max_height":1080}`
+	tonemapJsonSingle       = `{"source":"/path/to/hdr.mkv","destination":"/path/to/2160p.mkv","crf":18,"outputs":[{"destination":"/path/to/1080p.mkv","video_filters":"scale=-2:1080","tonemap":{"algorithm":"mobius","peak":1000}}]}`
+	badHdrPolicyJsonSingle  = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"hdr_policy":"sdr"}`
+	heldCropJsonSingle      = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"autocrop":true,"crop_alignment":16,"crop_confidence":0.8,"crop_fallback":"hold"}`
+	badCropJsonSingle       = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"autocrop":true,"crop_alignment":4}`
+	badAspectJsonSingle     = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"autocrop":true,"aspect_policy":"imax"}`
+	reviewNoCropJsonSingle  = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"review_crop":true}`
+	cfrJsonSingle           = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"frame_rate_mode":"cfr","frame_rate":"30000/1001"}`
+	vfrRateJsonSingle       = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"frame_rate_mode":"vfr","frame_rate":"30"}`
+	unknownCodecJsonSingle  = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"codec":"libx266"}`
+	unknownProfileJson      = `{"source":"/path/to/source.mkv","destination":"/path/to/destination.mkv","crf":18,"profile":"missing"}`
 )
 
 // createEmptyTestDb initializes an in-memory SQLite database for testing purposes.
@@ -108,6 +145,258 @@ func TestAddHandler(t *testing.T) {
 			respCode: http.StatusBadRequest,
 			rc:       testChannel,
 		},
+		{
+			desc:     "audio settings",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(audioJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "unsupported audio codec",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badAudioJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "audio filters with copied audio",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(copyAudioFilterJson)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "language selection",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(languagesJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "invalid language",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badLanguageJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "subtitle objects",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(richSubtitlesJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "bitmap subtitles in mp4",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(pgsInMp4JsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "external audio files",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(audioFilesJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "external audio file with unsupported codec",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badAudioFileJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "multiple renditions",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(renditionsJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "rendition reusing the destination",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(dupRenditionJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "hls packaging",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(hlsJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "packaging copied video",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badPackagingJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "explicit webm container",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(webmJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "hevc in webm",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(webmHevcJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "metadata job",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(metadataJobJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "metadata job encoding video",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(metadataEncodeJson)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "artifacts",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(artifactsJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "unsupported preview format",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badArtifactsJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "trimmed copy",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(trimJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "end before start",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badTrimJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "concat job",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(concatJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "concat job with one source",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badConcatJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "telecined source",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(telecineJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "scan detection when copying",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badScanJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "resolution limit when copying",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(scaledCopyJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "tone mapped rendition",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(tonemapJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "unknown hdr policy",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badHdrPolicyJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "crop held below confidence",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(heldCropJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "unsupported crop alignment",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badCropJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "unknown aspect policy",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(badAspectJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "crop review without autocrop",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(reviewNoCropJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "constant frame rate",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(cfrJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusOK,
+			rc:       testChannel,
+		},
+		{
+			desc:     "variable frame rate with a chosen rate",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(vfrRateJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "unknown codec",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(unknownCodecJsonSingle)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
+		{
+			desc:     "unknown profile",
+			request:  httptest.NewRequest("POST", "/add", strings.NewReader(unknownProfileJson)),
+			recorder: httptest.NewRecorder(),
+			respCode: http.StatusBadRequest,
+			rc:       testChannel,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -319,6 +608,63 @@ func TestQueryQueued(t *testing.T) {
 	}
 }
 
+func TestPrepareRequest(t *testing.T) {
+	oc := tfConfig
+	tfConfig.Profiles = map[string]ffwrap.Profile{
+		"web": {Audio: &ffwrap.AudioSettings{Codec: "opus", Bitrate: "128k"}, Audio_filters: "loudnorm"},
+	}
+	t.Cleanup(func() { tfConfig = oc })
+
+	testCases := []struct {
+		desc        string
+		request     ffwrap.TranscodeRequest
+		expected    ffwrap.TranscodeRequest
+		shouldError bool
+	}{
+		{
+			desc:     "defaults codec",
+			request:  ffwrap.TranscodeRequest{Source: "a", Destination: "b"},
+			expected: ffwrap.TranscodeRequest{Source: "a", Destination: "b", Codec: "libx265"},
+		},
+		{
+			desc:    "profile fills unset fields",
+			request: ffwrap.TranscodeRequest{Source: "a", Destination: "b", Profile: "web"},
+			expected: ffwrap.TranscodeRequest{
+				Source: "a", Destination: "b", Profile: "web", Codec: "libx265",
+				Audio:         &ffwrap.AudioSettings{Codec: "opus", Bitrate: "128k"},
+				Audio_filters: "loudnorm",
+			},
+		},
+		{
+			desc:    "request overrides profile",
+			request: ffwrap.TranscodeRequest{Source: "a", Destination: "b", Profile: "web", Audio: &ffwrap.AudioSettings{Codec: "aac"}},
+			expected: ffwrap.TranscodeRequest{
+				Source: "a", Destination: "b", Profile: "web", Codec: "libx265",
+				Audio:         &ffwrap.AudioSettings{Codec: "aac"},
+				Audio_filters: "loudnorm",
+			},
+		},
+		{
+			desc:        "unknown profile",
+			request:     ffwrap.TranscodeRequest{Source: "a", Destination: "b", Profile: "missing"},
+			expected:    ffwrap.TranscodeRequest{Source: "a", Destination: "b", Profile: "missing"},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			err := prepareRequest(&tc.request)
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: prepareRequest() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+			if diff := cmp.Diff(tc.expected, tc.request); diff != "" {
+				t.Errorf("%q: prepared request diff: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
 func insertQueuedJob(t *testing.T, jobNum int, codec string) {
 	t.Helper()
 	_, err := db.Exec(`
@@ -434,7 +780,7 @@ func TestQueryActive(t *testing.T) {
 					JobDefinition: ffwrap.TranscodeRequest{
 						Source:        "/path/to/source1.mkv",
 						Destination:   "/path/to/destination1.mkv",
-						Srt_files:     []string{"srt_file1"},
+						Srt_files:     []ffwrap.SubtitleFile{{Path: "srt_file1"}},
 						Crf:           18,
 						Codec:         "libx265",
 						Video_filters: "",
@@ -483,6 +829,14 @@ func TestStatuszHandler(t *testing.T) {
 		db.Close()
 		db = odb
 	})
+	oh := wsHub
+	wsHub = newHub()
+	t.Cleanup(func() { wsHub = oh })
+	insertQueuedJob(t, 1, "libx265")
+	insertQueuedJob(t, 2, "libx265")
+	if err := updateJobStatus(1, JOB_TRANSCODING); err != nil {
+		t.Fatalf("failed to update job status: %v", err)
+	}
 
 	req, err := http.NewRequest("GET", "/statusz", nil)
 	if err != nil {
@@ -521,3 +875,208 @@ func TestStatuszHandlerPanic(t *testing.T) {
 	var _ *http.Request = req
 	statuszHandler(http.ResponseWriter(rr), "{{range .ActiveJobs}}")
 }
+
+func TestProbeHandler(t *testing.T) {
+	odb := db
+	db = createEmptyTestDb(t)
+	t.Cleanup(func() {
+		db.Close()
+		db = odb
+	})
+	insertQueuedJob(t, 1, "libx265")
+	insertQueuedJob(t, 2, "libx265")
+	inv := ffwrap.FfprobeOutput{
+		Streams: []ffwrap.FfprobeStreams{{Index: 0, Codec: "h264", Codec_type: "video", Width: 1920, Height: 1080}},
+		Format:  ffwrap.FfprobeFormat{Duration: "60.000000", Tags: map[string]string{"title": "Example"}},
+	}
+	b, err := json.Marshal(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE source_metadata SET inventory = ? WHERE id = 1", b); err != nil {
+		t.Fatalf("failed to store inventory: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs/{id}/probe", probeHandler)
+
+	testCases := []struct {
+		desc     string
+		path     string
+		respCode int
+		expected *ffwrap.FfprobeOutput
+	}{
+		{desc: "probed job", path: "/jobs/1/probe", respCode: http.StatusOK, expected: &inv},
+		{desc: "job not probed", path: "/jobs/2/probe", respCode: http.StatusNotFound},
+		{desc: "unknown job", path: "/jobs/3/probe", respCode: http.StatusNotFound},
+		{desc: "invalid id", path: "/jobs/abc/probe", respCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+			if rr.Code != tc.respCode {
+				t.Fatalf("%q: got status %d want %d: %s", tc.desc, rr.Code, tc.respCode, rr.Body)
+			}
+			if tc.expected == nil {
+				return
+			}
+			var got ffwrap.FfprobeOutput
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%q: failed to decode response: %v", tc.desc, err)
+			}
+			if diff := cmp.Diff(*tc.expected, got); diff != "" {
+				t.Errorf("%q: unexpected inventory: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestCropReviewHandler(t *testing.T) {
+	odb := db
+	oh := wsHub
+	db = createEmptyTestDb(t)
+	wsHub = newHub()
+	t.Cleanup(func() {
+		db.Close()
+		db = odb
+		wsHub = oh
+	})
+	for i := 1; i <= 5; i++ {
+		insertQueuedCrop(t, i, "libx265")
+	}
+	analysis, err := json.Marshal(ffwrap.CropAnalysis{Filter: "crop=1920:800:0:140", Confidence: 0.4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE transcode_queue SET crop_complete = 2, crop_analysis = ? WHERE id IN (1, 2, 4, 5)", analysis); err != nil {
+		t.Fatalf("failed to hold jobs: %v", err)
+	}
+	// job 4's source has to be probed, job 5's was probed without dimensions
+	if _, err := db.Exec("DELETE FROM source_metadata WHERE id = 4"); err != nil {
+		t.Fatalf("failed to delete source metadata: %v", err)
+	}
+	if _, err := db.Exec("UPDATE source_metadata SET width = 0, height = 0 WHERE id = 5"); err != nil {
+		t.Fatalf("failed to update source metadata: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /jobs/{id}/crop", cropReviewHandler)
+
+	testCases := []struct {
+		desc         string
+		path         string
+		body         string
+		form         bool
+		respCode     int
+		videoFilters string
+	}{
+		{desc: "job not held", path: "/jobs/3/crop", body: `{"action":"approve"}`, respCode: http.StatusNotFound},
+		{desc: "odd adjustment", path: "/jobs/1/crop", body: `{"action":"adjust","crop":"crop=1921:800:0:140"}`, respCode: http.StatusBadRequest},
+		{desc: "unknown action", path: "/jobs/1/crop", body: `{"action":"skip"}`, respCode: http.StatusBadRequest},
+		{desc: "approve", path: "/jobs/1/crop", body: `{"action":"approve"}`, respCode: http.StatusOK, videoFilters: "crop=1920:800:0:140"},
+		{desc: "already reviewed", path: "/jobs/1/crop", body: `{"action":"approve"}`, respCode: http.StatusNotFound},
+		{desc: "disable from the status page", path: "/jobs/2/crop", body: "action=disable", form: true, respCode: http.StatusSeeOther},
+		{desc: "adjust unprobed source", path: "/jobs/4/crop", body: `{"action":"adjust","crop":"crop=1920:800:0:140"}`, respCode: http.StatusInternalServerError},
+		{desc: "adjust unknown dimensions", path: "/jobs/5/crop", body: `{"action":"adjust","crop":"crop=1920:800:0:140"}`, respCode: http.StatusConflict},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tc.path, strings.NewReader(tc.body))
+			if tc.form {
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+			if rr.Code != tc.respCode {
+				t.Fatalf("%q: got status %d want %d: %s", tc.desc, rr.Code, tc.respCode, rr.Body)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var got map[string]string
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%q: failed to decode response: %v", tc.desc, err)
+			}
+			if got["video_filters"] != tc.videoFilters {
+				t.Errorf("%q: video filters %q want %q", tc.desc, got["video_filters"], tc.videoFilters)
+			}
+		})
+	}
+
+	var complete int
+	var vf string
+	if err := db.QueryRow("SELECT crop_complete, video_filters FROM transcode_queue WHERE id = 2").Scan(&complete, &vf); err != nil {
+		t.Fatal(err)
+	}
+	if complete != 1 || vf != "" {
+		t.Errorf("disabled crop left crop_complete %d and video filters %q", complete, vf)
+	}
+}
+
+func TestArtifactsHandler(t *testing.T) {
+	odb := db
+	db = createEmptyTestDb(t)
+	t.Cleanup(func() {
+		db.Close()
+		db = odb
+	})
+	insertQueuedJob(t, 1, "libx265")
+	insertQueuedJob(t, 2, "libx265")
+	thumb := ffwrap.Artifact{Kind: ffwrap.ArtifactThumbnail, Path: "/out/title_artifacts/thumbnail_001.jpg", Timestamp: 30}
+	index := ffwrap.Artifact{Kind: ffwrap.ArtifactSpriteIndex, Path: "/out/title_artifacts/sprite.vtt"}
+	for _, a := range []ffwrap.Artifact{thumb, index} {
+		if _, err := db.Exec("INSERT INTO artifacts (id, kind, path, timestamp) VALUES (1, ?, ?, ?)", a.Kind, a.Path, a.Timestamp); err != nil {
+			t.Fatalf("failed to insert artifact: %v", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs/{id}/artifacts", artifactsHandler)
+
+	testCases := []struct {
+		desc     string
+		path     string
+		respCode int
+		expected []ffwrap.Artifact
+	}{
+		{desc: "job with artifacts", path: "/jobs/1/artifacts", respCode: http.StatusOK, expected: []ffwrap.Artifact{index, thumb}},
+		{desc: "job without artifacts", path: "/jobs/2/artifacts", respCode: http.StatusOK, expected: []ffwrap.Artifact{}},
+		{desc: "unknown job", path: "/jobs/3/artifacts", respCode: http.StatusNotFound},
+		{desc: "invalid id", path: "/jobs/abc/artifacts", respCode: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, httptest.NewRequest("GET", tc.path, nil))
+			if rr.Code != tc.respCode {
+				t.Fatalf("%q: got status %d want %d: %s", tc.desc, rr.Code, tc.respCode, rr.Body)
+			}
+			if tc.expected == nil {
+				return
+			}
+			var got []ffwrap.Artifact
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatalf("%q: failed to decode response: %v", tc.desc, err)
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("%q: unexpected artifacts: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestCodecsHandler(t *testing.T) {
+	rr := httptest.NewRecorder()
+	codecsHandler(rr, httptest.NewRequest("GET", "/codecs", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var got []codec.EncoderInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal codecs: %v", err)
+	}
+	if diff := cmp.Diff(codec.Encoders(), got); diff != "" {
+		t.Errorf("unexpected codecs: %s", diff)
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"os/exec"
 	"path/filepath"
 
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap"
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+
 	"gopkg.in/yaml.v3"
 )
 
@@ -22,6 +25,9 @@ type TFConfig struct {
 	LogDirectory   *string `yaml:"log_directory,omitempty"`
 	ListenPort     *int    `yaml:"listen_port,omitempty"`
 	ListenAddress  *string `yaml:"listen_address,omitempty"`
+
+	Profiles map[string]ffwrap.Profile `yaml:"profiles,omitempty"`
+	Codecs   map[string]codec.Template `yaml:"codecs,omitempty"`
 }
 
 const (
@@ -151,6 +157,9 @@ func (c *TFConfig) loadConfig(configFile fs.File) error {
 		*c.ListenAddress = defaultListenAddress
 	}
 
+	c.Profiles = tempConfig.Profiles
+	c.Codecs = tempConfig.Codecs
+
 	return nil
 }
 
@@ -8,6 +8,8 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap"
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -65,6 +67,43 @@ func buildFromEnv(t *testing.T) *TFConfig {
 	return df
 }
 
+func buildWithProfiles(t *testing.T) *TFConfig {
+	t.Helper()
+	df := buildFromConstants(t)
+	df.Profiles = map[string]ffwrap.Profile{
+		"web": {
+			Audio:      &ffwrap.AudioSettings{Codec: "opus", Bitrate: "128k", Channel_layout: "stereo"},
+			Max_width:  1920,
+			Max_height: 1080,
+		},
+		"archive": {
+			Audio: &ffwrap.AudioSettings{
+				Codec: "copy",
+				Rules: []ffwrap.AudioRule{{Match_commentary: true, Codec: "opus", Bitrate: "96k"}},
+			},
+			Audio_filters: "loudnorm",
+		},
+	}
+	return df
+}
+
+func buildWithCodecs(t *testing.T) *TFConfig {
+	t.Helper()
+	df := buildFromConstants(t)
+	df.Codecs = map[string]codec.Template{
+		"x265_psy": {
+			Encoder:      "libx265",
+			Preset:       "slow",
+			Args:         []string{"-profile:v", "main10"},
+			Params:       []string{"psy-rd=2.0", "psy-rdoq=1.0"},
+			Pixel_format: "yuv420p10le",
+			Hdr:          true,
+		},
+		"nvenc_cq": {Encoder: "hevc_nvenc", Crf_flag: "-cq", Preset: "p7"},
+	}
+	return df
+}
+
 func TestDefaultConfiguration(t *testing.T) {
 	tests := []struct {
 		name string
@@ -102,6 +141,16 @@ func TestLoadConfig(t *testing.T) {
 			testFile: testFile("test_data/empty.yaml", t),
 			want:     buildFromConstants(t),
 		},
+		{
+			name:     "profiles",
+			testFile: testFile("test_data/profiles.yaml", t),
+			want:     buildWithProfiles(t),
+		},
+		{
+			name:     "codec templates",
+			testFile: testFile("test_data/codecs.yaml", t),
+			want:     buildWithCodecs(t),
+		},
 		{
 			name:     "empty config file with env",
 			testFile: testFile("test_data/empty.yaml", t),
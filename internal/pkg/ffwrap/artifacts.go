@@ -0,0 +1,389 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/google/logger"
+)
+
+const (
+	ArtifactThumbnail   = "thumbnail"
+	ArtifactSprite      = "sprite"
+	ArtifactSpriteIndex = "sprite_index"
+	ArtifactPreview     = "preview"
+
+	PreviewGif  = "gif"
+	PreviewWebp = "webp"
+	PreviewMp4  = "mp4"
+
+	defaultSpriteInterval  = 10
+	defaultSpriteColumns   = 10
+	defaultSpriteRows      = 10
+	defaultSpriteWidth     = 160
+	defaultPreviewDuration = 3
+	defaultPreviewWidth    = 320
+	maxPreviewDuration     = 60
+	maxSceneThumbnails     = 100
+	previewFrameRate       = 12
+	// sceneThreshold is the scene score above which a frame starts a new scene.
+	sceneThreshold = 0.3
+)
+
+// directory returns the directory the artifacts of destination are written to.
+func (a Artifacts) directory(destination string) string {
+	if a.Directory != "" {
+		return a.Directory
+	}
+	return strings.TrimSuffix(destination, filepath.Ext(destination)) + "_artifacts"
+}
+
+// interval returns the seconds between sprite tiles.
+func (s SpriteOptions) interval() float64 {
+	if s.Interval > 0 {
+		return s.Interval
+	}
+	return defaultSpriteInterval
+}
+
+// grid returns the columns and rows of a sprite sheet.
+func (s SpriteOptions) grid() (int, int) {
+	c, r := s.Columns, s.Rows
+	if c <= 0 {
+		c = defaultSpriteColumns
+	}
+	if r <= 0 {
+		r = defaultSpriteRows
+	}
+	return c, r
+}
+
+// width returns the width of a sprite tile.
+func (s SpriteOptions) width() int {
+	if s.Width > 0 {
+		return s.Width
+	}
+	return defaultSpriteWidth
+}
+
+// format returns the container of the preview clip, gif unless set.
+func (p PreviewOptions) format() string {
+	if p.Format == "" {
+		return PreviewGif
+	}
+	return strings.ToLower(p.Format)
+}
+
+// duration returns the length of the preview clip in seconds.
+func (p PreviewOptions) duration() float64 {
+	if p.Duration > 0 {
+		return p.Duration
+	}
+	return defaultPreviewDuration
+}
+
+// width returns the width of the preview clip.
+func (p PreviewOptions) width() int {
+	if p.Width > 0 {
+		return p.Width
+	}
+	return defaultPreviewWidth
+}
+
+// start returns the start of the preview clip, a tenth into the video unless
+// set, to skip past logos and title cards.
+func (p PreviewOptions) start(duration float64) float64 {
+	if p.Start > 0 {
+		return p.Start
+	}
+	return math.Round(duration/10*1000) / 1000
+}
+
+// validate checks the artifact options.
+func (a Artifacts) validate() error {
+	if a.Thumbnails == nil && a.Sprite == nil && a.Preview == nil {
+		return fmt.Errorf("artifacts require thumbnails, a sprite or a preview")
+	}
+	if t := a.Thumbnails; t != nil {
+		if len(t.Timestamps) == 0 && t.Scene_count == 0 {
+			return fmt.Errorf("thumbnails require timestamps or a scene_count")
+		}
+		for _, ts := range t.Timestamps {
+			if ts < 0 {
+				return fmt.Errorf("thumbnail timestamp %v cannot be negative", ts)
+			}
+		}
+		if t.Scene_count < 0 || t.Scene_count > maxSceneThumbnails {
+			return fmt.Errorf("scene_count must be between 0 and %d", maxSceneThumbnails)
+		}
+		if t.Width < 0 {
+			return fmt.Errorf("thumbnail width cannot be negative")
+		}
+	}
+	if s := a.Sprite; s != nil {
+		if s.Interval < 0 || s.Columns < 0 || s.Rows < 0 || s.Width < 0 {
+			return fmt.Errorf("sprite options cannot be negative")
+		}
+	}
+	if p := a.Preview; p != nil {
+		switch p.format() {
+		case PreviewGif, PreviewWebp, PreviewMp4:
+		default:
+			return fmt.Errorf("unsupported preview format %q", p.Format)
+		}
+		if p.Start < 0 || p.Width < 0 {
+			return fmt.Errorf("preview options cannot be negative")
+		}
+		if p.Duration < 0 || p.Duration > maxPreviewDuration {
+			return fmt.Errorf("preview duration must be between 0 and %d seconds", maxPreviewDuration)
+		}
+	}
+	return nil
+}
+
+// formatSeconds formats seconds for ffmpeg time options.
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', -1, 64)
+}
+
+// formatVttTime formats seconds as a WebVTT timestamp, e.g. 00:01:30.000.
+func formatVttTime(s float64) string {
+	ms := int64(math.Round(s * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// scaledHeight returns the height ffmpeg picks for scale=width:-2 of a video
+// of w by h pixels.
+func scaledHeight(width, w, h int) int {
+	if w == 0 {
+		return 0
+	}
+	return int(math.Round(float64(width)*float64(h)/float64(w*2))) * 2
+}
+
+// withScale appends a scale filter keeping the aspect ratio to filters when
+// width is set.
+func withScale(filters []string, width int) string {
+	if width > 0 {
+		filters = append(filters, fmt.Sprintf("scale=%d:-2", width))
+	}
+	return strings.Join(filters, ",")
+}
+
+// thumbnailArgs extracts the frame at ts seconds into out.
+func thumbnailArgs(input string, ts float64, width int, out string) []string {
+	args := append(append([]string{}, ffquiet...), "-ss", formatSeconds(ts), "-i", input, "-map", "0:v:0", "-frames:v", "1")
+	if vf := withScale(nil, width); vf != "" {
+		args = append(args, "-vf", vf)
+	}
+	return append(args, "-q:v", "2", out)
+}
+
+// sceneThumbnailArgs extracts up to count frames starting a new scene into
+// the numbered files of pattern.
+func sceneThumbnailArgs(input string, count, width int, pattern string) []string {
+	vf := withScale([]string{fmt.Sprintf("select='gt(scene,%v)'", sceneThreshold)}, width)
+	return append(append([]string{}, ffquiet...),
+		"-i", input, "-map", "0:v:0", "-vf", vf, "-fps_mode", "vfr", "-frames:v", strconv.Itoa(count), "-q:v", "2", pattern)
+}
+
+// spriteArgs tiles a frame every interval into the numbered sheets of pattern.
+func spriteArgs(input string, s SpriteOptions, pattern string) []string {
+	c, r := s.grid()
+	vf := fmt.Sprintf("fps=1/%s,scale=%d:-2,tile=%dx%d", formatSeconds(s.interval()), s.width(), c, r)
+	return append(append([]string{}, ffquiet...),
+		"-i", input, "-map", "0:v:0", "-vf", vf, "-fps_mode", "vfr", "-q:v", "3", pattern)
+}
+
+// spriteIndex returns the WebVTT file mapping every interval of a video of
+// duration seconds to its tile in sheets, tiles being tileHeight pixels high.
+func spriteIndex(s SpriteOptions, tileHeight int, duration float64, sheets []string) string {
+	c, r := s.grid()
+	w, interval := s.width(), s.interval()
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	for i := 0; float64(i)*interval < duration; i++ {
+		sheet := i / (c * r)
+		if sheet >= len(sheets) {
+			break
+		}
+		tile := i % (c * r)
+		end := math.Min(float64(i+1)*interval, duration)
+		fmt.Fprintf(&b, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			formatVttTime(float64(i)*interval), formatVttTime(end),
+			filepath.Base(sheets[sheet]), tile%c*w, tile/c*tileHeight, w, tileHeight)
+	}
+	return b.String()
+}
+
+// previewArgs cuts the animated preview of a video of duration seconds into out.
+func previewArgs(input string, p PreviewOptions, duration float64, out string) []string {
+	args := append(append([]string{}, ffquiet...),
+		"-ss", formatSeconds(p.start(duration)), "-t", formatSeconds(p.duration()), "-i", input,
+		"-map", "0:v:0", "-an", "-vf", withScale([]string{fmt.Sprintf("fps=%d", previewFrameRate)}, p.width()))
+	switch p.format() {
+	case PreviewWebp:
+		args = append(args, "-c:v", "libwebp", "-loop", "0")
+	case PreviewMp4:
+		args = append(args, "-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "+faststart")
+	default:
+		args = append(args, "-loop", "0")
+	}
+	return append(args, out)
+}
+
+// runArtifactCommand runs ffmpeg with args, including its error output in the
+// returned error.
+func runArtifactCommand(ctx context.Context, args []string) error {
+	logger.Infof("calling ffmpeg with args: %#v", args)
+	cmd := exec.CommandContext(ctx, ffmpegbinary, args...)
+	out, err := cmd.CombinedOutput()
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ctx.Err()
+	} else if err != nil {
+		return fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// removeStale deletes the numbered artifacts matching glob left by an earlier
+// run for the same destination, the files matching it afterwards are the ones
+// written by this run.
+func removeStale(glob string) error {
+	stale, err := filepath.Glob(glob)
+	if err != nil {
+		return err
+	}
+	for _, f := range stale {
+		if err := os.Remove(f); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale artifact: %w", err)
+		}
+	}
+	return nil
+}
+
+// GenerateArtifacts writes the requested thumbnails, sprite sheets and preview
+// of destination. Every kind of artifact is attempted, the artifacts that were
+// written are returned along with the errors of those that were not.
+func GenerateArtifacts(ctx context.Context, a Artifacts, destination string) ([]Artifact, error) {
+	inv, err := ProbeSource(ctx, destination)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe %q: %w", destination, err)
+	}
+	duration, err := strconv.ParseFloat(inv.Format.Duration, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unknown duration of %q: %w", destination, err)
+	}
+	dir := a.directory(destination)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+
+	var artifacts []Artifact
+	var errs []error
+	if t := a.Thumbnails; t != nil {
+		for i, ts := range t.Timestamps {
+			if ts >= duration {
+				errs = append(errs, fmt.Errorf("thumbnail timestamp %v is past the end of %q", ts, destination))
+				continue
+			}
+			out := filepath.Join(dir, fmt.Sprintf("thumbnail_%03d.jpg", i+1))
+			if err := runArtifactCommand(ctx, thumbnailArgs(destination, ts, t.Width, out)); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			artifacts = append(artifacts, Artifact{Kind: ArtifactThumbnail, Path: out, Timestamp: ts})
+		}
+		if t.Scene_count > 0 {
+			pattern, glob := filepath.Join(dir, "scene_%03d.jpg"), filepath.Join(dir, "scene_*.jpg")
+			if err := removeStale(glob); err != nil {
+				errs = append(errs, err)
+			} else if err := runArtifactCommand(ctx, sceneThumbnailArgs(destination, t.Scene_count, t.Width, pattern)); err != nil {
+				errs = append(errs, err)
+			} else {
+				scenes, _ := filepath.Glob(glob)
+				for _, s := range scenes {
+					artifacts = append(artifacts, Artifact{Kind: ArtifactThumbnail, Path: s})
+				}
+			}
+		}
+	}
+	if s := a.Sprite; s != nil {
+		glob := filepath.Join(dir, "sprite_*.jpg")
+		if err := removeStale(glob); err != nil {
+			errs = append(errs, err)
+		} else if err := runArtifactCommand(ctx, spriteArgs(destination, *s, filepath.Join(dir, "sprite_%03d.jpg"))); err != nil {
+			errs = append(errs, err)
+		} else {
+			sheets, _ := filepath.Glob(glob)
+			for _, sh := range sheets {
+				artifacts = append(artifacts, Artifact{Kind: ArtifactSprite, Path: sh})
+			}
+			var tileHeight int
+			for _, st := range inv.Streams {
+				if st.Codec_type == "video" && !isAttachedPicture(st) {
+					tileHeight = scaledHeight(s.width(), st.Width, st.Height)
+					break
+				}
+			}
+			index := filepath.Join(dir, "sprite.vtt")
+			if err := os.WriteFile(index, []byte(spriteIndex(*s, tileHeight, duration, sheets)), 0644); err != nil {
+				errs = append(errs, fmt.Errorf("failed to write sprite index: %w", err))
+			} else {
+				artifacts = append(artifacts, Artifact{Kind: ArtifactSpriteIndex, Path: index})
+			}
+		}
+	}
+	if p := a.Preview; p != nil {
+		out := filepath.Join(dir, "preview."+p.format())
+		if err := runArtifactCommand(ctx, previewArgs(destination, *p, duration, out)); err != nil {
+			errs = append(errs, err)
+		} else {
+			artifacts = append(artifacts, Artifact{Kind: ArtifactPreview, Path: out, Timestamp: p.start(duration)})
+		}
+	}
+	return artifacts, errors.Join(errs...)
+}
+
+// String summarises the requested artifacts for display on the status page.
+func (a *Artifacts) String() string {
+	if a == nil {
+		return "none"
+	}
+	var parts []string
+	if t := a.Thumbnails; t != nil {
+		if n := len(t.Timestamps); n > 0 {
+			parts = append(parts, fmt.Sprintf("%d thumbnails", n))
+		}
+		if t.Scene_count > 0 {
+			parts = append(parts, fmt.Sprintf("%d scene thumbnails", t.Scene_count))
+		}
+	}
+	if s := a.Sprite; s != nil {
+		parts = append(parts, fmt.Sprintf("sprite every %ss", formatSeconds(s.interval())))
+	}
+	if p := a.Preview; p != nil {
+		parts = append(parts, fmt.Sprintf("%ss %s preview", formatSeconds(p.duration()), p.format()))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,124 @@
+package ffwrap
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestArtifactsValidate(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		artifacts   Artifacts
+		shouldError bool
+	}{
+		{desc: "thumbnails", artifacts: Artifacts{Thumbnails: &ThumbnailOptions{Timestamps: []float64{0, 90.5}, Scene_count: 4}}},
+		{desc: "sprite defaults", artifacts: Artifacts{Sprite: &SpriteOptions{}}},
+		{desc: "mp4 preview", artifacts: Artifacts{Preview: &PreviewOptions{Format: "MP4", Duration: 5}}},
+		{desc: "nothing requested", artifacts: Artifacts{Directory: "/out"}, shouldError: true},
+		{desc: "empty thumbnails", artifacts: Artifacts{Thumbnails: &ThumbnailOptions{Width: 320}}, shouldError: true},
+		{desc: "negative timestamp", artifacts: Artifacts{Thumbnails: &ThumbnailOptions{Timestamps: []float64{-1}}}, shouldError: true},
+		{desc: "too many scenes", artifacts: Artifacts{Thumbnails: &ThumbnailOptions{Scene_count: 1000}}, shouldError: true},
+		{desc: "negative sprite interval", artifacts: Artifacts{Sprite: &SpriteOptions{Interval: -5}}, shouldError: true},
+		{desc: "unknown preview format", artifacts: Artifacts{Preview: &PreviewOptions{Format: "avi"}}, shouldError: true},
+		{desc: "long preview", artifacts: Artifacts{Preview: &PreviewOptions{Duration: 120}}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.artifacts.validate()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validate() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
+
+func TestArtifactArgs(t *testing.T) {
+	quiet := []string{"-y", "-hide_banner", "-stats", "-loglevel", "error"}
+	testCases := []struct {
+		desc     string
+		got      []string
+		expected []string
+	}{
+		{
+			desc:     "thumbnail",
+			got:      thumbnailArgs("/out.mkv", 90.5, 640, "/art/thumbnail_001.jpg"),
+			expected: append(append([]string{}, quiet...), "-ss", "90.5", "-i", "/out.mkv", "-map", "0:v:0", "-frames:v", "1", "-vf", "scale=640:-2", "-q:v", "2", "/art/thumbnail_001.jpg"),
+		},
+		{
+			desc:     "full size thumbnail",
+			got:      thumbnailArgs("/out.mkv", 0, 0, "/art/thumbnail_001.jpg"),
+			expected: append(append([]string{}, quiet...), "-ss", "0", "-i", "/out.mkv", "-map", "0:v:0", "-frames:v", "1", "-q:v", "2", "/art/thumbnail_001.jpg"),
+		},
+		{
+			desc:     "scene thumbnails",
+			got:      sceneThumbnailArgs("/out.mkv", 5, 320, "/art/scene_%03d.jpg"),
+			expected: append(append([]string{}, quiet...), "-i", "/out.mkv", "-map", "0:v:0", "-vf", "select='gt(scene,0.3)',scale=320:-2", "-fps_mode", "vfr", "-frames:v", "5", "-q:v", "2", "/art/scene_%03d.jpg"),
+		},
+		{
+			desc:     "sprite",
+			got:      spriteArgs("/out.mkv", SpriteOptions{Interval: 2.5, Columns: 5}, "/art/sprite_%03d.jpg"),
+			expected: append(append([]string{}, quiet...), "-i", "/out.mkv", "-map", "0:v:0", "-vf", "fps=1/2.5,scale=160:-2,tile=5x10", "-fps_mode", "vfr", "-q:v", "3", "/art/sprite_%03d.jpg"),
+		},
+		{
+			desc:     "gif preview",
+			got:      previewArgs("/out.mkv", PreviewOptions{}, 600, "/art/preview.gif"),
+			expected: append(append([]string{}, quiet...), "-ss", "60", "-t", "3", "-i", "/out.mkv", "-map", "0:v:0", "-an", "-vf", "fps=12,scale=320:-2", "-loop", "0", "/art/preview.gif"),
+		},
+		{
+			desc: "mp4 preview",
+			got:  previewArgs("/out.mkv", PreviewOptions{Start: 5, Duration: 4, Width: 480, Format: "mp4"}, 600, "/art/preview.mp4"),
+			expected: append(append([]string{}, quiet...), "-ss", "5", "-t", "4", "-i", "/out.mkv", "-map", "0:v:0", "-an", "-vf", "fps=12,scale=480:-2",
+				"-c:v", "libx264", "-pix_fmt", "yuv420p", "-movflags", "+faststart", "/art/preview.mp4"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(tc.expected, tc.got); diff != "" {
+				t.Errorf("%q: unexpected args: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestSpriteIndex(t *testing.T) {
+	s := SpriteOptions{Interval: 10, Columns: 2, Rows: 1, Width: 160}
+	expected := `WEBVTT
+
+00:00:00.000 --> 00:00:10.000
+sprite_001.jpg#xywh=0,0,160,90
+
+00:00:10.000 --> 00:00:20.000
+sprite_001.jpg#xywh=160,0,160,90
+
+00:00:20.000 --> 00:00:25.500
+sprite_002.jpg#xywh=0,0,160,90
+`
+	got := spriteIndex(s, scaledHeight(160, 1920, 1080), 25.5, []string{"/art/sprite_001.jpg", "/art/sprite_002.jpg"})
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Errorf("unexpected sprite index: %s", diff)
+	}
+}
+
+func TestRemoveStale(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"sprite_001.jpg", "sprite_002.jpg", "preview.gif"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := removeStale(filepath.Join(dir, "sprite_*.jpg")); err != nil {
+		t.Fatalf("removeStale() unexpected error: %v", err)
+	}
+	left, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{filepath.Join(dir, "preview.gif")}, left); diff != "" {
+		t.Errorf("unexpected files left: %s", diff)
+	}
+}
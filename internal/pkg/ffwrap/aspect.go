@@ -0,0 +1,104 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+const (
+	// AspectPolicyLargest crops variable aspect sources to the largest frame
+	// so that no scene loses picture.
+	AspectPolicyLargest = "largest"
+	// AspectPolicyDominant crops variable aspect sources to the ratio of the
+	// most frequent crop, cutting the scenes that open up beyond it.
+	AspectPolicyDominant = "dominant"
+	// AspectPolicyFlag holds variable aspect sources for review.
+	AspectPolicyFlag = "flag"
+
+	// aspectVariantShare is the share of frames a crop with another aspect
+	// ratio needs for the source to be treated as variable.
+	aspectVariantShare = 0.05
+	// aspectWidthTolerance is the relative difference in width up to which
+	// two crops are of the same picture.
+	aspectWidthTolerance = 0.02
+	// aspectHeightChange is the relative difference in height from which two
+	// crops of the same picture are of different aspect ratios.
+	aspectHeightChange = 0.05
+)
+
+// CropSegment is the crop dominating the window of the source starting at
+// Start seconds, and the display aspect ratio of the cropped picture.
+type CropSegment struct {
+	Start  float64 `json:"start"`
+	Filter string  `json:"filter"`
+	Aspect float64 `json:"aspect,omitempty"`
+}
+
+// String describes the segment for display on the status page.
+func (s CropSegment) String() string {
+	return fmt.Sprintf("%s %.2f:1 (%s)", formatVttTime(s.Start), s.Aspect, s.Filter)
+}
+
+// aspectVariant reports whether o is the picture of r with another aspect
+// ratio: as wide but noticeably taller or shorter, as when the picture of a
+// film opens up for scenes shot in another format.
+func (r cropRect) aspectVariant(o cropRect) bool {
+	if r.w <= 0 || r.h <= 0 {
+		return false
+	}
+	dw := math.Abs(float64(o.w-r.w)) / float64(r.w)
+	dh := math.Abs(float64(o.h-r.h)) / float64(r.h)
+	return dw <= aspectWidthTolerance && dh >= aspectHeightChange
+}
+
+// aspect returns the display aspect ratio of the rect with the given sample
+// aspect ratio, rounded to two decimals.
+func (r cropRect) aspect(sar float64) float64 {
+	if r.h <= 0 {
+		return 0
+	}
+	if sar <= 0 {
+		sar = 1
+	}
+	return math.Round(float64(r.w)*sar/float64(r.h)*100) / 100
+}
+
+// mergeTimeline sets the aspect ratio of every segment and merges consecutive
+// segments of the same ratio into the first of them.
+func mergeTimeline(timeline []CropSegment, sar float64) []CropSegment {
+	var merged []CropSegment
+	for _, s := range timeline {
+		r, err := parseCrop(s.Filter)
+		if err != nil {
+			continue
+		}
+		s.Aspect = r.aspect(sar)
+		if len(merged) > 0 && merged[len(merged)-1].Aspect == s.Aspect {
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// aspectPolicy returns the aspect policy of the request, largest unless set.
+func (tr TranscodeRequest) aspectPolicy() string {
+	if tr.Aspect_policy == "" {
+		return AspectPolicyLargest
+	}
+	return strings.ToLower(tr.Aspect_policy)
+}
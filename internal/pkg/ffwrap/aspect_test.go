@@ -0,0 +1,51 @@
+package ffwrap
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeTimeline(t *testing.T) {
+	timeline := []CropSegment{
+		{Start: 30, Filter: "crop=1920:800:0:140"},
+		{Start: 330, Filter: "crop=1920:802:0:138"},
+		{Start: 630, Filter: "crop=1920:1008:0:36"},
+		{Start: 930, Filter: "crop=1920:1008:0:36"},
+		{Start: 1230, Filter: "crop=1920:800:0:140"},
+	}
+	want := []CropSegment{
+		{Start: 30, Filter: "crop=1920:800:0:140", Aspect: 2.4},
+		{Start: 330, Filter: "crop=1920:802:0:138", Aspect: 2.39},
+		{Start: 630, Filter: "crop=1920:1008:0:36", Aspect: 1.9},
+		{Start: 1230, Filter: "crop=1920:800:0:140", Aspect: 2.4},
+	}
+	if diff := cmp.Diff(want, mergeTimeline(timeline, 1)); diff != "" {
+		t.Errorf("unexpected timeline: %s", diff)
+	}
+	if got, want := want[2].String(), "00:10:30.000 1.90:1 (crop=1920:1008:0:36)"; got != want {
+		t.Errorf("CropSegment.String() = %q, want %q", got, want)
+	}
+}
+
+func TestAspectVariant(t *testing.T) {
+	scope := cropRect{w: 1920, h: 800, x: 0, y: 140}
+	testCases := []struct {
+		desc     string
+		crop     cropRect
+		expected bool
+	}{
+		{desc: "same crop", crop: scope},
+		{desc: "opened up", crop: cropRect{w: 1920, h: 1008, y: 36}, expected: true},
+		{desc: "dark scene", crop: cropRect{w: 1520, h: 480, x: 200, y: 300}},
+		{desc: "few lines", crop: cropRect{w: 1916, h: 810, x: 2, y: 134}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if got := scope.aspectVariant(tc.crop); got != tc.expected {
+				t.Errorf("%q: aspectVariant() = %v, want %v", tc.desc, got, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// audioEncoders maps the audio codec names accepted in requests to ffmpeg encoders.
+	audioEncoders = map[string]string{
+		"copy": "copy",
+		"opus": "libopus",
+		"aac":  "aac",
+		"ac3":  "ac3",
+		"eac3": "eac3",
+		"flac": "flac",
+	}
+
+	// channelLayouts maps the accepted channel layouts to their channel count.
+	channelLayouts = map[string]int{
+		"mono":   1,
+		"stereo": 2,
+		"5.1":    6,
+		"7.1":    8,
+	}
+
+	// losslessAudio holds the source codecs matched by the "lossless" rule keyword.
+	losslessAudio = map[string]bool{
+		"truehd": true,
+		"mlp":    true,
+		"flac":   true,
+		"alac":   true,
+		"tta":    true,
+	}
+
+	bitrateRegex = regexp.MustCompile(`^[1-9][0-9]*[kKmM]?$`)
+)
+
+// isLossless reports whether the source audio stream is losslessly compressed.
+func isLossless(s FfprobeStreams) bool {
+	c := strings.ToLower(s.Codec)
+	if losslessAudio[c] || strings.HasPrefix(c, "pcm_") {
+		return true
+	}
+	return c == "dts" && strings.HasPrefix(s.Profile, "DTS-HD MA")
+}
+
+// isCommentary reports whether the source stream is flagged or titled as a commentary track.
+func isCommentary(s FfprobeStreams) bool {
+	return s.Disposition["comment"] == 1 || strings.Contains(strings.ToLower(s.Tags.Title), "commentary")
+}
+
+// matches reports whether every populated criteria of the rule is satisfied by the source stream.
+func (r AudioRule) matches(s FfprobeStreams) bool {
+	if len(r.Match_codecs) > 0 {
+		found := false
+		for _, c := range r.Match_codecs {
+			if strings.EqualFold(c, "lossless") && isLossless(s) || strings.EqualFold(c, s.Codec) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Match_language != "" && !strings.EqualFold(r.Match_language, s.Tags.Language) {
+		return false
+	}
+	if r.Match_commentary && !isCommentary(s) {
+		return false
+	}
+	return true
+}
+
+// settingsFor returns the encoder settings for a source stream, taking the
+// first matching rule or falling back to the top level settings.
+func (a AudioSettings) settingsFor(s FfprobeStreams) AudioRule {
+	for _, r := range a.Rules {
+		if r.matches(s) {
+			return r
+		}
+	}
+	return AudioRule{Codec: a.Codec, Bitrate: a.Bitrate, Channel_layout: a.Channel_layout}
+}
+
+// validate checks the audio settings for unknown codecs, malformed bitrates and
+// filters that could never be applied because every track is copied.
+func (a AudioSettings) validate(filters string) error {
+	encoding := false
+	for _, r := range append([]AudioRule{{Codec: a.Codec, Bitrate: a.Bitrate, Channel_layout: a.Channel_layout}}, a.Rules...) {
+		c := strings.ToLower(r.Codec)
+		if c == "" {
+			c = "copy"
+		}
+		if _, ok := audioEncoders[c]; !ok {
+			return fmt.Errorf("unsupported audio codec %q", r.Codec)
+		}
+		if r.Bitrate != "" && !bitrateRegex.MatchString(r.Bitrate) {
+			return fmt.Errorf("invalid audio bitrate %q", r.Bitrate)
+		}
+		if _, ok := channelLayouts[strings.ToLower(r.Channel_layout)]; r.Channel_layout != "" && !ok {
+			return fmt.Errorf("unsupported channel layout %q", r.Channel_layout)
+		}
+		if c == "copy" && (r.Bitrate != "" || r.Channel_layout != "") {
+			return fmt.Errorf("audio bitrate and channel layout cannot be used with copy")
+		}
+		if c != "copy" {
+			encoding = true
+		}
+	}
+	if filters != "" && !encoding {
+		return fmt.Errorf("audio_filters require a non-copy audio codec")
+	}
+	return nil
+}
+
+// encoderArgs returns the ffmpeg output options for a single audio stream
+// addressed by the stream specifier spec, e.g. "a:1" or "a".
+func (r AudioRule) encoderArgs(spec string, sourceChannels int, filters string) []string {
+	enc, ok := audioEncoders[strings.ToLower(r.Codec)]
+	if !ok || enc == "copy" {
+		return []string{fmt.Sprintf("-c:%s", spec), "copy"}
+	}
+	args := []string{fmt.Sprintf("-c:%s", spec), enc}
+	if r.Bitrate != "" {
+		args = append(args, fmt.Sprintf("-b:%s", spec), r.Bitrate)
+	}
+	channels := sourceChannels
+	if n, ok := channelLayouts[strings.ToLower(r.Channel_layout)]; ok && (sourceChannels == 0 || n < sourceChannels) {
+		args = append(args, fmt.Sprintf("-ac:%s", spec), fmt.Sprintf("%d", n))
+		channels = n
+	}
+	if enc == "libopus" && channels > 2 {
+		// libopus needs the vorbis channel mapping family for surround layouts
+		args = append(args, fmt.Sprintf("-mapping_family:%s", spec), "1")
+	}
+	if filters != "" {
+		args = append(args, fmt.Sprintf("-filter:%s", spec), filters)
+	}
+	return args
+}
+
+// buildAudioArgs generates the per stream audio encoder options for the audio
+// tracks in tracks, which must be in the order they are mapped to the output.
+// Tracks that would be copied into a container that can't store them are
+// re-encoded. When no track information is available the top level settings
+// are applied to every audio stream.
+func buildAudioArgs(tracks []FfprobeStreams, as *AudioSettings, filters, container string) []string {
+	if as == nil {
+		as = &AudioSettings{Codec: "copy"}
+	}
+	if len(tracks) == 0 {
+		return as.settingsFor(FfprobeStreams{}).encoderArgs("a", 0, filters)
+	}
+	var args []string
+	for i, t := range tracks {
+		r := audioForContainer(as.settingsFor(t), t, container)
+		args = append(args, r.encoderArgs(fmt.Sprintf("a:%d", i), t.Channels, filters)...)
+	}
+	return args
+}
+
+// String summarises the audio settings for display on the status page.
+func (a *AudioSettings) String() string {
+	if a == nil {
+		return "copy"
+	}
+	return summariseAudioRule(AudioRule{Codec: a.Codec, Bitrate: a.Bitrate, Channel_layout: a.Channel_layout}, a.Rules)
+}
+
+func summariseAudioRule(r AudioRule, rules []AudioRule) string {
+	parts := []string{"copy"}
+	if r.Codec != "" {
+		parts[0] = r.Codec
+	}
+	if r.Bitrate != "" {
+		parts = append(parts, r.Bitrate)
+	}
+	if r.Channel_layout != "" {
+		parts = append(parts, r.Channel_layout)
+	}
+	if len(rules) > 0 {
+		parts = append(parts, fmt.Sprintf("(+%d rules)", len(rules)))
+	}
+	return strings.Join(parts, " ")
+}
+
+// validate checks the audio file for a usable path, codec and language.
+func (af AudioFile) validate() error {
+	if af.Path == "" {
+		return fmt.Errorf("audio file path cannot be empty")
+	}
+	if err := (AudioSettings{Codec: af.Codec, Bitrate: af.Bitrate}).validate(""); err != nil {
+		return fmt.Errorf("audio file %q: %w", af.Path, err)
+	}
+	if af.Language != "" && !languageRegex.MatchString(strings.ToLower(af.Language)) {
+		return fmt.Errorf("invalid language %q for audio file %q", af.Language, af.Path)
+	}
+	return nil
+}
+
+// language returns the language tag of the audio file, undetermined when unset.
+func (af AudioFile) language() string {
+	if af.Language == "" {
+		return LanguageUndetermined
+	}
+	return strings.ToLower(af.Language)
+}
+
+// inputArgs returns the ffmpeg input options that add the audio file.
+func (af AudioFile) inputArgs() []string {
+	var args []string
+	if af.Delay != 0 {
+		args = append(args, "-itsoffset", strconv.FormatFloat(af.Delay, 'f', -1, 64))
+	}
+	return append(args, "-i", af.Path)
+}
+
+// outputArgs returns the map, encoder and metadata options for the audio file
+// read from input number input and written as output audio stream index.
+func (af AudioFile) outputArgs(input, index int, filters string) []string {
+	args := []string{"-map", fmt.Sprintf("%d:a:0", input)}
+	args = append(args, AudioRule{Codec: af.Codec, Bitrate: af.Bitrate}.encoderArgs(fmt.Sprintf("a:%d", index), 0, filters)...)
+	args = append(args, fmt.Sprintf("-metadata:s:a:%d", index), fmt.Sprintf("language=%s", af.language()))
+	if af.Title != "" {
+		args = append(args, fmt.Sprintf("-metadata:s:a:%d", index), fmt.Sprintf("title=%s", af.Title))
+	}
+	return args
+}
+
+// String summarises the audio file for display on the status page.
+func (af AudioFile) String() string {
+	details := []string{af.language()}
+	if af.Title != "" {
+		details = append(details, strconv.Quote(af.Title))
+	}
+	if af.Codec != "" {
+		details = append(details, af.Codec)
+	}
+	if af.Default {
+		details = append(details, "default")
+	}
+	if af.Delay != 0 {
+		details = append(details, fmt.Sprintf("delay %+gs", af.Delay))
+	}
+	return fmt.Sprintf("%s (%s)", af.Path, strings.Join(details, ", "))
+}
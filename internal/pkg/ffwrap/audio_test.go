@@ -0,0 +1,162 @@
+package ffwrap
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	truehdTrack = FfprobeStreams{Index: 1, Codec: "truehd", Codec_type: "audio", Channels: 8, Tags: FfprobeTags{Language: "eng"}}
+	dtsMaTrack  = FfprobeStreams{Index: 2, Codec: "dts", Profile: "DTS-HD MA", Codec_type: "audio", Channels: 6, Tags: FfprobeTags{Language: "eng"}}
+	ac3Track    = FfprobeStreams{Index: 3, Codec: "ac3", Codec_type: "audio", Channels: 6, Tags: FfprobeTags{Language: "eng"}}
+	commentary  = FfprobeStreams{Index: 4, Codec: "ac3", Codec_type: "audio", Channels: 2, Tags: FfprobeTags{Language: "eng", Title: "Director's Commentary"}}
+)
+
+func TestBuildAudioArgs(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		tracks    []FfprobeStreams
+		settings  *AudioSettings
+		filters   string
+		container string
+		expected  []string
+	}{
+		{
+			desc:     "no settings copies everything",
+			tracks:   []FfprobeStreams{truehdTrack, ac3Track},
+			expected: []string{"-c:a:0", "copy", "-c:a:1", "copy"},
+		},
+		{
+			desc:     "no track information applies top level settings",
+			settings: &AudioSettings{Codec: "aac", Bitrate: "192k", Channel_layout: "stereo"},
+			filters:  "loudnorm",
+			expected: []string{"-c:a", "aac", "-b:a", "192k", "-ac:a", "2", "-filter:a", "loudnorm"},
+		},
+		{
+			desc:     "surround opus uses mapping family",
+			tracks:   []FfprobeStreams{ac3Track},
+			settings: &AudioSettings{Codec: "opus", Bitrate: "256k"},
+			expected: []string{"-c:a:0", "libopus", "-b:a:0", "256k", "-mapping_family:a:0", "1"},
+		},
+		{
+			desc:     "downmix skipped when source has fewer channels",
+			tracks:   []FfprobeStreams{commentary},
+			settings: &AudioSettings{Codec: "eac3", Channel_layout: "5.1"},
+			expected: []string{"-c:a:0", "eac3"},
+		},
+		{
+			desc:   "lossless kept and commentary transcoded",
+			tracks: []FfprobeStreams{truehdTrack, dtsMaTrack, ac3Track, commentary},
+			settings: &AudioSettings{
+				Codec: "eac3",
+				Rules: []AudioRule{
+					{Match_codecs: []string{"lossless"}, Codec: "copy"},
+					{Match_commentary: true, Codec: "opus", Bitrate: "96k"},
+				},
+			},
+			filters: "dynaudnorm",
+			expected: []string{
+				"-c:a:0", "copy",
+				"-c:a:1", "copy",
+				"-c:a:2", "eac3", "-filter:a:2", "dynaudnorm",
+				"-c:a:3", "libopus", "-b:a:3", "96k", "-filter:a:3", "dynaudnorm",
+			},
+		},
+		{
+			desc:      "copied truehd re-encoded for mp4",
+			tracks:    []FfprobeStreams{truehdTrack, ac3Track},
+			container: ContainerMp4,
+			expected:  []string{"-c:a:0", "eac3", "-c:a:1", "copy"},
+		},
+		{
+			desc:      "copied audio re-encoded for webm",
+			tracks:    []FfprobeStreams{ac3Track},
+			container: ContainerWebm,
+			expected:  []string{"-c:a:0", "libopus", "-mapping_family:a:0", "1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			result := buildAudioArgs(tc.tracks, tc.settings, tc.filters, tc.container)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected audio args: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestAudioSettingsValidate(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		settings    AudioSettings
+		filters     string
+		shouldError bool
+	}{
+		{desc: "copy", settings: AudioSettings{Codec: "copy"}},
+		{desc: "empty codec is copy", settings: AudioSettings{}},
+		{desc: "valid encode", settings: AudioSettings{Codec: "opus", Bitrate: "128k", Channel_layout: "stereo"}, filters: "loudnorm"},
+		{desc: "unknown codec", settings: AudioSettings{Codec: "mp3"}, shouldError: true},
+		{desc: "bad bitrate", settings: AudioSettings{Codec: "aac", Bitrate: "fast"}, shouldError: true},
+		{desc: "bad layout", settings: AudioSettings{Codec: "aac", Channel_layout: "quad"}, shouldError: true},
+		{desc: "bitrate with copy", settings: AudioSettings{Codec: "copy", Bitrate: "128k"}, shouldError: true},
+		{desc: "filters with copy", settings: AudioSettings{Codec: "copy"}, filters: "loudnorm", shouldError: true},
+		{
+			desc:     "filters with encoding rule",
+			settings: AudioSettings{Codec: "copy", Rules: []AudioRule{{Match_commentary: true, Codec: "opus"}}},
+			filters:  "loudnorm",
+		},
+		{
+			desc:        "invalid rule codec",
+			settings:    AudioSettings{Codec: "copy", Rules: []AudioRule{{Match_commentary: true, Codec: "vorbis"}}},
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.settings.validate(tc.filters)
+			if err == nil && tc.shouldError {
+				t.Errorf("%q: expected error but got nil", tc.desc)
+			}
+			if err != nil && !tc.shouldError {
+				t.Errorf("%q: got error: %v want: nil", tc.desc, err)
+			}
+		})
+	}
+}
+
+func TestAudioFileValidate(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		file        AudioFile
+		shouldError bool
+	}{
+		{desc: "copy", file: AudioFile{Path: "/dub.ac3", Language: "ger"}},
+		{desc: "encode", file: AudioFile{Path: "/dub.flac", Codec: "opus", Bitrate: "128k", Delay: -0.2}},
+		{desc: "missing path", file: AudioFile{Language: "ger"}, shouldError: true},
+		{desc: "unknown codec", file: AudioFile{Path: "/dub.ac3", Codec: "mp3"}, shouldError: true},
+		{desc: "bitrate with copy", file: AudioFile{Path: "/dub.ac3", Bitrate: "128k"}, shouldError: true},
+		{desc: "bad language", file: AudioFile{Path: "/dub.ac3", Language: "german"}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.file.validate()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validate() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
+
+func TestAudioFilesRequireInventory(t *testing.T) {
+	tr := TranscodeRequest{Source: "/src.mkv", Destination: "/dst.mkv", Codec: "copy", Audio_files: []AudioFile{{Path: "/dub.ac3"}}}
+	if _, err := FfmpegTranscode(context.Background(), tr, &FfprobeOutput{}); err == nil {
+		t.Errorf("FfmpegTranscode() mapped audio files without an inventory of the source")
+	}
+}
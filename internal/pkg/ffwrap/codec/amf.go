@@ -1,11 +1,31 @@
 package codec
 
-func buildAv1Amf() []string {
-	return []string{
+import "fmt"
+
+// buildAv1Amf returns the options of av1_amf at a constant quantizer mapped
+// from a crf on the 0-63 scale of the software AV1 encoders. AMF reads the 10
+// bit frames from memory and writes the color description set on the stream.
+func buildAv1Amf(crf int, colorMeta ColorInfo) []string {
+	qp := fmt.Sprintf("%d", av1Qindex(crf))
+	amf := []string{
 		"-c:v", "av1_amf",
 		"-quality", "quality",
-		"-vbaq", "true",
+		"-rc", "cqp",
+		"-qp_i", qp,
+		"-qp_p", qp,
 		"-bitdepth", "10",
 		"-pix_fmt", "p010le",
 	}
+	return append(amf, colorArgs(colorMeta)...)
+}
+
+// av1AmfEncoder is the AMD hardware AV1 encoder writing 10 bit video.
+type av1AmfEncoder struct{}
+
+func (av1AmfEncoder) Info() EncoderInfo {
+	return EncoderInfo{Name: "av1_amf", Pixel_formats: []string{"p010le"}}
+}
+
+func (av1AmfEncoder) Args(o Options) []string {
+	return buildAv1Amf(o.Crf, o.Color)
 }
@@ -5,6 +5,7 @@ import "strings"
 const (
 	SideDataTypeMastering  = "Mastering display metadata"
 	SideDataTypeLightLevel = "Content light level metadata"
+	SideDataTypeHdr10Plus  = "HDR Dynamic Metadata SMPTE2094-40 (HDR10+)"
 )
 
 type ColorCoords struct {
@@ -34,32 +35,104 @@ type ColorSideInfo struct {
 	Max_average    int    `json:"Max_average"`
 }
 
-// BuildCodec generates command line arguments for ffmpeg based on the specified codec type and CRF value, with optional color metadata.
-// It supports various codecs including libx265, libsvtav1, hevc_nvenc, and can handle specific configurations based on the provided CRF value and color metadata.
-// The function will always return a valid set of ffmpeg flags for a given codec, if an unrecognized codec is passed then a default libx265 arg slice will be built and returned.
-func BuildCodec(codec string, crf int, colorMeta ColorInfo) []string {
-	switch strings.ToLower(codec) {
-	case "copy":
-		return []string{"-c:v", "copy"}
-	case "hevc_nvenc":
-		return buildNvencHevc(crf)
-	case "libsvtav1":
-		return buildLibSvtAv1("none", crf, colorMeta)
-	case "libsvtav1_grain:low":
-		return buildLibSvtAv1("low", crf, colorMeta)
-	case "libsvtav1_grain:medium":
-		return buildLibSvtAv1("medium", crf, colorMeta)
-	case "libsvtav1_grain:high":
-		return buildLibSvtAv1("high", crf, colorMeta)
-	case "libx265_animation":
-		return buildLibx265("animation", crf, colorMeta)
-	case "libx265_grain":
-		return buildLibx265("grain", crf, colorMeta)
-	case "libx265":
-		return buildLibx265("none", crf, colorMeta)
-	case "av1_amf":
-		return buildAv1Amf()
-	default:
-		return buildLibx265("none", crf, colorMeta)
+const (
+	// maxCrf is the highest crf of the AV1 and VP9 encoders.
+	maxCrf = 63
+	// maxAv1Qindex is the highest quantizer index of AV1.
+	maxAv1Qindex = 255
+	// maxHevcQp is the highest quantizer of HEVC, the upper bound of the
+	// constant quality of the hardware encoders.
+	maxHevcQp = 51
+)
+
+// av1Qindex maps a crf on the 0-63 scale of the software AV1 encoders to the
+// 0-255 quantizer index of encoders taking it directly.
+func av1Qindex(crf int) int {
+	return scaleQuality(crf, maxCrf, 0, maxAv1Qindex)
+}
+
+// scaleQuality maps a crf on a 0-from scale to the lo-hi scale of an encoder's
+// quality option, clamping it to the range of both.
+func scaleQuality(crf, from, lo, hi int) int {
+	crf = min(max(crf, 0), from)
+	return max((crf*hi+from/2)/from, lo)
+}
+
+// bitDepthParam is the bit depth parameter of the encoders writing 8 or 10 bit
+// video. Auto writes 10 bit for PQ and HLG outputs and 8 bit otherwise.
+func bitDepthParam(values []string, def string) Param {
+	return Param{Name: "bit_depth", Description: "bit depth of the output", Values: values, Default: def}
+}
+
+// bitDepth returns the bit depth set by the bit_depth parameter of an encoder
+// for an output of the given color.
+func bitDepth(param string, colorMeta ColorInfo) int {
+	switch param {
+	case "8":
+		return 8
+	case "10":
+		return 10
+	}
+	if hdrTransfer(colorMeta) {
+		return 10
+	}
+	return 8
+}
+
+// hdrTransfer reports whether the color description is of PQ or HLG video.
+func hdrTransfer(colorMeta ColorInfo) bool {
+	switch strings.ToLower(colorMeta.Color_transfer) {
+	case "smpte2084", "arib-std-b67":
+		return true
+	}
+	return false
+}
+
+// yuv420 returns the planar 4:2:0 pixel format of a bit depth.
+func yuv420(depth int) string {
+	if depth == 10 {
+		return "yuv420p10le"
+	}
+	return "yuv420p"
+}
+
+// colorArgs returns the options writing the color description of the output
+// into the stream, for encoders that take it from ffmpeg.
+func colorArgs(colorMeta ColorInfo) []string {
+	var args []string
+	if colorMeta.Color_space != "" {
+		args = append(args, "-colorspace", colorMeta.Color_space)
+	}
+	if colorMeta.Color_primaries != "" {
+		args = append(args, "-color_primaries:v", colorMeta.Color_primaries)
+	}
+	if colorMeta.Color_transfer != "" {
+		args = append(args, "-color_trc:v", colorMeta.Color_transfer)
+	}
+	return args
+}
+
+// BuildCodec generates the ffmpeg options of the encoder registered under
+// codec for the given CRF value and color metadata. Names are case insensitive
+// and aliases set parameters of their encoder, e.g. libsvtav1_grain:medium.
+// Unknown codecs return an error wrapping ErrUnknownEncoder.
+func BuildCodec(codec string, crf int, colorMeta ColorInfo) ([]string, error) {
+	e, params, err := Lookup(codec)
+	if err != nil {
+		return nil, err
+	}
+	return e.Args(Options{Crf: crf, Params: params, Color: colorMeta}), nil
+}
+
+// Hardware returns the device options and the upload filter of the encoder
+// registered under codec, none for encoders reading frames from memory.
+func Hardware(codec string) (device []string, upload string) {
+	e, _, err := Lookup(codec)
+	if err != nil {
+		return nil, ""
+	}
+	if h, ok := e.(HardwareEncoder); ok {
+		return h.Device(), h.Upload()
 	}
+	return nil, ""
 }
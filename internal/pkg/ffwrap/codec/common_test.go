@@ -2,6 +2,8 @@ package codec
 
 import (
 	"testing"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 // Vars used for tests
@@ -12,6 +14,12 @@ var (
 		Color_transfer:  "bt709",
 		Side_data_list:  []ColorSideInfo{validLightColorInfo, validMasteringColorInfo}}
 
+	hdr10ColorMetaData = ColorInfo{
+		Color_space:     "bt2020nc",
+		Color_primaries: "bt2020",
+		Color_transfer:  "smpte2084",
+		Side_data_list:  []ColorSideInfo{validMasteringColorInfo, validLightColorInfo}}
+
 	validMasteringColorInfo = ColorSideInfo{
 		Side_data_type: SideDataTypeMastering,
 		Red_x:          "30/100",
@@ -59,11 +67,12 @@ var (
 
 // Define a struct for each test case, including input and expected output
 type buildCodecTestCase struct {
-	desc      string
-	codec     string
-	crf       int
-	colorMeta ColorInfo
-	expected  []string
+	desc        string
+	codec       string
+	crf         int
+	colorMeta   ColorInfo
+	expected    []string
+	shouldError bool
 }
 
 func TestBuildCodec(t *testing.T) {
@@ -77,6 +86,7 @@ func TestBuildCodec(t *testing.T) {
 		{
 			desc:  "hevc_nvenc -- sdr",
 			codec: "hevc_nvenc",
+			crf:   20,
 			colorMeta: ColorInfo{
 				Color_space:     "bt709",
 				Color_primaries: "bt709",
@@ -87,19 +97,23 @@ func TestBuildCodec(t *testing.T) {
 				"-pix_fmt", "p010le",
 				"-c:v", "hevc_nvenc",
 				"-rc", "1",
-				"-cq", "0",
+				"-cq", "20",
+				"-b:v", "0",
 				"-profile:v", "1",
 				"-tier", "1",
 				"-spatial_aq", "1",
 				"-temporal_aq", "1",
 				"-preset", "1",
 				"-b_ref_mode", "2",
+				"-colorspace", "bt709",
+				"-color_primaries:v", "bt709",
+				"-color_trc:v", "srgb",
 			},
 		},
 		{
-			// note that the current implementation of nvenc here does not support hdr
 			desc:  "hevc_nvenc -- hdr",
 			codec: "hevc_nvenc",
+			crf:   20,
 			colorMeta: ColorInfo{
 				Color_space:     "bt709",
 				Color_primaries: "bt709",
@@ -113,13 +127,17 @@ func TestBuildCodec(t *testing.T) {
 				"-pix_fmt", "p010le",
 				"-c:v", "hevc_nvenc",
 				"-rc", "1",
-				"-cq", "0",
+				"-cq", "20",
+				"-b:v", "0",
 				"-profile:v", "1",
 				"-tier", "1",
 				"-spatial_aq", "1",
 				"-temporal_aq", "1",
 				"-preset", "1",
 				"-b_ref_mode", "2",
+				"-colorspace", "bt709",
+				"-color_primaries:v", "bt709",
+				"-color_trc:v", "srgb",
 			},
 		},
 		{
@@ -460,36 +478,26 @@ func TestBuildCodec(t *testing.T) {
 			expected: []string{
 				"-c:v", "av1_amf",
 				"-quality", "quality",
-				"-vbaq", "true",
+				"-rc", "cqp",
+				"-qp_i", "93",
+				"-qp_p", "93",
 				"-bitdepth", "10",
 				"-pix_fmt", "p010le",
+				"-colorspace", "bt709",
+				"-color_primaries:v", "bt709",
+				"-color_trc:v", "srgb",
 			},
 		},
 		{
-			desc:  "default case, unknown codec",
+			desc:  "unknown codec rejected",
 			codec: "this is never going to resolve to a real codec I hope",
 			crf:   23,
 			colorMeta: ColorInfo{
 				Color_space:     "bt709",
 				Color_primaries: "bt709",
 				Color_transfer:  "srgb",
-				Side_data_list: []ColorSideInfo{
-					validMasteringColorInfo,
-					validLightColorInfo,
-				},
-			},
-			expected: []string{
-				"-c:v", "libx265",
-				"-crf", "23",
-				"-preset", "medium",
-				"-profile:v", "main10",
-				"-color_trc:v", "srgb",
-				"-color_primaries:v", "bt709",
-				"-colorspace", "bt709",
-				"-x265-params",
-				"hdr-opt=1:repeat-headers=1:colormatrix=bt709:colorprim=bt709:transfer=srgb:master-display=G(20000,20000)B(10000,10000)R(15000,15000)WP(45000,50000)L(10000,0):content-light=700,200",
-				"-pix_fmt", "yuv420p10le",
 			},
+			shouldError: true,
 		},
 	}
 
@@ -497,7 +505,10 @@ func TestBuildCodec(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.desc, func(t *testing.T) {
 			t.Parallel()
-			result := BuildCodec(tc.codec, tc.crf, tc.colorMeta)
+			result, err := BuildCodec(tc.codec, tc.crf, tc.colorMeta)
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: BuildCodec() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
 			if len(result) != len(tc.expected) {
 				t.Errorf("Expected length of output to be %d, but got %d, %#v", len(tc.expected), len(result), result)
 			} else {
@@ -510,3 +521,72 @@ func TestBuildCodec(t *testing.T) {
 		})
 	}
 }
+
+func TestAv1Qindex(t *testing.T) {
+	testCases := []struct {
+		crf int
+		qp  int
+	}{
+		{crf: 0, qp: 0},
+		{crf: 18, qp: 73},
+		{crf: 30, qp: 121},
+		{crf: 63, qp: 255},
+		{crf: 70, qp: 255},
+		{crf: -1, qp: 0},
+	}
+	for _, tc := range testCases {
+		if got := av1Qindex(tc.crf); got != tc.qp {
+			t.Errorf("av1Qindex(%d) = %d, want %d", tc.crf, got, tc.qp)
+		}
+	}
+}
+
+func TestScaleQuality(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		crf      int
+		from     int
+		lo, hi   int
+		expected int
+	}{
+		{desc: "hevc crf kept", crf: 22, from: maxHevcQp, lo: 1, hi: maxHevcQp, expected: 22},
+		{desc: "zero raised to the lowest quality option", crf: 0, from: maxHevcQp, lo: 1, hi: maxHevcQp, expected: 1},
+		{desc: "av1 crf scaled to hevc", crf: 30, from: maxCrf, lo: 1, hi: maxHevcQp, expected: 24},
+		{desc: "above the scale", crf: 80, from: maxCrf, lo: 1, hi: maxHevcQp, expected: 51},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if got := scaleQuality(tc.crf, tc.from, tc.lo, tc.hi); got != tc.expected {
+				t.Errorf("%q: scaleQuality(%d, %d, %d, %d) = %d, want %d", tc.desc, tc.crf, tc.from, tc.lo, tc.hi, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHardware(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		codec  string
+		device []string
+		upload string
+	}{
+		{desc: "vaapi", codec: "hevc_vaapi", device: []string{"-init_hw_device", "vaapi=va:/dev/dri/renderD128", "-filter_hw_device", "va"}, upload: "format=p010le,hwupload"},
+		{desc: "qsv", codec: "AV1_QSV", device: []string{"-init_hw_device", "qsv=qs", "-filter_hw_device", "qs"}, upload: "format=p010le,hwupload=extra_hw_frames=64,format=qsv"},
+		{desc: "nvenc reads frames from memory", codec: "av1_nvenc"},
+		{desc: "software", codec: "libx265"},
+		{desc: "unknown", codec: "libx266"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			device, upload := Hardware(tc.codec)
+			if diff := cmp.Diff(tc.device, device); diff != "" {
+				t.Errorf("%q: unexpected device options: %s", tc.desc, diff)
+			}
+			if upload != tc.upload {
+				t.Errorf("%q: Hardware(%q) upload = %q, want %q", tc.desc, tc.codec, upload, tc.upload)
+			}
+		})
+	}
+}
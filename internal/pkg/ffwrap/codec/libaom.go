@@ -0,0 +1,36 @@
+package codec
+
+import "fmt"
+
+// buildLibaomAv1 returns the options of libaom-av1 in constant quality mode,
+// which takes a crf with the bitrate set to 0. AV1 main profile covers 8 and
+// 10 bit video. ffmpeg passes the color description to libaom but not the
+// mastering display and content light level of HDR sources.
+func buildLibaomAv1(depth string, crf int, colorMeta ColorInfo) []string {
+	bits := bitDepth(depth, colorMeta)
+	libaom := []string{
+		"-c:v", "libaom-av1",
+		"-crf", fmt.Sprintf("%d", crf),
+		"-b:v", "0",
+		"-cpu-used", "4",
+		"-row-mt", "1",
+	}
+	libaom = append(libaom, colorArgs(colorMeta)...)
+	return append(libaom, "-pix_fmt", yuv420(bits))
+}
+
+// libaomAv1Encoder is the AV1 reference encoder, writing 10 bit video unless
+// set to 8 bit.
+type libaomAv1Encoder struct{}
+
+func (libaomAv1Encoder) Info() EncoderInfo {
+	return EncoderInfo{
+		Name:          "libaom-av1",
+		Pixel_formats: []string{"yuv420p10le", "yuv420p"},
+		Params:        []Param{bitDepthParam([]string{"8", "10"}, "10")},
+	}
+}
+
+func (libaomAv1Encoder) Args(o Options) []string {
+	return buildLibaomAv1(o.Params["bit_depth"], o.Crf, o.Color)
+}
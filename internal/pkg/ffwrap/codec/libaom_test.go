@@ -0,0 +1,37 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildLibaomAv1(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		depth     string
+		colorMeta ColorInfo
+		expected  []string
+	}{
+		{
+			desc:      "HDR at 10 bit without static metadata",
+			depth:     "10",
+			colorMeta: hdr10ColorMetaData,
+			expected:  []string{"-c:v", "libaom-av1", "-crf", "28", "-b:v", "0", "-cpu-used", "4", "-row-mt", "1", "-colorspace", "bt2020nc", "-color_primaries:v", "bt2020", "-color_trc:v", "smpte2084", "-pix_fmt", "yuv420p10le"},
+		},
+		{
+			desc:     "8 bit without color metadata",
+			depth:    "8",
+			expected: []string{"-c:v", "libaom-av1", "-crf", "28", "-b:v", "0", "-cpu-used", "4", "-row-mt", "1", "-pix_fmt", "yuv420p"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			result := buildLibaomAv1(tc.depth, 28, tc.colorMeta)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected result: %s", tc.desc, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package codec
+
+import "fmt"
+
+// buildLibrav1e returns the options of librav1e, which has no crf and runs at
+// a constant quantizer. ffmpeg passes the color description to rav1e but not
+// the mastering display and content light level of HDR sources.
+func buildLibrav1e(depth string, crf int, colorMeta ColorInfo) []string {
+	bits := bitDepth(depth, colorMeta)
+	librav1e := []string{
+		"-c:v", "librav1e",
+		"-qp", fmt.Sprintf("%d", av1Qindex(crf)),
+		"-speed", "6",
+	}
+	librav1e = append(librav1e, colorArgs(colorMeta)...)
+	return append(librav1e, "-pix_fmt", yuv420(bits))
+}
+
+// librav1eEncoder is the rav1e AV1 encoder, writing 10 bit video unless set to
+// 8 bit.
+type librav1eEncoder struct{}
+
+func (librav1eEncoder) Info() EncoderInfo {
+	return EncoderInfo{
+		Name:          "librav1e",
+		Pixel_formats: []string{"yuv420p10le", "yuv420p"},
+		Params:        []Param{bitDepthParam([]string{"8", "10"}, "10")},
+	}
+}
+
+func (librav1eEncoder) Args(o Options) []string {
+	return buildLibrav1e(o.Params["bit_depth"], o.Crf, o.Color)
+}
@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildLibrav1e(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		depth     string
+		colorMeta ColorInfo
+		expected  []string
+	}{
+		{
+			desc:      "HDR at 10 bit without static metadata",
+			depth:     "10",
+			colorMeta: hdr10ColorMetaData,
+			expected:  []string{"-c:v", "librav1e", "-qp", "121", "-speed", "6", "-colorspace", "bt2020nc", "-color_primaries:v", "bt2020", "-color_trc:v", "smpte2084", "-pix_fmt", "yuv420p10le"},
+		},
+		{
+			desc:      "SDR at 8 bit",
+			depth:     "8",
+			colorMeta: ColorInfo{Color_space: "bt709", Color_primaries: "bt709", Color_transfer: "bt709"},
+			expected:  []string{"-c:v", "librav1e", "-qp", "121", "-speed", "6", "-colorspace", "bt709", "-color_primaries:v", "bt709", "-color_trc:v", "bt709", "-pix_fmt", "yuv420p"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			result := buildLibrav1e(tc.depth, 30, tc.colorMeta)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected result: %s", tc.desc, diff)
+			}
+		})
+	}
+}
@@ -82,23 +82,10 @@ func parseColorCoordsAv1(csi ColorSideInfo) (ColorCoords, error) {
 	return ColorCoords{g + b + r + wp + lm}, nil
 }
 
-func buildLibSvtAv1(grain string, crf int, colorMeta ColorInfo) []string {
-	libsvtav1 := []string{
-		"-c:v", "libsvtav1",
-		"-crf", fmt.Sprintf("%d", crf),
-		"-preset", "6",
-	}
-
-	svtav1Params := []string{"tune=0:enable-overlays=1:input-depth=10"}
-	if colorMeta.Color_space != "" {
-		libsvtav1 = append(libsvtav1, "-colorspace", colorMeta.Color_space)
-	}
-	if colorMeta.Color_primaries != "" {
-		libsvtav1 = append(libsvtav1, "-color_primaries:v", colorMeta.Color_primaries)
-	}
-	if colorMeta.Color_transfer != "" {
-		libsvtav1 = append(libsvtav1, "-color_trc:v", colorMeta.Color_transfer)
-	}
+// libSvtAv1HDR returns the color options and the svtav1 parameters carrying the
+// color description and HDR metadata of the source.
+func libSvtAv1HDR(colorMeta ColorInfo) (libsvtav1, svtav1Params []string) {
+	libsvtav1 = colorArgs(colorMeta)
 	for _, sd := range colorMeta.Side_data_list {
 		switch strings.ToLower(sd.Side_data_type) {
 		case strings.ToLower(SideDataTypeMastering):
@@ -114,6 +101,20 @@ func buildLibSvtAv1(grain string, crf int, colorMeta ColorInfo) []string {
 		}
 		svtav1Params = append(svtav1Params, "chroma-sample-position=topleft")
 	}
+	return libsvtav1, svtav1Params
+}
+
+func buildLibSvtAv1(grain string, crf int, colorMeta ColorInfo) []string {
+	libsvtav1 := []string{
+		"-c:v", "libsvtav1",
+		"-crf", fmt.Sprintf("%d", crf),
+		"-preset", "6",
+	}
+
+	svtav1Params := []string{"tune=0:enable-overlays=1:input-depth=10"}
+	hdrcolor, svtcolor := libSvtAv1HDR(colorMeta)
+	libsvtav1 = append(libsvtav1, hdrcolor...)
+	svtav1Params = append(svtav1Params, svtcolor...)
 	switch grain {
 	case "low":
 		svtav1Params = append(svtav1Params, "film-grain=5")
@@ -125,3 +126,26 @@ func buildLibSvtAv1(grain string, crf int, colorMeta ColorInfo) []string {
 	libsvtav1 = append(libsvtav1, "-svtav1-params", strings.Join(svtav1Params, ":"))
 	return append(libsvtav1, "-pix_fmt", "yuv420p10le")
 }
+
+// libSvtAv1Encoder is libsvtav1 at preset 6 writing 10 bit video, with the HDR
+// metadata of the source in its svtav1 parameters and optional film grain
+// synthesis.
+type libSvtAv1Encoder struct{}
+
+func (libSvtAv1Encoder) Info() EncoderInfo {
+	return EncoderInfo{
+		Name: "libsvtav1",
+		Aliases: []Alias{
+			{Name: "libsvtav1_grain:low", Params: map[string]string{"grain": "low"}},
+			{Name: "libsvtav1_grain:medium", Params: map[string]string{"grain": "medium"}},
+			{Name: "libsvtav1_grain:high", Params: map[string]string{"grain": "high"}},
+		},
+		Pixel_formats: []string{"yuv420p10le"},
+		Hdr:           true,
+		Params:        []Param{{Name: "grain", Description: "strength of the synthesized film grain", Values: []string{"none", "low", "medium", "high"}, Default: "none"}},
+	}
+}
+
+func (libSvtAv1Encoder) Args(o Options) []string {
+	return buildLibSvtAv1(o.Params["grain"], o.Crf, o.Color)
+}
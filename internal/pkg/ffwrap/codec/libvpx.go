@@ -0,0 +1,42 @@
+package codec
+
+import "fmt"
+
+// buildLibvpxVp9 returns the options of libvpx-vp9 in constant quality mode,
+// which takes a crf with the bitrate set to 0. VP9 carries the color
+// description in the stream, profile 2 is its 10 bit profile. libvpx can't
+// write the mastering display and content light level of HDR sources.
+func buildLibvpxVp9(depth string, crf int, colorMeta ColorInfo) []string {
+	bits := bitDepth(depth, colorMeta)
+	profile := "0"
+	if bits == 10 {
+		profile = "2"
+	}
+	libvpx := []string{
+		"-c:v", "libvpx-vp9",
+		"-crf", fmt.Sprintf("%d", crf),
+		"-b:v", "0",
+		"-deadline", "good",
+		"-cpu-used", "2",
+		"-row-mt", "1",
+		"-profile:v", profile,
+	}
+	libvpx = append(libvpx, colorArgs(colorMeta)...)
+	return append(libvpx, "-pix_fmt", yuv420(bits))
+}
+
+// libvpxVp9Encoder is libvpx-vp9 for web delivery, writing 8 bit video unless
+// the output is HDR.
+type libvpxVp9Encoder struct{}
+
+func (libvpxVp9Encoder) Info() EncoderInfo {
+	return EncoderInfo{
+		Name:          "libvpx-vp9",
+		Pixel_formats: []string{"yuv420p", "yuv420p10le"},
+		Params:        []Param{bitDepthParam([]string{"auto", "8", "10"}, "auto")},
+	}
+}
+
+func (libvpxVp9Encoder) Args(o Options) []string {
+	return buildLibvpxVp9(o.Params["bit_depth"], o.Crf, o.Color)
+}
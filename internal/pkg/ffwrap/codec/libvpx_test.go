@@ -0,0 +1,44 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildLibvpxVp9(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		depth     string
+		colorMeta ColorInfo
+		expected  []string
+	}{
+		{
+			desc:      "SDR at 8 bit",
+			depth:     "auto",
+			colorMeta: ColorInfo{Color_space: "bt709", Color_primaries: "bt709", Color_transfer: "bt709"},
+			expected:  []string{"-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "0", "-deadline", "good", "-cpu-used", "2", "-row-mt", "1", "-profile:v", "0", "-colorspace", "bt709", "-color_primaries:v", "bt709", "-color_trc:v", "bt709", "-pix_fmt", "yuv420p"},
+		},
+		{
+			desc:      "HDR at 10 bit without static metadata",
+			depth:     "auto",
+			colorMeta: hdr10ColorMetaData,
+			expected:  []string{"-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "0", "-deadline", "good", "-cpu-used", "2", "-row-mt", "1", "-profile:v", "2", "-colorspace", "bt2020nc", "-color_primaries:v", "bt2020", "-color_trc:v", "smpte2084", "-pix_fmt", "yuv420p10le"},
+		},
+		{
+			desc:      "HDR forced to 8 bit",
+			depth:     "8",
+			colorMeta: ColorInfo{Color_transfer: "arib-std-b67"},
+			expected:  []string{"-c:v", "libvpx-vp9", "-crf", "31", "-b:v", "0", "-deadline", "good", "-cpu-used", "2", "-row-mt", "1", "-profile:v", "0", "-color_trc:v", "arib-std-b67", "-pix_fmt", "yuv420p"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			result := buildLibvpxVp9(tc.depth, 31, tc.colorMeta)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected result: %s", tc.desc, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package codec
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/logger"
+)
+
+// libx264HDR returns the color options and the x264 parameters carrying the
+// color description and HDR metadata of the source. x264 takes the mastering
+// display in the units of x265.
+func libx264HDR(colorMeta ColorInfo) (libx264, x264params []string) {
+	libx264 = colorArgs(colorMeta)
+	for _, sd := range colorMeta.Side_data_list {
+		switch strings.ToLower(sd.Side_data_type) {
+		case strings.ToLower(SideDataTypeMastering):
+			cc, err := parseColorCoords265(sd)
+			if err != nil {
+				logger.Errorf("failed to parse color coordinates: %v", err)
+				continue
+			}
+			x264params = append(x264params, fmt.Sprintf("mastering-display=%s", cc.Coordinates))
+		case strings.ToLower(SideDataTypeLightLevel):
+			x264params = append(x264params, fmt.Sprintf("cll=%d,%d", sd.Max_content, sd.Max_average))
+		}
+	}
+	return libx264, x264params
+}
+
+func buildLibx264(tune, depth string, crf int, colorMeta ColorInfo) []string {
+	bits := bitDepth(depth, colorMeta)
+	profile := "high"
+	if bits == 10 {
+		profile = "high10"
+	}
+	libx264 := []string{
+		"-c:v", "libx264",
+		"-crf", fmt.Sprintf("%d", crf),
+		"-preset", "medium",
+		"-profile:v", profile,
+	}
+
+	switch tune {
+	case "film", "animation", "grain":
+		libx264 = append(libx264, "-tune", tune)
+	}
+
+	hdrcolor, x264params := libx264HDR(colorMeta)
+	libx264 = append(libx264, hdrcolor...)
+	if len(x264params) > 0 {
+		libx264 = append(libx264, "-x264-params", strings.Join(x264params, ":"))
+	}
+	return append(libx264, "-pix_fmt", yuv420(bits))
+}
+
+// libx264Encoder is libx264 at the medium preset for players without HEVC
+// support, writing 8 bit high profile video unless the output is HDR.
+type libx264Encoder struct{}
+
+func (libx264Encoder) Info() EncoderInfo {
+	return EncoderInfo{
+		Name: "libx264",
+		Aliases: []Alias{
+			{Name: "libx264_animation", Params: map[string]string{"tune": "animation"}},
+			{Name: "libx264_grain", Params: map[string]string{"tune": "grain"}},
+		},
+		Pixel_formats: []string{"yuv420p", "yuv420p10le"},
+		Hdr:           true,
+		Params: []Param{
+			{Name: "tune", Description: "x264 tuning for the content", Values: []string{"none", "film", "animation", "grain"}, Default: "none"},
+			bitDepthParam([]string{"auto", "8", "10"}, "auto"),
+		},
+	}
+}
+
+func (libx264Encoder) Args(o Options) []string {
+	return buildLibx264(o.Params["tune"], o.Params["bit_depth"], o.Crf, o.Color)
+}
@@ -0,0 +1,97 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLibx264HDR(t *testing.T) {
+	testCases := []struct {
+		desc          string
+		colorMeta     ColorInfo
+		expectedLib   []string
+		expectedParam []string
+	}{
+		{
+			desc:          "HDR10 metadata",
+			colorMeta:     hdr10ColorMetaData,
+			expectedLib:   []string{"-colorspace", "bt2020nc", "-color_primaries:v", "bt2020", "-color_trc:v", "smpte2084"},
+			expectedParam: []string{"mastering-display=G(20000,20000)B(10000,10000)R(15000,15000)WP(45000,50000)L(10000,0)", "cll=700,200"},
+		},
+		{
+			desc: "Not a number mastering display is skipped",
+			colorMeta: ColorInfo{
+				Color_transfer: "arib-std-b67",
+				Side_data_list: []ColorSideInfo{nanMasteringColorInfo, validLightColorInfo},
+			},
+			expectedLib:   []string{"-color_trc:v", "arib-std-b67"},
+			expectedParam: []string{"cll=700,200"},
+		},
+		{
+			desc:      "No color metadata",
+			colorMeta: ColorInfo{},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			lib, params := libx264HDR(tc.colorMeta)
+			if diff := cmp.Diff(tc.expectedLib, lib); diff != "" {
+				t.Errorf("%q: unexpected color args: %s", tc.desc, diff)
+			}
+			if diff := cmp.Diff(tc.expectedParam, params); diff != "" {
+				t.Errorf("%q: unexpected x264 params: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestBuildLibx264(t *testing.T) {
+	sdr := ColorInfo{Color_space: "bt709", Color_primaries: "bt709", Color_transfer: "bt709"}
+	testCases := []struct {
+		desc      string
+		tune      string
+		depth     string
+		colorMeta ColorInfo
+		expected  []string
+	}{
+		{
+			desc:      "SDR at 8 bit",
+			tune:      "none",
+			depth:     "auto",
+			colorMeta: sdr,
+			expected:  []string{"-c:v", "libx264", "-crf", "20", "-preset", "medium", "-profile:v", "high", "-colorspace", "bt709", "-color_primaries:v", "bt709", "-color_trc:v", "bt709", "-pix_fmt", "yuv420p"},
+		},
+		{
+			desc:      "HDR at 10 bit",
+			tune:      "none",
+			depth:     "auto",
+			colorMeta: hdr10ColorMetaData,
+			expected: []string{"-c:v", "libx264", "-crf", "20", "-preset", "medium", "-profile:v", "high10", "-colorspace", "bt2020nc", "-color_primaries:v", "bt2020", "-color_trc:v", "smpte2084",
+				"-x264-params", "mastering-display=G(20000,20000)B(10000,10000)R(15000,15000)WP(45000,50000)L(10000,0):cll=700,200", "-pix_fmt", "yuv420p10le"},
+		},
+		{
+			desc:      "SDR forced to 10 bit",
+			tune:      "none",
+			depth:     "10",
+			colorMeta: sdr,
+			expected:  []string{"-c:v", "libx264", "-crf", "20", "-preset", "medium", "-profile:v", "high10", "-colorspace", "bt709", "-color_primaries:v", "bt709", "-color_trc:v", "bt709", "-pix_fmt", "yuv420p10le"},
+		},
+		{
+			desc:     "grain tuned without color metadata",
+			tune:     "grain",
+			depth:    "8",
+			expected: []string{"-c:v", "libx264", "-crf", "20", "-preset", "medium", "-profile:v", "high", "-tune", "grain", "-pix_fmt", "yuv420p"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			result := buildLibx264(tc.tune, tc.depth, 20, tc.colorMeta)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected result: %s", tc.desc, diff)
+			}
+		})
+	}
+}
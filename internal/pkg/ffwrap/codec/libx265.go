@@ -169,3 +169,24 @@ func buildLibx265(tune string, crf int, colorMeta ColorInfo) []string {
 	}
 	return append(libx265, "-pix_fmt", "yuv420p10le")
 }
+
+// libx265Encoder is libx265 at the medium preset writing 10 bit main10 video,
+// with the HDR metadata of the source in its x265 parameters.
+type libx265Encoder struct{}
+
+func (libx265Encoder) Info() EncoderInfo {
+	return EncoderInfo{
+		Name: "libx265",
+		Aliases: []Alias{
+			{Name: "libx265_animation", Params: map[string]string{"tune": "animation"}},
+			{Name: "libx265_grain", Params: map[string]string{"tune": "grain"}},
+		},
+		Pixel_formats: []string{"yuv420p10le"},
+		Hdr:           true,
+		Params:        []Param{{Name: "tune", Description: "x265 tuning for the content", Values: []string{"none", "animation", "grain"}, Default: "none"}},
+	}
+}
+
+func (libx265Encoder) Args(o Options) []string {
+	return buildLibx265(o.Params["tune"], o.Crf, o.Color)
+}
@@ -2,12 +2,22 @@ package codec
 
 import "fmt"
 
-func buildNvencHevc(crf int) []string {
-	return []string{
+// nvencCq maps a crf on a 0-from scale to the constant quality of NVENC, where
+// 0 would let the encoder choose.
+func nvencCq(crf, from int) string {
+	return fmt.Sprintf("%d", scaleQuality(crf, from, 1, maxHevcQp))
+}
+
+// buildNvencHevc returns the options of hevc_nvenc in constant quality mode,
+// with the bitrate set to 0 so that it isn't capped. NVENC reads the 10 bit
+// frames from memory and writes the color description set on the stream.
+func buildNvencHevc(crf int, colorMeta ColorInfo) []string {
+	nvenc := []string{
 		"-pix_fmt", "p010le",
 		"-c:v", "hevc_nvenc",
 		"-rc", "1",
-		"-cq", fmt.Sprintf("%d", crf),
+		"-cq", nvencCq(crf, maxHevcQp),
+		"-b:v", "0",
 		"-profile:v", "1",
 		"-tier", "1",
 		"-spatial_aq", "1",
@@ -15,4 +25,46 @@ func buildNvencHevc(crf int) []string {
 		"-preset", "1",
 		"-b_ref_mode", "2",
 	}
+	return append(nvenc, colorArgs(colorMeta)...)
+}
+
+// nvencHevcEncoder is the NVIDIA hardware HEVC encoder in constant quality
+// mode writing 10 bit video.
+type nvencHevcEncoder struct{}
+
+func (nvencHevcEncoder) Info() EncoderInfo {
+	return EncoderInfo{Name: "hevc_nvenc", Pixel_formats: []string{"p010le"}}
+}
+
+func (nvencHevcEncoder) Args(o Options) []string {
+	return buildNvencHevc(o.Crf, o.Color)
+}
+
+// buildNvencAv1 returns the options of av1_nvenc in constant quality mode. The
+// crf is on the 0-63 scale of the software AV1 encoders.
+func buildNvencAv1(crf int, colorMeta ColorInfo) []string {
+	nvenc := []string{
+		"-pix_fmt", "p010le",
+		"-c:v", "av1_nvenc",
+		"-rc", "vbr",
+		"-cq", nvencCq(crf, maxCrf),
+		"-b:v", "0",
+		"-preset", "p6",
+		"-tune", "hq",
+		"-spatial_aq", "1",
+		"-temporal_aq", "1",
+	}
+	return append(nvenc, colorArgs(colorMeta)...)
+}
+
+// nvencAv1Encoder is the NVIDIA hardware AV1 encoder in constant quality mode
+// writing 10 bit video.
+type nvencAv1Encoder struct{}
+
+func (nvencAv1Encoder) Info() EncoderInfo {
+	return EncoderInfo{Name: "av1_nvenc", Pixel_formats: []string{"p010le"}}
+}
+
+func (nvencAv1Encoder) Args(o Options) []string {
+	return buildNvencAv1(o.Crf, o.Color)
 }
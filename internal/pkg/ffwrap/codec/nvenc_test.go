@@ -0,0 +1,37 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildNvencAv1(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		crf       int
+		colorMeta ColorInfo
+		expected  []string
+	}{
+		{
+			desc:      "HDR10 color flags",
+			crf:       30,
+			colorMeta: hdr10ColorMetaData,
+			expected:  []string{"-pix_fmt", "p010le", "-c:v", "av1_nvenc", "-rc", "vbr", "-cq", "24", "-b:v", "0", "-preset", "p6", "-tune", "hq", "-spatial_aq", "1", "-temporal_aq", "1", "-colorspace", "bt2020nc", "-color_primaries:v", "bt2020", "-color_trc:v", "smpte2084"},
+		},
+		{
+			desc:     "lossless crf kept in constant quality",
+			crf:      0,
+			expected: []string{"-pix_fmt", "p010le", "-c:v", "av1_nvenc", "-rc", "vbr", "-cq", "1", "-b:v", "0", "-preset", "p6", "-tune", "hq", "-spatial_aq", "1", "-temporal_aq", "1"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			result := buildNvencAv1(tc.crf, tc.colorMeta)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected result: %s", tc.desc, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package codec
+
+import "fmt"
+
+// qsvUpload converts the decoded frames to 10 bit and uploads them to the QSV
+// device, with extra frames for the lookahead of the encoder.
+const qsvUpload = "format=p010le,hwupload=extra_hw_frames=64,format=qsv"
+
+// qsvDeviceArgs returns the options opening the QSV device for the filters.
+func qsvDeviceArgs() []string {
+	return []string{"-init_hw_device", "qsv=qs", "-filter_hw_device", "qs"}
+}
+
+// buildQsvHevc returns the options of hevc_qsv in intelligent constant quality
+// mode, whose 1-51 quality level is the crf. The color description set on the
+// stream is written into the VUI.
+func buildQsvHevc(crf int, colorMeta ColorInfo) []string {
+	qsv := []string{
+		"-c:v", "hevc_qsv",
+		"-global_quality", fmt.Sprintf("%d", scaleQuality(crf, maxHevcQp, 1, maxHevcQp)),
+		"-preset", "slow",
+		"-profile:v", "main10",
+	}
+	return append(qsv, colorArgs(colorMeta)...)
+}
+
+// buildQsvAv1 returns the options of av1_qsv in intelligent constant quality
+// mode, its 1-51 quality level mapped from a crf on the 0-63 scale of the
+// software AV1 encoders.
+func buildQsvAv1(crf int, colorMeta ColorInfo) []string {
+	qsv := []string{
+		"-c:v", "av1_qsv",
+		"-global_quality", fmt.Sprintf("%d", scaleQuality(crf, maxCrf, 1, maxHevcQp)),
+		"-preset", "slow",
+		"-profile:v", "main",
+	}
+	return append(qsv, colorArgs(colorMeta)...)
+}
+
+// qsvHevcEncoder is the Intel Quick Sync HEVC encoder writing 10 bit video.
+type qsvHevcEncoder struct{}
+
+func (qsvHevcEncoder) Info() EncoderInfo {
+	return EncoderInfo{Name: "hevc_qsv", Pixel_formats: []string{"p010le"}}
+}
+
+func (qsvHevcEncoder) Args(o Options) []string {
+	return buildQsvHevc(o.Crf, o.Color)
+}
+
+func (qsvHevcEncoder) Device() []string {
+	return qsvDeviceArgs()
+}
+
+func (qsvHevcEncoder) Upload() string {
+	return qsvUpload
+}
+
+// qsvAv1Encoder is the Intel Quick Sync AV1 encoder writing 10 bit video.
+type qsvAv1Encoder struct{}
+
+func (qsvAv1Encoder) Info() EncoderInfo {
+	return EncoderInfo{Name: "av1_qsv", Pixel_formats: []string{"p010le"}}
+}
+
+func (qsvAv1Encoder) Args(o Options) []string {
+	return buildQsvAv1(o.Crf, o.Color)
+}
+
+func (qsvAv1Encoder) Device() []string {
+	return qsvDeviceArgs()
+}
+
+func (qsvAv1Encoder) Upload() string {
+	return qsvUpload
+}
@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildQsv(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		build     func(int, ColorInfo) []string
+		crf       int
+		colorMeta ColorInfo
+		expected  []string
+	}{
+		{
+			desc:      "hevc HDR10 color flags",
+			build:     buildQsvHevc,
+			crf:       22,
+			colorMeta: hdr10ColorMetaData,
+			expected:  []string{"-c:v", "hevc_qsv", "-global_quality", "22", "-preset", "slow", "-profile:v", "main10", "-colorspace", "bt2020nc", "-color_primaries:v", "bt2020", "-color_trc:v", "smpte2084"},
+		},
+		{
+			desc:     "hevc lossless crf raised to the best quality level",
+			build:    buildQsvHevc,
+			crf:      0,
+			expected: []string{"-c:v", "hevc_qsv", "-global_quality", "1", "-preset", "slow", "-profile:v", "main10"},
+		},
+		{
+			desc:      "av1 crf scaled to the quality level",
+			build:     buildQsvAv1,
+			crf:       30,
+			colorMeta: ColorInfo{Color_transfer: "arib-std-b67"},
+			expected:  []string{"-c:v", "av1_qsv", "-global_quality", "24", "-preset", "slow", "-profile:v", "main", "-color_trc:v", "arib-std-b67"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			result := tc.build(tc.crf, tc.colorMeta)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected result: %s", tc.desc, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ErrUnknownEncoder is wrapped by the errors of lookups naming an encoder that
+// is neither registered nor an alias of one.
+var ErrUnknownEncoder = errors.New("unknown encoder")
+
+// Param describes a parameter of an encoder. Values lists the accepted values
+// and Default is used when the parameter is not set.
+type Param struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Values      []string `json:"values,omitempty"`
+	Default     string   `json:"default,omitempty"`
+}
+
+// Alias is another name of an encoder that sets some of its parameters, e.g.
+// libx265_grain for libx265 tuned for grain.
+type Alias struct {
+	Name   string            `json:"name"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// EncoderInfo describes an encoder in the registry: the names it is requested
+// by, the pixel formats it writes, whether it carries the HDR metadata of the
+// source and the parameters it accepts. Base is the ffmpeg encoder run by
+// encoders named otherwise, such as codec templates.
+type EncoderInfo struct {
+	Name          string   `json:"name"`
+	Base          string   `json:"base,omitempty"`
+	Aliases       []Alias  `json:"aliases,omitempty"`
+	Pixel_formats []string `json:"pixel_formats,omitempty"`
+	Hdr           bool     `json:"hdr"`
+	Params        []Param  `json:"params,omitempty"`
+}
+
+// Options are the settings an encoder builds its arguments from. Params holds
+// a value for every parameter of the encoder.
+type Options struct {
+	Crf    int
+	Params map[string]string
+	Color  ColorInfo
+}
+
+// Encoder builds the ffmpeg options of a video encoder.
+type Encoder interface {
+	Info() EncoderInfo
+	Args(o Options) []string
+}
+
+// HardwareEncoder is an encoder reading its frames from a hardware device
+// rather than from memory. Device returns the global options opening the
+// device for the filters and Upload the filter converting the decoded frames
+// to a pixel format of the encoder and uploading them to the device.
+type HardwareEncoder interface {
+	Encoder
+	Device() []string
+	Upload() string
+}
+
+// entry is a name in the registry and the parameters it sets.
+type entry struct {
+	encoder Encoder
+	params  map[string]string
+}
+
+// registry maps the lowercased names and aliases of the encoders to them.
+// Encoders are registered during startup, before any job is built.
+var registry = map[string]entry{}
+
+// Register adds an encoder to the registry under its name and aliases. Names
+// are case insensitive and must not be taken, alias parameters must be in the
+// encoder's schema.
+func Register(e Encoder) error {
+	info := e.Info()
+	if info.Name == "" {
+		return fmt.Errorf("encoder has no name")
+	}
+	names := []entry{{encoder: e}}
+	keys := []string{strings.ToLower(info.Name)}
+	for _, a := range info.Aliases {
+		if err := checkParams(info, a.Params); err != nil {
+			return fmt.Errorf("alias %q of %s: %w", a.Name, info.Name, err)
+		}
+		names = append(names, entry{encoder: e, params: a.Params})
+		keys = append(keys, strings.ToLower(a.Name))
+	}
+	for i, k := range keys {
+		if _, ok := registry[k]; ok || slices.Contains(keys[:i], k) {
+			return fmt.Errorf("encoder name %q is already registered", k)
+		}
+	}
+	for i, k := range keys {
+		registry[k] = names[i]
+	}
+	return nil
+}
+
+// checkParams checks that params only sets parameters of the encoder to
+// values it accepts.
+func checkParams(info EncoderInfo, params map[string]string) error {
+	for name, v := range params {
+		i := slices.IndexFunc(info.Params, func(p Param) bool { return p.Name == name })
+		if i < 0 {
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+		if p := info.Params[i]; len(p.Values) > 0 && !slices.Contains(p.Values, v) {
+			return fmt.Errorf("parameter %q must be one of %s, not %q", name, strings.Join(p.Values, ", "), v)
+		}
+	}
+	return nil
+}
+
+// Lookup returns the encoder registered under name and the parameters the name
+// sets, completed with the defaults of the encoder.
+func Lookup(name string) (Encoder, map[string]string, error) {
+	e, ok := registry[strings.ToLower(name)]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %q", ErrUnknownEncoder, name)
+	}
+	params := map[string]string{}
+	for _, p := range e.encoder.Info().Params {
+		params[p.Name] = p.Default
+	}
+	for k, v := range e.params {
+		params[k] = v
+	}
+	return e.encoder, params, nil
+}
+
+// Encoders lists the registered encoders ordered by name.
+func Encoders() []EncoderInfo {
+	seen := map[string]bool{}
+	var infos []EncoderInfo
+	for _, e := range registry {
+		info := e.encoder.Info()
+		if seen[info.Name] {
+			continue
+		}
+		seen[info.Name] = true
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b EncoderInfo) int { return strings.Compare(a.Name, b.Name) })
+	return infos
+}
+
+// FfmpegEncoder returns the name of the ffmpeg encoder run by the encoder.
+func (i EncoderInfo) FfmpegEncoder() string {
+	if i.Base != "" {
+		return i.Base
+	}
+	return i.Name
+}
+
+// copyEncoder passes the video of the source through unchanged.
+type copyEncoder struct{}
+
+func (copyEncoder) Info() EncoderInfo {
+	return EncoderInfo{Name: "copy"}
+}
+
+func (copyEncoder) Args(Options) []string {
+	return []string{"-c:v", "copy"}
+}
+
+func init() {
+	for _, e := range []Encoder{copyEncoder{}, libx265Encoder{}, libSvtAv1Encoder{}, libx264Encoder{}, libvpxVp9Encoder{}, libaomAv1Encoder{}, librav1eEncoder{},
+		nvencHevcEncoder{}, nvencAv1Encoder{}, av1AmfEncoder{}, vaapiHevcEncoder{}, vaapiAv1Encoder{}, qsvHevcEncoder{}, qsvAv1Encoder{}} {
+		if err := Register(e); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package codec
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testEncoder is an encoder registered by the tests.
+type testEncoder struct {
+	info EncoderInfo
+}
+
+func (e testEncoder) Info() EncoderInfo {
+	return e.info
+}
+
+func (e testEncoder) Args(o Options) []string {
+	return []string{"-c:v", e.info.Name, "-preset", o.Params["preset"]}
+}
+
+func TestLookup(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		name        string
+		encoder     string
+		params      map[string]string
+		shouldError bool
+	}{
+		{desc: "name", name: "libx265", encoder: "libx265", params: map[string]string{"tune": "none"}},
+		{desc: "alias", name: "libx265_grain", encoder: "libx265", params: map[string]string{"tune": "grain"}},
+		{desc: "case insensitive alias", name: "LibSvtAv1_Grain:Medium", encoder: "libsvtav1", params: map[string]string{"grain": "medium"}},
+		{desc: "copy", name: "copy", encoder: "copy", params: map[string]string{}},
+		{desc: "unknown", name: "libx266", shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			e, params, err := Lookup(tc.name)
+			if tc.shouldError {
+				if !errors.Is(err, ErrUnknownEncoder) {
+					t.Errorf("%q: Lookup(%q) err = %v, want %v", tc.desc, tc.name, err, ErrUnknownEncoder)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("%q: Lookup(%q) returned error: %v", tc.desc, tc.name, err)
+			}
+			if e.Info().Name != tc.encoder {
+				t.Errorf("%q: Lookup(%q) = %s, want %s", tc.desc, tc.name, e.Info().Name, tc.encoder)
+			}
+			if diff := cmp.Diff(tc.params, params); diff != "" {
+				t.Errorf("%q: unexpected params: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	presets := []Param{{Name: "preset", Values: []string{"fast", "slow"}, Default: "fast"}}
+	testCases := []struct {
+		desc        string
+		info        EncoderInfo
+		shouldError bool
+	}{
+		{desc: "new encoder", info: EncoderInfo{Name: "test_register", Aliases: []Alias{{Name: "test_register_slow", Params: map[string]string{"preset": "slow"}}}, Params: presets}},
+		{desc: "no name", info: EncoderInfo{}, shouldError: true},
+		{desc: "name taken", info: EncoderInfo{Name: "LIBX265"}, shouldError: true},
+		{desc: "alias taken", info: EncoderInfo{Name: "test_register_alias", Aliases: []Alias{{Name: "libx265_grain"}}}, shouldError: true},
+		{desc: "unknown alias parameter", info: EncoderInfo{Name: "test_register_param", Aliases: []Alias{{Name: "test_register_param_crf", Params: map[string]string{"crf": "20"}}}, Params: presets}, shouldError: true},
+		{desc: "unaccepted alias value", info: EncoderInfo{Name: "test_register_value", Aliases: []Alias{{Name: "test_register_value_medium", Params: map[string]string{"preset": "medium"}}}, Params: presets}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		if err := Register(testEncoder{tc.info}); (err != nil) != tc.shouldError {
+			t.Errorf("%q: Register() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+		}
+	}
+	if _, _, err := Lookup("test_register_value"); err == nil {
+		t.Errorf("encoder with a rejected alias was registered")
+	}
+	args, err := BuildCodec("test_register_slow", 20, ColorInfo{})
+	if err != nil {
+		t.Fatalf("BuildCodec() returned error: %v", err)
+	}
+	if diff := cmp.Diff([]string{"-c:v", "test_register", "-preset", "slow"}, args); diff != "" {
+		t.Errorf("unexpected args: %s", diff)
+	}
+}
+
+func TestEncoders(t *testing.T) {
+	builtin := []string{"av1_amf", "av1_nvenc", "av1_qsv", "av1_vaapi", "copy", "hevc_nvenc", "hevc_qsv", "hevc_vaapi", "libaom-av1", "librav1e", "libsvtav1", "libvpx-vp9", "libx264", "libx265"}
+	var names []string
+	for _, e := range Encoders() {
+		if slices.Contains(builtin, e.Name) {
+			names = append(names, e.Name)
+		}
+	}
+	if diff := cmp.Diff(builtin, names); diff != "" {
+		t.Errorf("unexpected built-in encoders: %s", diff)
+	}
+}
@@ -0,0 +1,159 @@
+package codec
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/logger"
+)
+
+// paramsOptions maps the encoders taking their parameters as a single option
+// to that option.
+var paramsOptions = map[string]string{
+	"libx264":   "-x264-params",
+	"libx265":   "-x265-params",
+	"libsvtav1": "-svtav1-params",
+}
+
+// Template is a video encoder declared in the configuration. Encoder is the
+// ffmpeg encoder it runs with Args, the quality is set from the crf of the
+// request with Crf_flag, -crf unless set, and Preset with -preset. Params are
+// joined into the parameter option of libx264, libx265 or libsvtav1. Hdr
+// injects the color description and HDR metadata of the source the way the
+// built-in encoders of those three do. Templates of the VAAPI and QSV encoders
+// open their device and upload their frames like the built-in encoders.
+type Template struct {
+	Encoder      string   `yaml:"encoder"`
+	Args         []string `yaml:"args,omitempty"`
+	Crf_flag     string   `yaml:"crf_flag,omitempty"`
+	Preset       string   `yaml:"preset,omitempty"`
+	Params       []string `yaml:"params,omitempty"`
+	Pixel_format string   `yaml:"pixel_format,omitempty"`
+	Hdr          bool     `yaml:"hdr,omitempty"`
+}
+
+// templateEncoder is a template registered under its name.
+type templateEncoder struct {
+	name string
+	t    Template
+}
+
+// validate checks that the template names an encoder, that its args are option
+// and value pairs, as packaged outputs address them to their stream, and that
+// it only uses params and HDR injection with an encoder that supports them.
+func (t Template) validate() error {
+	switch {
+	case t.Encoder == "":
+		return fmt.Errorf("template has no encoder")
+	case strings.EqualFold(t.Encoder, "copy"):
+		return fmt.Errorf("templates can't copy video")
+	case len(t.Args)%2 != 0:
+		return fmt.Errorf("args must be option and value pairs, %q has no value", t.Args[len(t.Args)-1])
+	}
+	for i := 0; i < len(t.Args); i += 2 {
+		if !strings.HasPrefix(t.Args[i], "-") {
+			return fmt.Errorf("args must be option and value pairs, %q is not an option", t.Args[i])
+		}
+	}
+	if _, ok := paramsOptions[strings.ToLower(t.Encoder)]; !ok && (len(t.Params) > 0 || t.Hdr) {
+		return fmt.Errorf("params and hdr are only supported for %s, not %s", strings.Join(slices.Sorted(maps.Keys(paramsOptions)), ", "), t.Encoder)
+	}
+	return nil
+}
+
+func (e templateEncoder) Info() EncoderInfo {
+	info := EncoderInfo{Name: e.name, Base: e.t.Encoder, Hdr: e.t.Hdr}
+	if e.t.Pixel_format != "" {
+		info.Pixel_formats = []string{e.t.Pixel_format}
+	}
+	return info
+}
+
+func (e templateEncoder) Args(o Options) []string {
+	enc := strings.ToLower(e.t.Encoder)
+	crfFlag := e.t.Crf_flag
+	if crfFlag == "" {
+		crfFlag = "-crf"
+	}
+	args := []string{"-c:v", e.t.Encoder, crfFlag, strconv.Itoa(o.Crf)}
+	if e.t.Preset != "" {
+		args = append(args, "-preset", e.t.Preset)
+	}
+	args = append(args, e.t.Args...)
+
+	params := slices.Clone(e.t.Params)
+	if e.t.Hdr {
+		var color, colorParams []string
+		switch enc {
+		case "libx264":
+			color, colorParams = libx264HDR(o.Color)
+		case "libx265":
+			var err error
+			if color, colorParams, err = libx265HDR(o.Color); err != nil {
+				logger.Errorf("failed to generate color args, continuing without: %v", err)
+			}
+			if len(colorParams) > 0 {
+				colorParams = append([]string{"hdr-opt=1", "repeat-headers=1"}, colorParams...)
+			}
+		case "libsvtav1":
+			color, colorParams = libSvtAv1HDR(o.Color)
+		}
+		args = append(args, color...)
+		params = append(params, colorParams...)
+	}
+	if len(params) > 0 {
+		args = append(args, paramsOptions[enc], strings.Join(params, ":"))
+	}
+	if e.t.Pixel_format != "" {
+		args = append(args, "-pix_fmt", e.t.Pixel_format)
+	}
+	return args
+}
+
+// hardware returns the built-in encoder a template runs with when it reads its
+// frames from a hardware device.
+func (e templateEncoder) hardware() (HardwareEncoder, bool) {
+	b, _, err := Lookup(e.t.Encoder)
+	if err != nil {
+		return nil, false
+	}
+	if _, ok := b.(templateEncoder); ok {
+		return nil, false
+	}
+	h, ok := b.(HardwareEncoder)
+	return h, ok
+}
+
+// Device returns the device options of the hardware encoder of the template.
+func (e templateEncoder) Device() []string {
+	if h, ok := e.hardware(); ok {
+		return h.Device()
+	}
+	return nil
+}
+
+// Upload returns the upload filter of the hardware encoder of the template.
+func (e templateEncoder) Upload() string {
+	if h, ok := e.hardware(); ok {
+		return h.Upload()
+	}
+	return ""
+}
+
+// RegisterTemplates validates the templates of the configuration and registers
+// each under its name, in order of their names.
+func RegisterTemplates(templates map[string]Template) error {
+	for _, name := range slices.Sorted(maps.Keys(templates)) {
+		t := templates[name]
+		if err := t.validate(); err != nil {
+			return fmt.Errorf("codec template %q: %w", name, err)
+		}
+		if err := Register(templateEncoder{name: name, t: t}); err != nil {
+			return fmt.Errorf("codec template %q: %w", name, err)
+		}
+	}
+	return nil
+}
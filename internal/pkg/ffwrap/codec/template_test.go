@@ -0,0 +1,149 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestTemplateArgs(t *testing.T) {
+	hdr10 := ColorInfo{
+		Color_space:     "bt2020nc",
+		Color_primaries: "bt2020",
+		Color_transfer:  "smpte2084",
+		Side_data_list:  []ColorSideInfo{validLightColorInfo},
+	}
+	testCases := []struct {
+		desc      string
+		template  Template
+		colorMeta ColorInfo
+		expected  []string
+	}{
+		{
+			desc:     "x265 with psy params",
+			template: Template{Encoder: "libx265", Preset: "slow", Params: []string{"psy-rd=2.0"}, Pixel_format: "yuv420p10le"},
+			expected: []string{
+				"-c:v", "libx265",
+				"-crf", "20",
+				"-preset", "slow",
+				"-x265-params", "psy-rd=2.0",
+				"-pix_fmt", "yuv420p10le",
+			},
+		},
+		{
+			desc:      "x265 with hdr injected",
+			template:  Template{Encoder: "libx265", Args: []string{"-profile:v", "main10"}, Params: []string{"psy-rd=2.0"}, Hdr: true},
+			colorMeta: hdr10,
+			expected: []string{
+				"-c:v", "libx265",
+				"-crf", "20",
+				"-profile:v", "main10",
+				"-color_trc:v", "smpte2084",
+				"-color_primaries:v", "bt2020",
+				"-colorspace", "bt2020nc",
+				"-x265-params", "psy-rd=2.0:hdr-opt=1:repeat-headers=1:colormatrix=bt2020nc:colorprim=bt2020:transfer=smpte2084:content-light=700,200",
+			},
+		},
+		{
+			desc:      "x265 sdr without params",
+			template:  Template{Encoder: "libx265", Hdr: true},
+			colorMeta: ColorInfo{},
+			expected:  []string{"-c:v", "libx265", "-crf", "20"},
+		},
+		{
+			desc:      "slow svtav1 with hdr injected",
+			template:  Template{Encoder: "libsvtav1", Preset: "4", Params: []string{"tune=0", "film-grain=8"}, Pixel_format: "yuv420p10le", Hdr: true},
+			colorMeta: hdr10,
+			expected: []string{
+				"-c:v", "libsvtav1",
+				"-crf", "20",
+				"-preset", "4",
+				"-colorspace", "bt2020nc",
+				"-color_primaries:v", "bt2020",
+				"-color_trc:v", "smpte2084",
+				"-svtav1-params", "tune=0:film-grain=8:content-light=700,200:chroma-sample-position=topleft",
+				"-pix_fmt", "yuv420p10le",
+			},
+		},
+		{
+			desc:      "x264 with hdr injected",
+			template:  Template{Encoder: "libx264", Preset: "slow", Args: []string{"-profile:v", "high10"}, Pixel_format: "yuv420p10le", Hdr: true},
+			colorMeta: hdr10,
+			expected: []string{
+				"-c:v", "libx264",
+				"-crf", "20",
+				"-preset", "slow",
+				"-profile:v", "high10",
+				"-colorspace", "bt2020nc",
+				"-color_primaries:v", "bt2020",
+				"-color_trc:v", "smpte2084",
+				"-x264-params", "cll=700,200",
+				"-pix_fmt", "yuv420p10le",
+			},
+		},
+		{
+			desc:      "hdr not injected unless set",
+			template:  Template{Encoder: "libsvtav1", Params: []string{"tune=0"}},
+			colorMeta: hdr10,
+			expected:  []string{"-c:v", "libsvtav1", "-crf", "20", "-svtav1-params", "tune=0"},
+		},
+		{
+			desc:     "nvenc constant quality",
+			template: Template{Encoder: "hevc_nvenc", Crf_flag: "-cq", Preset: "p7", Args: []string{"-rc", "vbr"}},
+			expected: []string{"-c:v", "hevc_nvenc", "-cq", "20", "-preset", "p7", "-rc", "vbr"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			got := templateEncoder{name: "test", t: tc.template}.Args(Options{Crf: 20, Color: tc.colorMeta})
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("%q: unexpected args: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestRegisterTemplates(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		templates   map[string]Template
+		shouldError bool
+	}{
+		{desc: "none"},
+		{desc: "valid", templates: map[string]Template{"test_template_x265": {Encoder: "libx265", Preset: "slow"}}},
+		{desc: "no encoder", templates: map[string]Template{"test_template_empty": {Preset: "slow"}}, shouldError: true},
+		{desc: "copy", templates: map[string]Template{"test_template_copy": {Encoder: "copy"}}, shouldError: true},
+		{desc: "params for an encoder without them", templates: map[string]Template{"test_template_nvenc": {Encoder: "hevc_nvenc", Params: []string{"psy-rd=2.0"}}}, shouldError: true},
+		{desc: "hdr for an encoder without it", templates: map[string]Template{"test_template_amf": {Encoder: "av1_amf", Hdr: true}}, shouldError: true},
+		{desc: "flag without a value", templates: map[string]Template{"test_template_flag": {Encoder: "libx264", Args: []string{"-profile:v", "high", "-bitexact"}}}, shouldError: true},
+		{desc: "value without an option", templates: map[string]Template{"test_template_value": {Encoder: "libx264", Args: []string{"high", "-profile:v"}}}, shouldError: true},
+		{desc: "built-in name", templates: map[string]Template{"libsvtav1": {Encoder: "libsvtav1"}}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		if err := RegisterTemplates(tc.templates); (err != nil) != tc.shouldError {
+			t.Errorf("%q: RegisterTemplates() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+		}
+	}
+	e, _, err := Lookup("TEST_TEMPLATE_X265")
+	if err != nil {
+		t.Fatalf("Lookup() returned error: %v", err)
+	}
+	if diff := cmp.Diff(EncoderInfo{Name: "test_template_x265", Base: "libx265"}, e.Info()); diff != "" {
+		t.Errorf("unexpected template info: %s", diff)
+	}
+}
+
+func TestTemplateHardware(t *testing.T) {
+	vaapi := templateEncoder{name: "vaapi_fast", t: Template{Encoder: "hevc_vaapi", Args: []string{"-quality", "7"}}}
+	if diff := cmp.Diff([]string{"-init_hw_device", "vaapi=va:/dev/dri/renderD128", "-filter_hw_device", "va"}, vaapi.Device()); diff != "" {
+		t.Errorf("unexpected device options of a vaapi template: %s", diff)
+	}
+	if got := vaapi.Upload(); got != "format=p010le,hwupload" {
+		t.Errorf("Upload() = %q for a vaapi template, want the upload of hevc_vaapi", got)
+	}
+	software := templateEncoder{name: "x265_slow", t: Template{Encoder: "libx265", Preset: "slow"}}
+	if device, upload := software.Device(), software.Upload(); device != nil || upload != "" {
+		t.Errorf("software template uses device %v and upload %q, want none", device, upload)
+	}
+}
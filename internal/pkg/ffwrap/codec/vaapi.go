@@ -0,0 +1,79 @@
+package codec
+
+import "fmt"
+
+const (
+	// vaapiDevice is the render node the VAAPI encoders run on.
+	vaapiDevice = "/dev/dri/renderD128"
+	// vaapiUpload converts the decoded frames to 10 bit and uploads them to
+	// the VAAPI device.
+	vaapiUpload = "format=p010le,hwupload"
+)
+
+// vaapiDeviceArgs returns the options opening the VAAPI device for the filters.
+func vaapiDeviceArgs() []string {
+	return []string{"-init_hw_device", "vaapi=va:" + vaapiDevice, "-filter_hw_device", "va"}
+}
+
+// buildVaapiHevc returns the options of hevc_vaapi at a constant quantizer,
+// the crf taken as the HEVC quantizer. The color description set on the
+// stream is written into the VUI.
+func buildVaapiHevc(crf int, colorMeta ColorInfo) []string {
+	vaapi := []string{
+		"-c:v", "hevc_vaapi",
+		"-rc_mode", "CQP",
+		"-qp", fmt.Sprintf("%d", scaleQuality(crf, maxHevcQp, 0, maxHevcQp)),
+		"-profile:v", "main10",
+	}
+	return append(vaapi, colorArgs(colorMeta)...)
+}
+
+// buildVaapiAv1 returns the options of av1_vaapi at a constant quantizer index
+// mapped from a crf on the 0-63 scale of the software AV1 encoders.
+func buildVaapiAv1(crf int, colorMeta ColorInfo) []string {
+	vaapi := []string{
+		"-c:v", "av1_vaapi",
+		"-rc_mode", "CQP",
+		"-qp", fmt.Sprintf("%d", av1Qindex(crf)),
+		"-profile:v", "main",
+	}
+	return append(vaapi, colorArgs(colorMeta)...)
+}
+
+// vaapiHevcEncoder is the VAAPI hardware HEVC encoder writing 10 bit video.
+type vaapiHevcEncoder struct{}
+
+func (vaapiHevcEncoder) Info() EncoderInfo {
+	return EncoderInfo{Name: "hevc_vaapi", Pixel_formats: []string{"p010le"}}
+}
+
+func (vaapiHevcEncoder) Args(o Options) []string {
+	return buildVaapiHevc(o.Crf, o.Color)
+}
+
+func (vaapiHevcEncoder) Device() []string {
+	return vaapiDeviceArgs()
+}
+
+func (vaapiHevcEncoder) Upload() string {
+	return vaapiUpload
+}
+
+// vaapiAv1Encoder is the VAAPI hardware AV1 encoder writing 10 bit video.
+type vaapiAv1Encoder struct{}
+
+func (vaapiAv1Encoder) Info() EncoderInfo {
+	return EncoderInfo{Name: "av1_vaapi", Pixel_formats: []string{"p010le"}}
+}
+
+func (vaapiAv1Encoder) Args(o Options) []string {
+	return buildVaapiAv1(o.Crf, o.Color)
+}
+
+func (vaapiAv1Encoder) Device() []string {
+	return vaapiDeviceArgs()
+}
+
+func (vaapiAv1Encoder) Upload() string {
+	return vaapiUpload
+}
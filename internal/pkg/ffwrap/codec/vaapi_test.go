@@ -0,0 +1,47 @@
+package codec
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildVaapi(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		build     func(int, ColorInfo) []string
+		crf       int
+		colorMeta ColorInfo
+		expected  []string
+	}{
+		{
+			desc:      "hevc HDR10 color flags",
+			build:     buildVaapiHevc,
+			crf:       22,
+			colorMeta: hdr10ColorMetaData,
+			expected:  []string{"-c:v", "hevc_vaapi", "-rc_mode", "CQP", "-qp", "22", "-profile:v", "main10", "-colorspace", "bt2020nc", "-color_primaries:v", "bt2020", "-color_trc:v", "smpte2084"},
+		},
+		{
+			desc:     "hevc crf above the quantizer range",
+			build:    buildVaapiHevc,
+			crf:      60,
+			expected: []string{"-c:v", "hevc_vaapi", "-rc_mode", "CQP", "-qp", "51", "-profile:v", "main10"},
+		},
+		{
+			desc:      "av1 quantizer index",
+			build:     buildVaapiAv1,
+			crf:       30,
+			colorMeta: ColorInfo{Color_space: "bt709", Color_primaries: "bt709", Color_transfer: "bt709"},
+			expected:  []string{"-c:v", "av1_vaapi", "-rc_mode", "CQP", "-qp", "121", "-profile:v", "main", "-colorspace", "bt709", "-color_primaries:v", "bt709", "-color_trc:v", "bt709"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			result := tc.build(tc.crf, tc.colorMeta)
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected result: %s", tc.desc, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/logger"
+)
+
+// DefaultCodec is the video encoder of requests that don't name one.
+const DefaultCodec = "libx265"
+
+// videoCodec returns the video encoder of the output, the default codec unless
+// set.
+func (tr TranscodeRequest) videoCodec() string {
+	if tr.Codec == "" {
+		return DefaultCodec
+	}
+	return tr.Codec
+}
+
+// codecArgs returns the video encoder options of an output. Codecs are checked
+// when a job is submitted, an unknown codec left in the queue is passed to
+// ffmpeg by name so that the job fails instead of using another encoder.
+func (tr TranscodeRequest) codecArgs(colorMeta codec.ColorInfo) []string {
+	args, err := codec.BuildCodec(tr.videoCodec(), tr.Crf, tr.outputColor(colorMeta))
+	if err != nil {
+		logger.Errorf("%v, passing it to ffmpeg unchanged", err)
+		return []string{"-c:v", tr.Codec}
+	}
+	return args
+}
+
+// validateCodecs checks that the video encoder of every output is registered.
+func (tr TranscodeRequest) validateCodecs() error {
+	for _, o := range tr.renditions() {
+		if _, _, err := codec.Lookup(o.videoCodec()); err != nil {
+			return err
+		}
+	}
+	device := tr.deviceArgs()
+	for _, o := range tr.renditions() {
+		if d, _ := codec.Hardware(o.videoCodec()); d != nil && !slices.Equal(d, device) {
+			return fmt.Errorf("%s and %s run on different hardware devices", tr.videoCodec(), o.videoCodec())
+		}
+	}
+	return nil
+}
+
+// deviceArgs returns the global options opening the hardware device of the
+// outputs encoding on one. Every output of a job uses the same device.
+func (tr TranscodeRequest) deviceArgs() []string {
+	for _, o := range tr.renditions() {
+		if device, _ := codec.Hardware(o.videoCodec()); device != nil {
+			return device
+		}
+	}
+	return nil
+}
+
+// uploadFilter returns the filter moving the video of an output to the device
+// of its hardware encoder, the last filter of its chain.
+func (tr TranscodeRequest) uploadFilter() string {
+	_, upload := codec.Hardware(tr.videoCodec())
+	return upload
+}
+
+// ffmpegEncoder returns the ffmpeg encoder run for a codec name, which differs
+// from the name for aliases and codec templates.
+func ffmpegEncoder(name string) string {
+	e, _, err := codec.Lookup(name)
+	if err != nil {
+		return name
+	}
+	return e.Info().FfmpegEncoder()
+}
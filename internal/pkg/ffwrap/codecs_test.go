@@ -0,0 +1,27 @@
+package ffwrap
+
+import "testing"
+
+func TestValidateCodecs(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		shouldError bool
+	}{
+		{desc: "default codec", request: TranscodeRequest{}},
+		{desc: "alias", request: TranscodeRequest{Codec: "libx264_grain"}},
+		{desc: "unknown codec", request: TranscodeRequest{Codec: "libx266"}, shouldError: true},
+		{desc: "unknown rendition codec", request: TranscodeRequest{Outputs: []Rendition{{Destination: "/720p.mkv", Codec: "h265"}}}, shouldError: true},
+		{desc: "hardware and software outputs", request: TranscodeRequest{Codec: "av1_vaapi", Outputs: []Rendition{{Destination: "/720p.mkv", Codec: "libx264"}}}},
+		{desc: "outputs on one device", request: TranscodeRequest{Codec: "hevc_vaapi", Outputs: []Rendition{{Destination: "/720p.mkv", Codec: "av1_vaapi"}}}},
+		{desc: "outputs on different devices", request: TranscodeRequest{Codec: "hevc_vaapi", Outputs: []Rendition{{Destination: "/720p.mkv", Codec: "av1_qsv"}}}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if err := tc.request.validateCodecs(); (err != nil) != tc.shouldError {
+				t.Errorf("%q: validateCodecs() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
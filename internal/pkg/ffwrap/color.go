@@ -0,0 +1,360 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/logger"
+)
+
+const (
+	// colorSamples is the number of points of the source a frame is probed at.
+	colorSamples = 4
+	// pqPeak is the luminance in cd/m² of the largest PQ code value.
+	pqPeak = 10000
+)
+
+var (
+	// ErrUndecodableVideo is returned when no frame of the source's video
+	// could be decoded at any sample point and its stream carries no color
+	// tags.
+	ErrUndecodableVideo = errors.New("no decodable video frames")
+	// ErrColorUnknown is returned with an empty color description when
+	// neither the frames nor the stream of a source describe its color, the
+	// source is encoded as untagged SDR.
+	ErrColorUnknown = errors.New("source color is not described")
+
+	signalstatsRegex = regexp.MustCompile(`lavfi\.signalstats\.(YMAX|YAVG)=([\d.]+)`)
+)
+
+// colorSamplePoints returns the positions in seconds of the frames probed for
+// color metadata: the start of the source and points spread across it.
+func colorSamplePoints(duration float64) []float64 {
+	points := []float64{0}
+	if duration <= 0 {
+		return points
+	}
+	for i := 1; i < colorSamples; i++ {
+		points = append(points, float64(int(duration*float64(i)/colorSamples)))
+	}
+	return points
+}
+
+// colorProbeArgs returns the ffprobe arguments reading the color description
+// of one frame at each of the points.
+func colorProbeArgs(source string, points []float64) []string {
+	intervals := make([]string, 0, len(points))
+	for _, p := range points {
+		if p > 0 {
+			intervals = append(intervals, formatSeconds(p)+"%+#1")
+		} else {
+			intervals = append(intervals, "%+#1")
+		}
+	}
+	return []string{
+		"-hide_banner", "-loglevel", "warning", "-select_streams", "v:0", "-analyzeduration", "6000M", "-probesize", "6000M",
+		"-print_format", "json", "-show_frames", "-read_intervals", strings.Join(intervals, ","),
+		"-show_entries", "frame=color_space,color_primaries,color_transfer,side_data_list,pix_fmt", "-i", source,
+	}
+}
+
+// knownColor reports whether ffprobe described a color property.
+func knownColor(v string) bool {
+	switch strings.ToLower(v) {
+	case "", "unknown", "unspecified", "reserved":
+		return false
+	}
+	return true
+}
+
+// validFraction reports whether v is a fraction of integers with a non zero
+// denominator as printed by ffprobe for mastering display metadata.
+func validFraction(v string) bool {
+	n, d, found := strings.Cut(v, "/")
+	if !found {
+		return false
+	}
+	if _, err := strconv.Atoi(n); err != nil {
+		return false
+	}
+	den, err := strconv.Atoi(d)
+	return err == nil && den != 0
+}
+
+// wellFormed reports whether the side data can be passed to an encoder,
+// mastering display metadata needs every coordinate and luminance and content
+// light level metadata a maximum.
+func wellFormed(sd codec.ColorSideInfo) bool {
+	switch sd.Side_data_type {
+	case codec.SideDataTypeMastering:
+		for _, v := range []string{sd.Red_x, sd.Red_y, sd.Green_x, sd.Green_y, sd.Blue_x, sd.Blue_y, sd.White_point_x, sd.White_point_y, sd.Min_luminance, sd.Max_luminance} {
+			if !validFraction(v) {
+				return false
+			}
+		}
+	case codec.SideDataTypeLightLevel:
+		return sd.Max_content > 0
+	}
+	return true
+}
+
+// mergeColorFrames combines the color descriptions of the sampled frames.
+// Properties missing from a frame are taken from the following ones and the
+// first well formed side data of each type is kept.
+func mergeColorFrames(frames []codec.ColorInfo) codec.ColorInfo {
+	var ci codec.ColorInfo
+	seen := map[string]bool{}
+	for _, f := range frames {
+		if !knownColor(ci.Color_space) && knownColor(f.Color_space) {
+			ci.Color_space = f.Color_space
+		}
+		if !knownColor(ci.Color_primaries) && knownColor(f.Color_primaries) {
+			ci.Color_primaries = f.Color_primaries
+		}
+		if !knownColor(ci.Color_transfer) && knownColor(f.Color_transfer) {
+			ci.Color_transfer = f.Color_transfer
+		}
+		for _, sd := range f.Side_data_list {
+			if seen[sd.Side_data_type] || !wellFormed(sd) {
+				continue
+			}
+			seen[sd.Side_data_type] = true
+			ci.Side_data_list = append(ci.Side_data_list, sd)
+		}
+	}
+	return ci
+}
+
+// fillStreamColor takes the properties none of the frames described from the
+// tags of the first video stream of the inventory.
+func fillStreamColor(ci *codec.ColorInfo, inv *FfprobeOutput) {
+	if inv == nil {
+		return
+	}
+	for _, s := range inv.Streams {
+		if s.Codec_type != "video" || isAttachedPicture(s) {
+			continue
+		}
+		if !knownColor(ci.Color_space) && knownColor(s.Color_space) {
+			ci.Color_space = s.Color_space
+		}
+		if !knownColor(ci.Color_primaries) && knownColor(s.Color_primaries) {
+			ci.Color_primaries = s.Color_primaries
+		}
+		if !knownColor(ci.Color_transfer) && knownColor(s.Color_transfer) {
+			ci.Color_transfer = s.Color_transfer
+		}
+		return
+	}
+}
+
+// described reports whether any color property of the description is known.
+func described(ci codec.ColorInfo) bool {
+	return knownColor(ci.Color_space) || knownColor(ci.Color_primaries) || knownColor(ci.Color_transfer)
+}
+
+// hasSideData reports whether the description carries side data of the type.
+func hasSideData(ci codec.ColorInfo, sideDataType string) bool {
+	for _, sd := range ci.Side_data_list {
+		if sd.Side_data_type == sideDataType {
+			return true
+		}
+	}
+	return false
+}
+
+// probeColor reads the color description of frames sampled across the source,
+// falling back to the stream tags of the inventory for properties no frame
+// describes. inv may be nil, only the start of the source is sampled then.
+func probeColor(ctx context.Context, source string, inv *FfprobeOutput) (codec.ColorInfo, error) {
+	var duration float64
+	if inv != nil {
+		duration, _ = ParseDuration(inv.Format.Duration)
+	}
+	args := colorProbeArgs(source, colorSamplePoints(duration))
+	logger.Infof("calling ffprobe with: %#v", args)
+	cmd := exec.CommandContext(ctx, ffprobebinary, args...)
+	var w ColorInfoWrapper
+	if o, err := cmd.Output(); errors.Is(ctx.Err(), context.Canceled) {
+		return codec.ColorInfo{}, ctx.Err()
+	} else if err != nil {
+		logger.Warningf("%q failed to probe frame color, falling back to stream tags: %v", source, err)
+	} else if err := json.Unmarshal(o, &w); err != nil {
+		logger.Warningf("%q unmarshall frame color %q: %v", source, o, err)
+	}
+
+	ci := mergeColorFrames(w.Frames)
+	fillStreamColor(&ci, inv)
+	switch {
+	case described(ci):
+		return ci, nil
+	case len(w.Frames) == 0:
+		return codec.ColorInfo{}, fmt.Errorf("%w: %q", ErrUndecodableVideo, source)
+	}
+	return ci, fmt.Errorf("%w: %q", ErrColorUnknown, source)
+}
+
+// lightLevelFilter linearises PQ frames so that the full 16 bit range spans
+// pqPeak cd/m², takes the largest of the red, green and blue components of
+// every pixel as CTA-861.3 defines the light level and measures its maximum
+// and average on each frame. The maximum is carried in the luma plane of a
+// yuv444p16le frame for signalstats.
+var lightLevelFilter = strings.Join([]string{
+	fmt.Sprintf("[0:v:0]zscale=t=linear:npl=%d:r=full,format=gbrp16le,extractplanes=r+g+b[r][g][b]", pqPeak),
+	"[r][g]blend=all_mode=lighten[rg]",
+	"[rg][b]blend=all_mode=lighten,split=3[m0][m1][m2]",
+	"[m0][m1][m2]mergeplanes=0x001020:yuv444p16le,signalstats,metadata=print:key=lavfi.signalstats.YMAX,metadata=print:key=lavfi.signalstats.YAVG",
+}, ";")
+
+// lightLevelArgs returns the arguments measuring the light level of every
+// frame of the source's first video stream.
+func lightLevelArgs(source string) []string {
+	args := append([]string{"-hide_banner"}, ffcommon...)
+	return append(args, "-i", source, "-filter_complex", lightLevelFilter, "-an", "-sn", "-f", "null", "NUL")
+}
+
+// parseLightLevel returns the largest maximum and average light level printed
+// by the signalstats metadata of lightLevelFilter as 16 bit values.
+func parseLightLevel(out []byte) (float64, float64, error) {
+	var maxY, maxAvg float64
+	m := signalstatsRegex.FindAllSubmatch(out, -1)
+	if len(m) == 0 {
+		return 0, 0, fmt.Errorf("failed to extract signalstats")
+	}
+	for _, s := range m {
+		v, err := strconv.ParseFloat(string(s[2]), 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid signalstats value %q: %w", s[2], err)
+		}
+		if string(s[1]) == "YMAX" {
+			maxY = max(maxY, v)
+		} else {
+			maxAvg = max(maxAvg, v)
+		}
+	}
+	return maxY, maxAvg, nil
+}
+
+// nits converts a 16 bit value of the linearised signal to cd/m².
+func nits(v float64) int {
+	return int(math.Round(v / math.MaxUint16 * pqPeak))
+}
+
+// analyzeLightLevel measures the content light level of a PQ source over every
+// frame: MaxCLL is the brightest pixel and MaxFALL the brightest frame
+// average. Sampled frames would miss the brightest scenes and understate both,
+// so the whole stream is decoded, which takes about as long as playing it
+// back.
+func analyzeLightLevel(ctx context.Context, source string) (codec.ColorSideInfo, error) {
+	args := lightLevelArgs(source)
+	logger.Infof("signalstats with args %#v", args)
+	var serr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffmpegbinary, args...)
+	cmd.Stderr = &serr
+	if err := cmd.Run(); errors.Is(ctx.Err(), context.Canceled) {
+		return codec.ColorSideInfo{}, ctx.Err()
+	} else if err != nil {
+		return codec.ColorSideInfo{}, fmt.Errorf("failed to exec signalstats: %v", err)
+	}
+	maxY, maxAvg, err := parseLightLevel(serr.Bytes())
+	if err != nil {
+		return codec.ColorSideInfo{}, err
+	}
+	return codec.ColorSideInfo{
+		Side_data_type: codec.SideDataTypeLightLevel,
+		Max_content:    nits(maxY),
+		Max_average:    nits(maxAvg),
+	}, nil
+}
+
+// needsLightLevel reports whether the content light level of a source is
+// measured: a PQ source without light level metadata.
+func needsLightLevel(ci codec.ColorInfo) bool {
+	return strings.EqualFold(ci.Color_transfer, "smpte2084") && !hasSideData(ci, codec.SideDataTypeLightLevel)
+}
+
+// MeasureLightLevel stores the content light level of a PQ source without
+// light level metadata in its inventory when the request encodes its video.
+// A failed measurement is logged and leaves the light level out, only
+// cancellation is returned.
+func MeasureLightLevel(ctx context.Context, tr TranscodeRequest, source string, inv *FfprobeOutput) error {
+	if !tr.encodesVideo() {
+		return nil
+	}
+	ci, err := probeColor(ctx, source, inv)
+	if errors.Is(err, context.Canceled) {
+		return err
+	} else if err != nil || !needsLightLevel(ci) {
+		return nil
+	}
+	ll, err := analyzeLightLevel(ctx, source)
+	if errors.Is(err, context.Canceled) {
+		return err
+	} else if err != nil {
+		logger.Warningf("%q content light level analysis failed: %v", source, err)
+		return nil
+	}
+	inv.Light_level = &ll
+	return nil
+}
+
+// parseColorInfo returns the color description the outputs of a source are
+// encoded with. PQ sources without light level metadata take the light level
+// measured into the inventory, if any.
+func parseColorInfo(ctx context.Context, source string, inv *FfprobeOutput) (codec.ColorInfo, error) {
+	ci, err := probeColor(ctx, source, inv)
+	if err == nil && needsLightLevel(ci) && inv != nil && inv.Light_level != nil {
+		ci.Side_data_list = append(ci.Side_data_list, *inv.Light_level)
+	}
+	return ci, err
+}
+
+// encodesVideo reports whether any output of the request decodes and encodes
+// the video of the source.
+func (tr TranscodeRequest) encodesVideo() bool {
+	for _, o := range tr.renditions() {
+		if !strings.EqualFold(o.Codec, "copy") {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceColor returns the color description of a source for a request. Video
+// that can't be decoded fails requests encoding it, other errors leave the
+// source's color undescribed.
+func (tr TranscodeRequest) sourceColor(ctx context.Context, source string, inv *FfprobeOutput) (codec.ColorInfo, error) {
+	colorMeta, err := parseColorInfo(ctx, source, inv)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return codec.ColorInfo{}, err
+	case errors.Is(err, ErrUndecodableVideo) && tr.encodesVideo():
+		return codec.ColorInfo{}, err
+	case err != nil:
+		logger.Warningf("failed to parse color metadata: %v", err)
+	}
+	logger.Infof("got color metadata: %#v", colorMeta)
+	return colorMeta, nil
+}
@@ -0,0 +1,161 @@
+package ffwrap
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	masteringSideData = codec.ColorSideInfo{
+		Side_data_type: codec.SideDataTypeMastering,
+		Red_x:          "34000/50000",
+		Red_y:          "16000/50000",
+		Green_x:        "13250/50000",
+		Green_y:        "34500/50000",
+		Blue_x:         "7500/50000",
+		Blue_y:         "3000/50000",
+		White_point_x:  "15635/50000",
+		White_point_y:  "16450/50000",
+		Min_luminance:  "50/10000",
+		Max_luminance:  "10000000/10000",
+	}
+	lightLevelSideData = codec.ColorSideInfo{Side_data_type: codec.SideDataTypeLightLevel, Max_content: 1000, Max_average: 400}
+)
+
+func TestColorProbeArgs(t *testing.T) {
+	args := colorProbeArgs("in.mkv", colorSamplePoints(4000))
+	i := 0
+	for i < len(args) && args[i] != "-read_intervals" {
+		i++
+	}
+	if i+1 >= len(args) {
+		t.Fatalf("colorProbeArgs() has no read intervals: %#v", args)
+	}
+	if want := "%+#1,1000%+#1,2000%+#1,3000%+#1"; args[i+1] != want {
+		t.Errorf("read intervals = %q, want %q", args[i+1], want)
+	}
+	if got := colorSamplePoints(0); !cmp.Equal(got, []float64{0}) {
+		t.Errorf("colorSamplePoints(0) = %v, want only the start", got)
+	}
+}
+
+func TestMergeColorFrames(t *testing.T) {
+	broken := masteringSideData
+	broken.Max_luminance = "0/0"
+	testCases := []struct {
+		desc     string
+		frames   []codec.ColorInfo
+		expected codec.ColorInfo
+	}{
+		{desc: "no frames"},
+		{
+			desc: "first frame complete",
+			frames: []codec.ColorInfo{
+				{Color_space: "bt2020nc", Color_primaries: "bt2020", Color_transfer: "smpte2084", Side_data_list: []codec.ColorSideInfo{masteringSideData, lightLevelSideData}},
+				{Color_space: "bt709", Color_primaries: "bt709", Color_transfer: "bt709"},
+			},
+			expected: codec.ColorInfo{Color_space: "bt2020nc", Color_primaries: "bt2020", Color_transfer: "smpte2084", Side_data_list: []codec.ColorSideInfo{masteringSideData, lightLevelSideData}},
+		},
+		{
+			desc: "filled from later frames",
+			frames: []codec.ColorInfo{
+				{Color_space: "unknown", Color_transfer: "smpte2084", Side_data_list: []codec.ColorSideInfo{broken}},
+				{Color_space: "bt2020nc", Color_primaries: "bt2020", Side_data_list: []codec.ColorSideInfo{masteringSideData}},
+				{Side_data_list: []codec.ColorSideInfo{masteringSideData, {Side_data_type: codec.SideDataTypeLightLevel}}},
+			},
+			expected: codec.ColorInfo{Color_space: "bt2020nc", Color_primaries: "bt2020", Color_transfer: "smpte2084", Side_data_list: []codec.ColorSideInfo{masteringSideData}},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(tc.expected, mergeColorFrames(tc.frames)); diff != "" {
+				t.Errorf("%q: unexpected color: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestFillStreamColor(t *testing.T) {
+	inv := &FfprobeOutput{Streams: []FfprobeStreams{
+		{Codec_type: "video", Disposition: map[string]int{"attached_pic": 1}, Color_space: "bt709"},
+		{Codec_type: "video", Color_space: "bt2020nc", Color_primaries: "bt2020", Color_transfer: "arib-std-b67"},
+	}}
+	ci := codec.ColorInfo{Color_transfer: "smpte2084"}
+	fillStreamColor(&ci, inv)
+	want := codec.ColorInfo{Color_space: "bt2020nc", Color_primaries: "bt2020", Color_transfer: "smpte2084"}
+	if diff := cmp.Diff(want, ci); diff != "" {
+		t.Errorf("unexpected color: %s", diff)
+	}
+	fillStreamColor(&ci, nil)
+	if diff := cmp.Diff(want, ci); diff != "" {
+		t.Errorf("unexpected color without an inventory: %s", diff)
+	}
+}
+
+func TestParseLightLevel(t *testing.T) {
+	out := []byte(`frame:0    pts:0       pts_time:0
+lavfi.signalstats.YMAX=6553.5
+frame:0    pts:0       pts_time:0
+lavfi.signalstats.YAVG=1310.7
+frame:1    pts:1001    pts_time:0.041708
+lavfi.signalstats.YMAX=3276
+frame:1    pts:1001    pts_time:0.041708
+lavfi.signalstats.YAVG=2621.4
+`)
+	y, avg, err := parseLightLevel(out)
+	if err != nil {
+		t.Fatalf("parseLightLevel() unexpected error: %v", err)
+	}
+	if got := nits(y); got != 1000 {
+		t.Errorf("MaxCLL = %d, want 1000", got)
+	}
+	if got := nits(avg); got != 400 {
+		t.Errorf("MaxFALL = %d, want 400", got)
+	}
+	if _, _, err := parseLightLevel([]byte("Conversion failed!")); err == nil {
+		t.Errorf("parseLightLevel() expected an error without signalstats")
+	}
+	args := strings.Join(lightLevelArgs("in.mkv"), " ")
+	if strings.Contains(args, "-ss") || strings.Contains(args, "-frames") {
+		t.Errorf("lightLevelArgs() samples the source: %q", args)
+	}
+	if !strings.Contains(args, "blend=all_mode=lighten") {
+		t.Errorf("lightLevelArgs() doesn't measure max(R,G,B): %q", args)
+	}
+}
+
+func TestMeasureLightLevelCopy(t *testing.T) {
+	inv := &FfprobeOutput{}
+	tr := TranscodeRequest{Codec: "copy"}
+	if err := MeasureLightLevel(context.Background(), tr, "missing.mkv", inv); err != nil {
+		t.Fatalf("MeasureLightLevel() unexpected error: %v", err)
+	}
+	if inv.Light_level != nil {
+		t.Errorf("MeasureLightLevel() measured a copy: %#v", inv.Light_level)
+	}
+}
+
+func TestNeedsLightLevel(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		ci       codec.ColorInfo
+		expected bool
+	}{
+		{desc: "pq without light level", ci: codec.ColorInfo{Color_transfer: "smpte2084", Side_data_list: []codec.ColorSideInfo{masteringSideData}}, expected: true},
+		{desc: "pq with light level", ci: codec.ColorInfo{Color_transfer: "smpte2084", Side_data_list: []codec.ColorSideInfo{lightLevelSideData}}},
+		{desc: "hlg", ci: codec.ColorInfo{Color_transfer: "arib-std-b67"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if got := needsLightLevel(tc.ci); got != tc.expected {
+				t.Errorf("%q: needsLightLevel() = %v, want %v", tc.desc, got, tc.expected)
+			}
+		})
+	}
+}
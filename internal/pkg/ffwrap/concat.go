@@ -0,0 +1,378 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+
+	"github.com/google/logger"
+)
+
+// JobTypeConcat joins Sources, in order, into a single output. The parts are
+// expected to match, every analysis of the source and the color of the output
+// only consider the first of them.
+const JobTypeConcat = "concat"
+
+// validateConcat checks the sources of a concat job and that nothing needs to
+// be aligned with a single source.
+func (tr TranscodeRequest) validateConcat() error {
+	switch {
+	case len(tr.Sources) < 2:
+		return fmt.Errorf("concat jobs require at least two sources")
+	case tr.Source != tr.Sources[0]:
+		return fmt.Errorf("source %q must be the first of the concat sources", tr.Source)
+	case len(tr.Outputs) > 0, tr.Packaging != nil:
+		return fmt.Errorf("concat jobs write a single output")
+	case len(tr.Srt_files) > 0, len(tr.Audio_files) > 0:
+		return fmt.Errorf("concat jobs can't add subtitle or audio files")
+	case tr.trimmed():
+		return fmt.Errorf("concat jobs can't be trimmed")
+	}
+	for _, s := range tr.Sources {
+		if s == "" {
+			return fmt.Errorf("concat sources cannot be empty")
+		}
+		if s == tr.Destination {
+			return fmt.Errorf("destination %q is one of the concat sources", s)
+		}
+	}
+	return nil
+}
+
+// concatStreams returns the streams of a source that are joined, leaving out
+// cover art, attachments and data streams.
+func concatStreams(inv FfprobeOutput) []FfprobeStreams {
+	var streams []FfprobeStreams
+	for _, s := range inv.Streams {
+		switch s.Codec_type {
+		case "video":
+			if !isAttachedPicture(s) {
+				streams = append(streams, s)
+			}
+		case "audio", "subtitle":
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// streamSignature describes the properties of a stream that have to be equal
+// for the concat demuxer to join it without re-encoding.
+func streamSignature(s FfprobeStreams) string {
+	switch s.Codec_type {
+	case "video":
+		return fmt.Sprintf("video %s %dx%d %s %s", s.Codec, s.Width, s.Height, s.Pix_fmt, s.R_frame_rate)
+	case "audio":
+		return fmt.Sprintf("audio %s %s %d", s.Codec, s.Sample_rate, s.Channels)
+	}
+	return s.Codec_type + " " + s.Codec
+}
+
+// countStreams returns the number of streams of the given type.
+func countStreams(streams []FfprobeStreams, codecType string) int {
+	var n int
+	for _, s := range streams {
+		if s.Codec_type == codecType {
+			n++
+		}
+	}
+	return n
+}
+
+// concatDemuxable reports whether the sources can be joined by the concat
+// demuxer, which requires identical streams. Sources whose streams differ are
+// joined by the concat filter, which requires the same number of video and
+// audio streams in every source; otherwise the layouts are incompatible.
+func concatDemuxable(sources []string, invs []FfprobeOutput) (bool, error) {
+	first := concatStreams(invs[0])
+	videos, audios := countStreams(first, "video"), countStreams(first, "audio")
+	if videos == 0 {
+		return false, fmt.Errorf("concat source %q has no video stream", sources[0])
+	}
+	demux := true
+	for i, inv := range invs[1:] {
+		streams := concatStreams(inv)
+		if v, a := countStreams(streams, "video"), countStreams(streams, "audio"); v != videos || a != audios {
+			return false, fmt.Errorf("stream layouts differ: %q has %d video and %d audio streams, %q has %d and %d",
+				sources[0], videos, audios, sources[i+1], v, a)
+		}
+		if len(streams) != len(first) {
+			demux = false
+			continue
+		}
+		for j, s := range streams {
+			if streamSignature(s) != streamSignature(first[j]) {
+				demux = false
+			}
+		}
+	}
+	return demux, nil
+}
+
+// concatList returns an ffconcat script listing the sources in order.
+func concatList(sources []string) string {
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	for _, s := range sources {
+		fmt.Fprintf(&b, "file '%s'\n", strings.ReplaceAll(s, "'", `'\''`))
+	}
+	return b.String()
+}
+
+// concatChapters returns an ffmetadata file with a chapter for every source,
+// titled after the source's title tag or its position.
+func concatChapters(invs []FfprobeOutput) string {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	var start int64
+	for i, inv := range invs {
+		d, _ := strconv.ParseFloat(inv.Format.Duration, 64)
+		end := start + int64(d*1000)
+		title := inv.Format.Tags["title"]
+		if title == "" {
+			title = fmt.Sprintf("Part %d", i+1)
+		}
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", start, end, escapeFfmetadata(title))
+		start = end
+	}
+	return b.String()
+}
+
+// concatDuration returns the duration in seconds of the parts joined together,
+// parts of unknown duration count as 0.
+func concatDuration(invs []FfprobeOutput) float64 {
+	var d float64
+	for _, inv := range invs {
+		part, _ := ParseDuration(inv.Format.Duration)
+		d += part
+	}
+	return d
+}
+
+// ConcatDuration probes every source of a concat job and returns the duration
+// in seconds of their concatenation.
+func ConcatDuration(ctx context.Context, sources []string) (float64, error) {
+	invs := make([]FfprobeOutput, 0, len(sources))
+	for _, s := range sources {
+		inv, err := ProbeSource(ctx, s)
+		if err != nil {
+			return 0, fmt.Errorf("failed to probe concat source: %w", err)
+		}
+		invs = append(invs, inv)
+	}
+	return concatDuration(invs), nil
+}
+
+// escapeFfmetadata escapes the characters with a special meaning in ffmetadata files.
+func escapeFfmetadata(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(s)
+}
+
+// withConcatChapters returns the metadata options of a concat job, importing
+// the chapters at each join unless the request manages chapters itself.
+func (tr TranscodeRequest) withConcatChapters(chapters string) *MetadataOptions {
+	m := MetadataOptions{}
+	if tr.Metadata != nil {
+		m = *tr.Metadata
+	}
+	if m.Chapters_file == "" && !strings.EqualFold(m.Chapters, ChaptersDrop) {
+		m.Chapters_file = chapters
+	}
+	return &m
+}
+
+// buildConcatDemuxArgs generates the arguments joining sources with identical
+// streams through the concat demuxer. The joined sources are treated as one
+// source with the streams of the first.
+func buildConcatDemuxArgs(tr TranscodeRequest, list, chapters string, streams []FfprobeStreams, colorMeta codec.ColorInfo) []string {
+	joined := tr
+	joined.Source = list
+	joined.Job_type = ""
+	joined.Metadata = tr.withConcatChapters(chapters)
+	args := buildTranscodeArgs(joined, streams, colorMeta)
+	// the demuxer options precede the first input
+	i := len(ffquiet) + len(ffcommon)
+	return append(append(append([]string{}, args[:i]...), "-f", "concat", "-safe", "0"), args[i:]...)
+}
+
+// buildConcatFilterArgs generates the arguments joining sources whose streams
+// differ with the concat filter. Every source is scaled, padded and resampled
+// to the format of the first, only video and audio are kept and the result is
+// re-encoded.
+func buildConcatFilterArgs(tr TranscodeRequest, invs []FfprobeOutput, chapters string, colorMeta codec.ColorInfo) []string {
+	container := tr.outputContainer()
+	first := concatStreams(invs[0])
+	var video FfprobeStreams
+	var audio []FfprobeStreams
+	for _, s := range first {
+		switch {
+		case s.Codec_type == "video" && video.Codec_type == "":
+			video = s
+		case s.Codec_type == "audio":
+			audio = append(audio, s)
+		}
+	}
+
+	vf := []string{
+		fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease", video.Width, video.Height),
+		fmt.Sprintf("pad=%d:%d:(ow-iw)/2:(oh-ih)/2", video.Width, video.Height),
+		"setsar=1",
+	}
+	if tr.Frame_rate != "" {
+		vf = append(vf, "fps="+tr.Frame_rate)
+	} else if video.R_frame_rate != "" && parseRational(video.R_frame_rate) > 0 {
+		vf = append(vf, "fps="+video.R_frame_rate)
+	}
+	if video.Pix_fmt != "" {
+		vf = append(vf, "format="+video.Pix_fmt)
+	}
+	var graph, segments []string
+	for n := range invs {
+		graph = append(graph, fmt.Sprintf("[%d:v:0]%s[v%d]", n, strings.Join(vf, ","), n))
+		segments = append(segments, fmt.Sprintf("[v%d]", n))
+		for k, a := range audio {
+			var af []string
+			if a.Sample_rate != "" {
+				af = append(af, "aresample="+a.Sample_rate)
+			}
+			if a.Channel_layout != "" {
+				af = append(af, "aformat=channel_layouts="+a.Channel_layout)
+			}
+			if len(af) == 0 {
+				af = append(af, "anull")
+			}
+			graph = append(graph, fmt.Sprintf("[%d:a:%d]%s[a%d_%d]", n, k, strings.Join(af, ","), n, k))
+			segments = append(segments, fmt.Sprintf("[a%d_%d]", n, k))
+		}
+	}
+	outputs := "[vcat]"
+	maps := []string{"-map", "[v]"}
+	for k := range audio {
+		outputs += fmt.Sprintf("[a%d]", k)
+		maps = append(maps, "-map", fmt.Sprintf("[a%d]", k))
+	}
+	graph = append(graph, fmt.Sprintf("%sconcat=n=%d:v=1:a=%d%s", strings.Join(segments, ""), len(invs), len(audio), outputs))
+	if vf := joinFilters(tr.Video_filters, tr.toneMapFilter(colorMeta), tr.hdr10PlusFilter(colorMeta), tr.uploadFilter()); vf != "" {
+		graph = append(graph, "[vcat]"+vf+"[v]")
+	} else {
+		graph = append(graph, "[vcat]null[v]")
+	}
+
+	args := append(append([]string{}, ffquiet...), ffcommon...)
+	args = append(args, tr.deviceArgs()...)
+	for _, s := range tr.Sources {
+		args = append(args, "-i", s)
+	}
+	m := tr.withConcatChapters(chapters)
+	args = append(args, m.inputArgs()...)
+	args = append(args, "-filter_complex", strings.Join(graph, ";"))
+	args = append(args, tr.codecArgs(colorMeta)...)
+	args = append(args, tr.hdrArgs(detectHdr(invs[0].Streams, colorMeta), colorMeta)...)
+	as := AudioSettings{Codec: "copy"}
+	if tr.Audio != nil {
+		as = *tr.Audio
+	}
+	for k, a := range audio {
+		r := as.settingsFor(a)
+		if r.Codec == "" || strings.EqualFold(r.Codec, "copy") {
+			// filtered audio can't be copied
+			r = fallbackAudio(container)
+		}
+		args = append(args, r.encoderArgs(fmt.Sprintf("a:%d", k), a.Channels, tr.Audio_filters)...)
+	}
+	args = append(args, maps...)
+	args = append(args, m.outputArgs(len(invs), 1, nil, container)...)
+	args = append(args, tr.containerArgs()...)
+	return append(args, tr.Destination)
+}
+
+// writeTempFile writes content to a new temporary file and returns its path.
+func writeTempFile(pattern, content string) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// concatTranscode probes every source of a concat job and joins them with the
+// concat demuxer when their streams match or the concat filter otherwise.
+func concatTranscode(ctx context.Context, tr TranscodeRequest) ([]string, error) {
+	invs := make([]FfprobeOutput, 0, len(tr.Sources))
+	for _, s := range tr.Sources {
+		inv, err := ProbeSource(ctx, s)
+		if err != nil {
+			return nil, fmt.Errorf("failed to probe concat source: %w", err)
+		}
+		invs = append(invs, inv)
+	}
+	demux, err := concatDemuxable(tr.Sources, invs)
+	if err != nil {
+		return nil, err
+	}
+	colorMeta, err := tr.sourceColor(ctx, tr.Sources[0], &invs[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := tr.checkHdr(detectHdr(invs[0].Streams, colorMeta), colorMeta); err != nil {
+		return nil, err
+	}
+
+	chapters, err := writeTempFile("concat-chapters-*.txt", concatChapters(invs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to write concat chapters: %w", err)
+	}
+	defer os.Remove(chapters)
+
+	var args []string
+	if demux {
+		sources := make([]string, 0, len(tr.Sources))
+		for _, s := range tr.Sources {
+			abs, err := filepath.Abs(s)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, abs)
+		}
+		list, err := writeTempFile("concat-*.txt", concatList(sources))
+		if err != nil {
+			return nil, fmt.Errorf("failed to write concat list: %w", err)
+		}
+		defer os.Remove(list)
+		logger.Infof("joining %d sources with the concat demuxer", len(sources))
+		args = buildConcatDemuxArgs(tr, list, chapters, invs[0].Streams, colorMeta)
+	} else {
+		if strings.EqualFold(tr.Codec, "copy") {
+			return nil, fmt.Errorf("concat sources have different stream parameters and can't be joined without encoding")
+		}
+		logger.Infof("joining %d sources with the concat filter", len(tr.Sources))
+		args = buildConcatFilterArgs(tr, invs, chapters, colorMeta)
+	}
+	if err := runFfmpeg(ctx, args, tr.LogDestination); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
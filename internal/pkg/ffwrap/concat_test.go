@@ -0,0 +1,145 @@
+package ffwrap
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	part1080 = FfprobeOutput{
+		Streams: []FfprobeStreams{
+			{Index: 0, Codec: "h264", Codec_type: "video", Width: 1920, Height: 1080, Pix_fmt: "yuv420p", R_frame_rate: "24000/1001"},
+			{Index: 1, Codec: "ac3", Codec_type: "audio", Channels: 6, Channel_layout: "5.1(side)", Sample_rate: "48000", Tags: FfprobeTags{Language: "eng"}},
+		},
+		Format: FfprobeFormat{Duration: "1200.5"},
+	}
+	part720 = FfprobeOutput{
+		Streams: []FfprobeStreams{
+			{Index: 0, Codec: "h264", Codec_type: "video", Width: 1280, Height: 720, Pix_fmt: "yuv420p", R_frame_rate: "30/1"},
+			{Index: 1, Codec: "aac", Codec_type: "audio", Channels: 2, Sample_rate: "44100", Tags: FfprobeTags{Language: "eng"}},
+		},
+		Format: FfprobeFormat{Duration: "600", Tags: map[string]string{"title": "Disc 2; Extras"}},
+	}
+	partNoAudio = FfprobeOutput{
+		Streams: []FfprobeStreams{{Index: 0, Codec: "h264", Codec_type: "video", Width: 1920, Height: 1080}},
+	}
+)
+
+func TestValidateConcat(t *testing.T) {
+	sources := []string{"/disc1.mkv", "/disc2.mkv"}
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		shouldError bool
+	}{
+		{desc: "two sources", request: TranscodeRequest{Source: "/disc1.mkv", Sources: sources, Destination: "/film.mkv", Codec: "copy", Job_type: "concat"}},
+		{desc: "one source", request: TranscodeRequest{Source: "/disc1.mkv", Sources: sources[:1], Destination: "/film.mkv", Job_type: "concat"}, shouldError: true},
+		{desc: "source not first", request: TranscodeRequest{Source: "/disc2.mkv", Sources: sources, Destination: "/film.mkv", Job_type: "concat"}, shouldError: true},
+		{desc: "destination is a source", request: TranscodeRequest{Source: "/disc1.mkv", Sources: sources, Destination: "/disc2.mkv", Job_type: "concat"}, shouldError: true},
+		{desc: "trimmed", request: TranscodeRequest{Source: "/disc1.mkv", Sources: sources, Destination: "/film.mkv", Start: 30, Job_type: "concat"}, shouldError: true},
+		{
+			desc:        "subtitle file",
+			request:     TranscodeRequest{Source: "/disc1.mkv", Sources: sources, Destination: "/film.mkv", Srt_files: []SubtitleFile{{Path: "/subs.srt"}}, Job_type: "concat"},
+			shouldError: true,
+		},
+		{desc: "sources without concat", request: TranscodeRequest{Source: "/disc1.mkv", Sources: sources, Destination: "/film.mkv"}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.request.validateJobType()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validateJobType() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
+
+func TestConcatDemuxable(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		invs        []FfprobeOutput
+		expected    bool
+		shouldError bool
+	}{
+		{desc: "identical streams", invs: []FfprobeOutput{part1080, part1080}, expected: true},
+		{desc: "different resolution and audio", invs: []FfprobeOutput{part1080, part720}},
+		{desc: "missing audio", invs: []FfprobeOutput{part1080, partNoAudio}, shouldError: true},
+		{desc: "no video", invs: []FfprobeOutput{{}, part1080}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			got, err := concatDemuxable([]string{"/a.mkv", "/b.mkv"}, tc.invs)
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("%q: concatDemuxable() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+			if got != tc.expected {
+				t.Errorf("%q: concatDemuxable() = %v, want %v", tc.desc, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestConcatFiles(t *testing.T) {
+	list := "ffconcat version 1.0\nfile '/media/disc1.mkv'\nfile '/media/director'\\''s cut.mkv'\n"
+	if diff := cmp.Diff(list, concatList([]string{"/media/disc1.mkv", "/media/director's cut.mkv"})); diff != "" {
+		t.Errorf("unexpected concat list: %s", diff)
+	}
+	chapters := `;FFMETADATA1
+
+[CHAPTER]
+TIMEBASE=1/1000
+START=0
+END=1200500
+title=Part 1
+
+[CHAPTER]
+TIMEBASE=1/1000
+START=1200500
+END=1800500
+title=Disc 2\; Extras
+`
+	if diff := cmp.Diff(chapters, concatChapters([]FfprobeOutput{part1080, part720})); diff != "" {
+		t.Errorf("unexpected concat chapters: %s", diff)
+	}
+	if d := concatDuration([]FfprobeOutput{part1080, part720}); d != 1800.5 {
+		t.Errorf("concatDuration() = %v, want 1800.5", d)
+	}
+}
+
+func TestBuildConcatArgs(t *testing.T) {
+	common := []string{"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M"}
+	tr := TranscodeRequest{Source: "/disc1.mkv", Sources: []string{"/disc1.mkv", "/disc2.mkv"}, Destination: "/film.mkv", Codec: "copy", Job_type: JobTypeConcat}
+
+	demux := slices.Concat(common,
+		[]string{"-f", "concat", "-safe", "0", "-i", "/tmp/list.txt", "-f", "ffmetadata", "-i", "/tmp/chapters.txt"},
+		[]string{"-c:v", "copy", "-c:a:0", "copy", "-c:s", "copy", "-c:t", "copy"},
+		[]string{"-map", "0:v:0", "-map", "0:1", "-map", "0:t:?", "-map_chapters", "1", "/film.mkv"},
+	)
+	if diff := cmp.Diff(demux, buildConcatDemuxArgs(tr, "/tmp/list.txt", "/tmp/chapters.txt", part1080.Streams, codec.ColorInfo{})); diff != "" {
+		t.Errorf("unexpected concat demuxer args: %s", diff)
+	}
+
+	tr.Codec = "libx265"
+	tr.Crf = 20
+	tr.Video_filters = "crop=1920:800:0:140"
+	scale := "scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=24000/1001,format=yuv420p"
+	audio := "aresample=48000,aformat=channel_layouts=5.1(side)"
+	filter := slices.Concat(common,
+		[]string{"-i", "/disc1.mkv", "-i", "/disc2.mkv", "-f", "ffmetadata", "-i", "/tmp/chapters.txt"},
+		[]string{"-filter_complex",
+			"[0:v:0]" + scale + "[v0];[0:a:0]" + audio + "[a0_0];" +
+				"[1:v:0]" + scale + "[v1];[1:a:0]" + audio + "[a1_0];" +
+				"[v0][a0_0][v1][a1_0]concat=n=2:v=1:a=1[vcat][a0];[vcat]crop=1920:800:0:140[v]"},
+		mustBuildCodec("libx265", 20, codec.ColorInfo{}),
+		[]string{"-c:a:0", "eac3"},
+		[]string{"-map", "[v]", "-map", "[a0]", "-map_chapters", "2", "/film.mkv"},
+	)
+	if diff := cmp.Diff(filter, buildConcatFilterArgs(tr, []FfprobeOutput{part1080, part720}, "/tmp/chapters.txt", codec.ColorInfo{})); diff != "" {
+		t.Errorf("unexpected concat filter args: %s", diff)
+	}
+}
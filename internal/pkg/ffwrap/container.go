@@ -0,0 +1,243 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/logger"
+)
+
+const (
+	ContainerMkv  = "mkv"
+	ContainerMp4  = "mp4"
+	ContainerMov  = "mov"
+	ContainerWebm = "webm"
+)
+
+var (
+	// containerExtensions maps destination file extensions to the container they imply.
+	containerExtensions = map[string]string{
+		".mkv":  ContainerMkv,
+		".mp4":  ContainerMp4,
+		".m4v":  ContainerMp4,
+		".mov":  ContainerMov,
+		".webm": ContainerWebm,
+	}
+
+	// muxers maps containers to the ffmpeg muxer forced when a container is requested.
+	muxers = map[string]string{
+		ContainerMkv:  "matroska",
+		ContainerMp4:  "mp4",
+		ContainerMov:  "mov",
+		ContainerWebm: "webm",
+	}
+
+	// containerAudioEncoders lists the request audio codecs each restricted
+	// container can hold, containers without an entry accept every codec.
+	containerAudioEncoders = map[string]map[string]bool{
+		ContainerMp4:  {"copy": true, "aac": true, "ac3": true, "eac3": true, "flac": true, "opus": true},
+		ContainerMov:  {"copy": true, "aac": true, "ac3": true, "eac3": true, "flac": true, "opus": true},
+		ContainerWebm: {"copy": true, "opus": true},
+		SegmentTs:     {"copy": true, "aac": true, "ac3": true, "eac3": true},
+	}
+
+	// containerAudioCodecs lists the source audio codecs each restricted
+	// container can hold without re-encoding.
+	containerAudioCodecs = map[string]map[string]bool{
+		ContainerMp4:  {"aac": true, "ac3": true, "eac3": true, "flac": true, "opus": true, "mp3": true, "alac": true},
+		ContainerMov:  {"aac": true, "ac3": true, "eac3": true, "flac": true, "opus": true, "mp3": true, "alac": true},
+		ContainerWebm: {"opus": true, "vorbis": true},
+		SegmentTs:     {"aac": true, "ac3": true, "eac3": true, "mp3": true},
+	}
+
+	// textSubtitles are the subtitle codecs that can be converted between each other.
+	textSubtitles = map[string]bool{
+		"subrip":   true,
+		"ass":      true,
+		"webvtt":   true,
+		"mov_text": true,
+	}
+)
+
+// outputContainer returns the container of the request's output, the
+// requested container or else the one implied by the destination extension,
+// or an empty string when it can't be determined.
+func (tr TranscodeRequest) outputContainer() string {
+	if tr.Container != "" {
+		return strings.ToLower(tr.Container)
+	}
+	return containerExtensions[strings.ToLower(filepath.Ext(tr.Destination))]
+}
+
+// allowsAttachments reports whether the container can store attachments such
+// as fonts, only matroska can.
+func allowsAttachments(container string) bool {
+	return container == ContainerMkv || container == ""
+}
+
+// isWebmVideoEncoder reports whether the video encoder produces VP9 or AV1.
+func isWebmVideoEncoder(enc string) bool {
+	enc = strings.ToLower(ffmpegEncoder(enc))
+	return strings.Contains(enc, "av1") || strings.Contains(enc, "vp9")
+}
+
+// fallbackAudio returns the encoder settings for source audio that can't be
+// copied into the container.
+func fallbackAudio(container string) AudioRule {
+	switch container {
+	case ContainerWebm:
+		return AudioRule{Codec: "opus"}
+	case SegmentTs:
+		// aac is the audio every hls player decodes from ts segments
+		return AudioRule{Codec: "aac"}
+	}
+	return AudioRule{Codec: "eac3"}
+}
+
+// audioForContainer replaces a copy of a source track the container can't
+// store with an encode to a codec it can.
+func audioForContainer(r AudioRule, s FfprobeStreams, container string) AudioRule {
+	allowed, restricted := containerAudioCodecs[container]
+	if !restricted || (r.Codec != "" && !strings.EqualFold(r.Codec, "copy")) || allowed[strings.ToLower(s.Codec)] {
+		return r
+	}
+	return fallbackAudio(container)
+}
+
+// containerArgs returns the muxer options of the request's container.
+func (tr TranscodeRequest) containerArgs() []string {
+	var args []string
+	c := tr.outputContainer()
+	if tr.Container != "" {
+		args = append(args, "-f", muxers[c])
+	}
+	if c == ContainerMp4 || c == ContainerMov {
+		args = append(args, "-movflags", "+faststart")
+	}
+	return args
+}
+
+// validateContainer checks the requested container and rejects video and
+// audio codecs the output container can't hold.
+func (tr TranscodeRequest) validateContainer() error {
+	if tr.Container != "" {
+		c := strings.ToLower(tr.Container)
+		if _, ok := muxers[c]; !ok {
+			return fmt.Errorf("unsupported container %q", tr.Container)
+		}
+		if tr.Packaging != nil {
+			return fmt.Errorf("container cannot be combined with packaging")
+		}
+		for _, d := range tr.Destinations() {
+			if e, ok := containerExtensions[strings.ToLower(filepath.Ext(d))]; ok && e != c {
+				return fmt.Errorf("destination %q does not match container %s", d, c)
+			}
+		}
+	}
+	if tr.Packaging != nil {
+		return tr.validateAudioEncoders(tr.Packaging.segmentContainer())
+	}
+	for _, o := range tr.renditions() {
+		c := o.outputContainer()
+		if c == ContainerWebm && !strings.EqualFold(o.Codec, "copy") && !isWebmVideoEncoder(o.Codec) {
+			return fmt.Errorf("webm only supports VP9 and AV1 video, not %s", o.Codec)
+		}
+		if err := o.validateAudioEncoders(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateAudioEncoders rejects audio codecs of the request and its audio
+// files that the container can't hold.
+func (tr TranscodeRequest) validateAudioEncoders(c string) error {
+	allowed, restricted := containerAudioEncoders[c]
+	if !restricted {
+		return nil
+	}
+	codecs := []string{}
+	if tr.Audio != nil {
+		codecs = append(codecs, tr.Audio.Codec)
+		for _, r := range tr.Audio.Rules {
+			codecs = append(codecs, r.Codec)
+		}
+	}
+	for _, af := range tr.Audio_files {
+		codecs = append(codecs, af.Codec)
+	}
+	for _, ac := range codecs {
+		if ac == "" {
+			ac = "copy"
+		}
+		if !allowed[strings.ToLower(ac)] {
+			return fmt.Errorf("%s audio can't be stored in %s", ac, c)
+		}
+	}
+	return nil
+}
+
+// subtitleCodec returns the encoder for the source subtitles stored in the
+// container, text subtitles are converted where the container requires it.
+func subtitleCodec(container string) string {
+	switch container {
+	case ContainerMp4, ContainerMov:
+		return "mov_text"
+	case ContainerWebm:
+		return "webvtt"
+	}
+	return "copy"
+}
+
+// storableSubtitles drops the source subtitle streams the container can't
+// store, such as bitmap subtitles in MP4.
+func storableSubtitles(subtitles []FfprobeStreams, container string) []FfprobeStreams {
+	var kept []FfprobeStreams
+	for _, s := range subtitles {
+		if _, err := subtitleEncoder(s.Codec, container); err != nil {
+			logger.Warningf("dropping subtitle stream %d: %v", s.Index, err)
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept
+}
+
+// subtitleEncoder returns the encoder used to store a subtitle stream of the
+// given codec in container. An error is returned when the container can't
+// hold the subtitle, for example bitmap subtitles in MP4.
+func subtitleEncoder(subCodec, container string) (string, error) {
+	switch container {
+	case ContainerMp4, ContainerMov:
+		if subCodec == "mov_text" {
+			return "copy", nil
+		}
+		if textSubtitles[subCodec] {
+			return "mov_text", nil
+		}
+	case ContainerWebm:
+		if subCodec == "webvtt" {
+			return "copy", nil
+		}
+		if textSubtitles[subCodec] {
+			return "webvtt", nil
+		}
+	default:
+		return "copy", nil
+	}
+	return "", fmt.Errorf("%s subtitles can't be stored in %s", subCodec, container)
+}
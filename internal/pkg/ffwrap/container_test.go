@@ -0,0 +1,107 @@
+package ffwrap
+
+import (
+	"testing"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateContainer(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		shouldError bool
+	}{
+		{desc: "mkv from extension", request: TranscodeRequest{Destination: "/a.mkv", Codec: "libx265", Audio: &AudioSettings{Codec: "flac"}}},
+		{desc: "explicit mp4", request: TranscodeRequest{Destination: "/a.mp4", Container: "MP4", Codec: "libx265", Audio: &AudioSettings{Codec: "aac"}}},
+		{desc: "explicit container without extension", request: TranscodeRequest{Destination: "/a.out", Container: "mkv", Codec: "libx265"}},
+		{desc: "webm av1 and opus", request: TranscodeRequest{Destination: "/a.webm", Codec: "libsvtav1", Audio: &AudioSettings{Codec: "opus"}}},
+		{desc: "webm copy", request: TranscodeRequest{Destination: "/a.webm", Codec: "copy"}},
+		{desc: "unknown container", request: TranscodeRequest{Destination: "/a.avi", Container: "avi", Codec: "libx265"}, shouldError: true},
+		{desc: "extension mismatch", request: TranscodeRequest{Destination: "/a.mkv", Container: "mp4", Codec: "libx265"}, shouldError: true},
+		{desc: "webm hevc", request: TranscodeRequest{Destination: "/a.webm", Codec: "libx265"}, shouldError: true},
+		{desc: "webm aac", request: TranscodeRequest{Destination: "/a.webm", Codec: "libsvtav1", Audio: &AudioSettings{Codec: "aac"}}, shouldError: true},
+		{
+			desc:        "webm ac3 rule",
+			request:     TranscodeRequest{Destination: "/a.webm", Codec: "libsvtav1", Audio: &AudioSettings{Codec: "opus", Rules: []AudioRule{{Match_commentary: true, Codec: "ac3"}}}},
+			shouldError: true,
+		},
+		{desc: "webm rendition", request: TranscodeRequest{Destination: "/a.mkv", Codec: "libx265", Outputs: []Rendition{{Destination: "/b.webm"}}}, shouldError: true},
+		{
+			desc:        "container with packaging",
+			request:     TranscodeRequest{Destination: "/www", Container: "mp4", Codec: "libx265", Packaging: &Packaging{Format: "hls"}},
+			shouldError: true,
+		},
+		{
+			desc:    "ts segments with eac3",
+			request: TranscodeRequest{Destination: "/www", Codec: "libx265", Audio: &AudioSettings{Codec: "eac3"}, Packaging: &Packaging{Format: "hls", Segment_type: "ts"}},
+		},
+		{
+			desc:        "ts segments with flac",
+			request:     TranscodeRequest{Destination: "/www", Codec: "libx265", Audio: &AudioSettings{Codec: "flac"}, Packaging: &Packaging{Format: "hls", Segment_type: "ts"}},
+			shouldError: true,
+		},
+		{
+			desc:        "ts segments with an opus audio file",
+			request:     TranscodeRequest{Destination: "/www", Codec: "libx265", Audio_files: []AudioFile{{Path: "/dub.opus", Codec: "opus"}}, Packaging: &Packaging{Format: "hls", Segment_type: "ts"}},
+			shouldError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.request.validateContainer()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validateContainer() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
+
+func TestContainerOutputArgs(t *testing.T) {
+	streams := []FfprobeStreams{
+		{Index: 0, Codec: "vp9", Codec_type: "video"},
+		{Index: 1, Codec: "truehd", Codec_type: "audio", Channels: 2, Tags: FfprobeTags{Language: "eng"}},
+		{Index: 2, Codec: "ass", Codec_type: "subtitle", Tags: FfprobeTags{Language: "eng"}},
+		{Index: 3, Codec: "hdmv_pgs_subtitle", Codec_type: "subtitle", Tags: FfprobeTags{Language: "eng"}},
+	}
+	tr := TranscodeRequest{Source: "/src.mkv", Destination: "/dst.out", Container: "webm", Codec: "copy"}
+	expected := []string{
+		"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+		"-i", "/src.mkv",
+		"-c:v", "copy",
+		"-c:a:0", "libopus",
+		"-c:s", "webvtt",
+		"-map", "0:v:0", "-map", "0:1", "-map", "0:2",
+		"-f", "webm",
+		"/dst.out",
+	}
+	if diff := cmp.Diff(expected, buildTranscodeArgs(tr, streams, codec.ColorInfo{})); diff != "" {
+		t.Errorf("unexpected webm args: %s", diff)
+	}
+}
+
+func TestAudioForContainer(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		track     FfprobeStreams
+		container string
+		expected  AudioRule
+	}{
+		{desc: "flac copied to mkv", track: FfprobeStreams{Codec: "flac"}, container: ContainerMkv},
+		{desc: "flac copied to fmp4 segments", track: FfprobeStreams{Codec: "flac"}, container: ContainerMp4},
+		{desc: "flac in ts segments", track: FfprobeStreams{Codec: "flac"}, container: SegmentTs, expected: AudioRule{Codec: "aac"}},
+		{desc: "opus in ts segments", track: FfprobeStreams{Codec: "opus"}, container: SegmentTs, expected: AudioRule{Codec: "aac"}},
+		{desc: "ac3 copied to ts segments", track: FfprobeStreams{Codec: "ac3"}, container: SegmentTs},
+		{desc: "truehd in mp4", track: FfprobeStreams{Codec: "truehd"}, container: ContainerMp4, expected: AudioRule{Codec: "eac3"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(tc.expected, audioForContainer(AudioRule{}, tc.track, tc.container)); diff != "" {
+				t.Errorf("%q: unexpected audio rule: %s", tc.desc, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,336 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/logger"
+)
+
+const (
+	// CropFallbackNone encodes the source uncropped when the detected crop is
+	// not trusted.
+	CropFallbackNone = "none"
+	// CropFallbackHold holds the job for review when the detected crop is not
+	// trusted.
+	CropFallbackHold = "hold"
+
+	// cropSamples is the number of windows of the source analysed by
+	// cropdetect, enough to see the scenes of variable aspect sources.
+	cropSamples = 20
+	// cropSampleSeconds is the length of every window.
+	cropSampleSeconds = 15
+	// cropSignificantShare is the share of frames a candidate needs for the
+	// selected crop to keep its picture.
+	cropSignificantShare = 0.02
+	// defaultCropConfidence is the share of frames that must agree with the
+	// selected crop for it to be applied.
+	defaultCropConfidence = 0.5
+	// defaultCropAlignment is the multiple cropped dimensions are rounded to,
+	// the smallest chroma subsampled formats allow.
+	defaultCropAlignment = 2
+)
+
+// cropdetectRegex extracts the crop filter cropdetect reports for a frame.
+var cropdetectRegex = regexp.MustCompile(`crop=(\d+):(\d+):(\d+):(\d+)`)
+
+// CropCandidate is a crop reported by cropdetect and the number of sampled
+// frames it was reported for.
+type CropCandidate struct {
+	Filter string `json:"filter"`
+	Frames int    `json:"frames"`
+}
+
+// CropAnalysis holds the candidates found in the sampled frames, the crop
+// selected from them and the share of frames agreeing with it. Timeline lists
+// the aspect ratio of the picture across the source, Variable_aspect is set
+// when it changes between scenes.
+type CropAnalysis struct {
+	Candidates      []CropCandidate `json:"candidates"`
+	Filter          string          `json:"filter"`
+	Confidence      float64         `json:"confidence"`
+	Variable_aspect bool            `json:"variable_aspect,omitempty"`
+	Timeline        []CropSegment   `json:"timeline,omitempty"`
+}
+
+// cropRect is the picture area of a frame, w by h pixels at x, y.
+type cropRect struct {
+	w, h, x, y int
+}
+
+// parseCrop reads a crop filter of the form crop=w:h:x:y.
+func parseCrop(filter string) (cropRect, error) {
+	dims := strings.Split(strings.TrimPrefix(filter, "crop="), ":")
+	if len(dims) != 4 {
+		return cropRect{}, fmt.Errorf("splitting crop filter %q for parsing failed", filter)
+	}
+	var v [4]int
+	for i, d := range dims {
+		n, err := strconv.Atoi(d)
+		if err != nil {
+			return cropRect{}, fmt.Errorf("invalid crop filter %q: %w", filter, err)
+		}
+		v[i] = n
+	}
+	return cropRect{w: v[0], h: v[1], x: v[2], y: v[3]}, nil
+}
+
+func (r cropRect) String() string {
+	return fmt.Sprintf("crop=%d:%d:%d:%d", r.w, r.h, r.x, r.y)
+}
+
+// union returns the smallest rect containing r and o.
+func (r cropRect) union(o cropRect) cropRect {
+	x, y := min(r.x, o.x), min(r.y, o.y)
+	return cropRect{w: max(r.x+r.w, o.x+o.w) - x, h: max(r.y+r.h, o.y+o.h) - y, x: x, y: y}
+}
+
+// within reports whether r lies inside o grown by tolerance pixels on every
+// side, cropping to o then keeps the picture of r.
+func (r cropRect) within(o cropRect, tolerance int) bool {
+	return r.x >= o.x-tolerance && r.y >= o.y-tolerance &&
+		r.x+r.w <= o.x+o.w+tolerance && r.y+r.h <= o.y+o.h+tolerance
+}
+
+// alignSpan grows a span of size n at offset to a multiple of m, centred on
+// the original span and kept within limit, 0 meaning no limit. The offset is
+// kept even so chroma samples are not split.
+func alignSpan(n, offset, m, limit int) (int, int) {
+	a := (n + m - 1) / m * m
+	if limit > 0 && a > limit {
+		a = limit - limit%m
+	}
+	offset -= (a - n) / 2
+	if limit > 0 {
+		offset = min(offset, limit-a)
+	}
+	offset = max(offset, 0)
+	return a, offset - offset%2
+}
+
+// align grows the rect to dimensions that are multiples of m within a frame
+// of width by height pixels. Growing never cuts into the picture.
+func (r cropRect) align(m, width, height int) cropRect {
+	w, x := alignSpan(r.w, r.x, m, width)
+	h, y := alignSpan(r.h, r.y, m, height)
+	return cropRect{w: w, h: h, x: x, y: y}
+}
+
+// parseCropdetect tallies the crops cropdetect reports for each frame,
+// frames it reports no picture area for are skipped.
+func parseCropdetect(out []byte) map[string]int {
+	tally := map[string]int{}
+	for _, m := range cropdetectRegex.FindAllSubmatch(out, -1) {
+		if string(m[1]) == "0" || string(m[2]) == "0" {
+			continue
+		}
+		tally[string(m[0])]++
+	}
+	return tally
+}
+
+// sortedCandidates orders a tally by frame count, the most frequent first.
+func sortedCandidates(tally map[string]int) []CropCandidate {
+	candidates := make([]CropCandidate, 0, len(tally))
+	for f, n := range tally {
+		candidates = append(candidates, CropCandidate{Filter: f, Frames: n})
+	}
+	slices.SortFunc(candidates, func(a, b CropCandidate) int {
+		if a.Frames != b.Frames {
+			return b.Frames - a.Frames
+		}
+		return strings.Compare(a.Filter, b.Filter)
+	})
+	return candidates
+}
+
+// cropdetectArgs returns the arguments running cropdetect on the window
+// starting at start. The detection is reset on every frame so each frame is
+// reported on its own.
+func cropdetectArgs(source string, start float64) []string {
+	args := append([]string{"-hide_banner"}, ffcommon...)
+	return append(args, "-ss", formatSeconds(start), "-i", source, "-map", "0:v:0",
+		"-vf", "cropdetect=round=2:reset=1", "-t", strconv.Itoa(cropSampleSeconds), "-an", "-f", "null", "NUL")
+}
+
+// DetectCrop runs cropdetect on windows spread across the duration seconds of
+// the source following start. It returns the crops reported for the sampled
+// frames, the most frequent first, and the timeline of the crop dominating
+// each window.
+func DetectCrop(ctx context.Context, source string, start, duration float64) (CropAnalysis, error) {
+	var a CropAnalysis
+	tally := map[string]int{}
+	for _, p := range samplePoints(start, duration, cropSamples) {
+		args := cropdetectArgs(source, p)
+		logger.Infof("cropdetect with args %#v", args)
+		var serr bytes.Buffer
+		cmd := exec.CommandContext(ctx, ffmpegbinary, args...)
+		cmd.Stderr = &serr
+		if err := cmd.Run(); errors.Is(ctx.Err(), context.Canceled) {
+			return CropAnalysis{}, ctx.Err()
+		} else if err != nil {
+			return CropAnalysis{}, fmt.Errorf("failed to exec cropdetect: %v", err)
+		}
+		window := parseCropdetect(serr.Bytes())
+		for f, n := range window {
+			tally[f] += n
+		}
+		if len(window) > 0 {
+			a.Timeline = append(a.Timeline, CropSegment{Start: p, Filter: sortedCandidates(window)[0].Filter})
+		}
+	}
+	if len(tally) == 0 {
+		return CropAnalysis{}, fmt.Errorf("failed to extract crop string")
+	}
+	a.Candidates = sortedCandidates(tally)
+	return a, nil
+}
+
+// SelectCrop picks the crop of a frame of width by height pixels, with the
+// given sample aspect ratio, from the candidates of the analysis. The
+// selected crop contains the most frequent candidate and every candidate
+// reported for a significant share of the frames, so bright scenes are not cut
+// to the crop of dark ones. Candidates changing the aspect ratio of the
+// picture mark the source as variable, they are part of the crop unless the
+// aspect policy of the request is dominant. The dimensions of the crop are
+// grown to multiples of the request's alignment. The confidence is the share
+// of frames whose crop lies within the selected one, give or take the
+// alignment, or for variable sources within the crop of one of its aspect
+// ratios.
+func (tr TranscodeRequest) SelectCrop(a CropAnalysis, width, height int, sar float64) CropAnalysis {
+	a.Filter, a.Confidence, a.Variable_aspect = "", 0, false
+	var total int
+	for _, c := range a.Candidates {
+		total += c.Frames
+	}
+	var dominant, selected cropRect
+	var variants []cropRect
+	found := false
+	for _, c := range a.Candidates {
+		r, err := parseCrop(c.Filter)
+		if err != nil {
+			continue
+		}
+		share := float64(c.Frames) / float64(total)
+		switch {
+		case !found:
+			dominant, selected, found = r, r, true
+		case share >= aspectVariantShare && dominant.aspectVariant(r):
+			variants = append(variants, r)
+		case share >= cropSignificantShare:
+			selected = selected.union(r)
+		}
+	}
+	if !found {
+		return a
+	}
+	a.Variable_aspect = len(variants) > 0
+	if tr.aspectPolicy() != AspectPolicyDominant {
+		for _, v := range variants {
+			selected = selected.union(v)
+		}
+	}
+	alignment := tr.Crop_alignment
+	if alignment <= 0 {
+		alignment = defaultCropAlignment
+	}
+	selected = selected.align(alignment, width, height)
+	accepted := append([]cropRect{selected}, variants...)
+	if a.Variable_aspect {
+		accepted = append(accepted, dominant)
+	}
+	var agreeing int
+	for _, c := range a.Candidates {
+		r, err := parseCrop(c.Filter)
+		if err == nil && slices.ContainsFunc(accepted, func(o cropRect) bool { return r.within(o, alignment) }) {
+			agreeing += c.Frames
+		}
+	}
+	a.Filter = selected.String()
+	a.Confidence = float64(agreeing) / float64(total)
+	a.Timeline = mergeTimeline(a.Timeline, sar)
+	return a
+}
+
+// String summarises the analysis for display on the status page.
+func (a *CropAnalysis) String() string {
+	if a == nil || a.Filter == "" {
+		return ""
+	}
+	var frames int
+	for _, c := range a.Candidates {
+		frames += c.Frames
+	}
+	s := fmt.Sprintf("%s, %d%% confidence over %d frames and %d candidates", a.Filter, int(math.Round(a.Confidence*100)), frames, len(a.Candidates))
+	if a.Variable_aspect {
+		s += ", variable aspect ratio"
+	}
+	return s
+}
+
+// cropConfidence returns the minimum confidence of the request.
+func (tr TranscodeRequest) cropConfidence() float64 {
+	if tr.Crop_confidence == 0 {
+		return defaultCropConfidence
+	}
+	return tr.Crop_confidence
+}
+
+// CropPolicy returns the crop filter applied for the analysis and whether the
+// job is held for review. A crop below the confidence the request requires
+// is dropped, or held when the request's fallback is hold. Variable aspect
+// sources are held when the request's aspect policy is flag.
+func (tr TranscodeRequest) CropPolicy(a CropAnalysis) (string, bool) {
+	if a.Variable_aspect && tr.aspectPolicy() == AspectPolicyFlag {
+		return "", true
+	}
+	if a.Filter != "" && a.Confidence >= tr.cropConfidence() {
+		return a.Filter, false
+	}
+	return "", strings.EqualFold(tr.Crop_fallback, CropFallbackHold)
+}
+
+// validateCrop checks the crop alignment, confidence, fallback and aspect
+// policy of a request.
+func (tr TranscodeRequest) validateCrop() error {
+	switch tr.Crop_alignment {
+	case 0, 2, 16:
+	default:
+		return fmt.Errorf("unsupported crop alignment %d, use 2 or 16", tr.Crop_alignment)
+	}
+	if tr.Crop_confidence < 0 || tr.Crop_confidence > 1 {
+		return fmt.Errorf("crop confidence must be between 0 and 1")
+	}
+	switch strings.ToLower(tr.Crop_fallback) {
+	case "", CropFallbackNone, CropFallbackHold:
+	default:
+		return fmt.Errorf("unsupported crop fallback %q", tr.Crop_fallback)
+	}
+	switch tr.aspectPolicy() {
+	case AspectPolicyLargest, AspectPolicyDominant, AspectPolicyFlag:
+	default:
+		return fmt.Errorf("unsupported aspect policy %q", tr.Aspect_policy)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package ffwrap
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseCropdetect(t *testing.T) {
+	out := []byte(`[Parsed_cropdetect_0 @ 0x5581] x1:0 x2:1919 y1:140 y2:939 w:1920 h:800 x:0 y:140 pts:0 t:0.000000 limit:0.094118 crop=1920:800:0:140
+[Parsed_cropdetect_0 @ 0x5581] x1:0 x2:1919 y1:140 y2:939 w:1920 h:800 x:0 y:140 pts:1001 t:0.041708 limit:0.094118 crop=1920:800:0:140
+[Parsed_cropdetect_0 @ 0x5581] x1:1919 x2:0 y1:1079 y2:0 w:-1904 h:-1064 x:1912 y:1072 pts:2002 t:0.083417 limit:0.094118 crop=-1904:-1064:1912:1072
+[Parsed_cropdetect_0 @ 0x5581] x1:200 x2:1719 y1:300 y2:779 w:1520 h:480 x:200 y:300 pts:3003 t:0.125125 limit:0.094118 crop=1520:480:200:300
+`)
+	want := map[string]int{"crop=1920:800:0:140": 2, "crop=1520:480:200:300": 1}
+	if diff := cmp.Diff(want, parseCropdetect(out)); diff != "" {
+		t.Errorf("unexpected crop tally: %s", diff)
+	}
+	if diff := cmp.Diff([]CropCandidate{{Filter: "crop=1920:800:0:140", Frames: 2}, {Filter: "crop=1520:480:200:300", Frames: 1}}, sortedCandidates(want)); diff != "" {
+		t.Errorf("unexpected candidate order: %s", diff)
+	}
+}
+
+func TestSelectCrop(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		request    TranscodeRequest
+		candidates []CropCandidate
+		filter     string
+		confidence float64
+		variable   bool
+	}{
+		{desc: "no candidates"},
+		{
+			desc:       "dark scenes inside the picture",
+			candidates: []CropCandidate{{Filter: "crop=1920:800:0:140", Frames: 900}, {Filter: "crop=1520:480:200:300", Frames: 90}, {Filter: "crop=1880:780:20:150", Frames: 10}},
+			filter:     "crop=1920:800:0:140",
+			confidence: 1,
+		},
+		{
+			desc:       "rare bright frame ignored",
+			candidates: []CropCandidate{{Filter: "crop=1920:800:0:140", Frames: 990}, {Filter: "crop=1920:1080:0:0", Frames: 10}},
+			filter:     "crop=1920:800:0:140",
+			confidence: 0.99,
+		},
+		{
+			desc:       "edge noise within the alignment",
+			candidates: []CropCandidate{{Filter: "crop=1920:800:0:140", Frames: 990}, {Filter: "crop=1920:802:0:139", Frames: 10}},
+			filter:     "crop=1920:800:0:140",
+			confidence: 1,
+		},
+		{
+			desc:       "rare frames outside the crop",
+			candidates: []CropCandidate{{Filter: "crop=1920:800:0:140", Frames: 985}, {Filter: "crop=1920:816:0:132", Frames: 15}},
+			filter:     "crop=1920:800:0:140",
+			confidence: 0.985,
+		},
+		{
+			desc:       "significant crop kept",
+			candidates: []CropCandidate{{Filter: "crop=1920:800:0:140", Frames: 900}, {Filter: "crop=1800:820:60:130", Frames: 100}},
+			filter:     "crop=1920:820:0:130",
+			confidence: 1,
+		},
+		{
+			desc:       "variable aspect keeps the largest frame",
+			candidates: []CropCandidate{{Filter: "crop=1920:800:0:140", Frames: 700}, {Filter: "crop=1920:1008:0:36", Frames: 300}},
+			filter:     "crop=1920:1008:0:36",
+			confidence: 1,
+			variable:   true,
+		},
+		{
+			desc:       "variable aspect cropped to the dominant ratio",
+			request:    TranscodeRequest{Aspect_policy: "dominant"},
+			candidates: []CropCandidate{{Filter: "crop=1920:800:0:140", Frames: 700}, {Filter: "crop=1920:1008:0:36", Frames: 300}},
+			filter:     "crop=1920:800:0:140",
+			confidence: 1,
+			variable:   true,
+		},
+		{
+			desc:       "mod 16 alignment grows the crop",
+			request:    TranscodeRequest{Crop_alignment: 16},
+			candidates: []CropCandidate{{Filter: "crop=1916:802:2:138", Frames: 100}},
+			filter:     "crop=1920:816:0:130",
+			confidence: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			got := tc.request.SelectCrop(CropAnalysis{Candidates: tc.candidates}, 1920, 1080, 1)
+			if got.Filter != tc.filter || got.Confidence != tc.confidence || got.Variable_aspect != tc.variable {
+				t.Errorf("%q: SelectCrop() = %q at %v variable %v, want %q at %v variable %v", tc.desc, got.Filter, got.Confidence, got.Variable_aspect, tc.filter, tc.confidence, tc.variable)
+			}
+		})
+	}
+}
+
+func TestCropPolicy(t *testing.T) {
+	analysis := CropAnalysis{Filter: "crop=1920:800:0:140", Confidence: 0.7}
+	testCases := []struct {
+		desc    string
+		request TranscodeRequest
+		filter  string
+		hold    bool
+	}{
+		{desc: "default confidence", request: TranscodeRequest{}, filter: "crop=1920:800:0:140"},
+		{desc: "fallback to no crop", request: TranscodeRequest{Crop_confidence: 0.8}},
+		{desc: "held for review", request: TranscodeRequest{Crop_confidence: 0.8, Crop_fallback: "hold"}, hold: true},
+		{desc: "constant aspect not flagged", request: TranscodeRequest{Aspect_policy: "flag"}, filter: "crop=1920:800:0:140"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			filter, hold := tc.request.CropPolicy(analysis)
+			if filter != tc.filter || hold != tc.hold {
+				t.Errorf("%q: CropPolicy() = %q, %v, want %q, %v", tc.desc, filter, hold, tc.filter, tc.hold)
+			}
+		})
+	}
+	variable := CropAnalysis{Filter: "crop=1920:1008:0:36", Confidence: 1, Variable_aspect: true}
+	if filter, hold := (TranscodeRequest{Aspect_policy: "flag"}).CropPolicy(variable); filter != "" || !hold {
+		t.Errorf("CropPolicy() = %q, %v, want a variable aspect source held for review", filter, hold)
+	}
+}
+
+func TestValidateCrop(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		shouldError bool
+	}{
+		{desc: "defaults"},
+		{desc: "mod 16", request: TranscodeRequest{Crop_alignment: 16, Crop_confidence: 0.9, Crop_fallback: "hold"}},
+		{desc: "mod 4", request: TranscodeRequest{Crop_alignment: 4}, shouldError: true},
+		{desc: "confidence above 1", request: TranscodeRequest{Crop_confidence: 90}, shouldError: true},
+		{desc: "unknown fallback", request: TranscodeRequest{Crop_fallback: "skip"}, shouldError: true},
+		{desc: "flag variable aspect", request: TranscodeRequest{Aspect_policy: "flag"}},
+		{desc: "unknown aspect policy", request: TranscodeRequest{Aspect_policy: "imax"}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if err := tc.request.validateCrop(); (err != nil) != tc.shouldError {
+				t.Errorf("%q: validateCrop() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ArtifactCropPreview = "crop_preview"
+
+	// CropReviewApprove applies the detected crop.
+	CropReviewApprove = "approve"
+	// CropReviewAdjust applies the crop given by the operator.
+	CropReviewAdjust = "adjust"
+	// CropReviewDisable encodes the source uncropped.
+	CropReviewDisable = "disable"
+
+	// cropPreviewSamples is the number of frames the detected crop is drawn on.
+	cropPreviewSamples = 4
+)
+
+// CropReview is an operator's decision on the crop of a job held for review.
+// Crop is the crop=w:h:x:y filter applied when adjusting.
+type CropReview struct {
+	Action string `json:"action"`
+	Crop   string `json:"crop,omitempty"`
+}
+
+// Filter returns the crop filter the review applies given the detected one.
+func (r CropReview) Filter(detected string) string {
+	switch strings.ToLower(r.Action) {
+	case CropReviewApprove:
+		return detected
+	case CropReviewAdjust:
+		return r.Crop
+	}
+	return ""
+}
+
+// Validate checks the review against a source of width by height pixels,
+// dimensions of 0 are not checked.
+func (r CropReview) Validate(width, height int) error {
+	switch strings.ToLower(r.Action) {
+	case CropReviewApprove, CropReviewDisable:
+		return nil
+	case CropReviewAdjust:
+	default:
+		return fmt.Errorf("unsupported crop review action %q", r.Action)
+	}
+	c, err := parseCrop(r.Crop)
+	if err != nil {
+		return err
+	}
+	switch {
+	case c.w <= 0 || c.h <= 0 || c.x < 0 || c.y < 0:
+		return fmt.Errorf("crop %q must have a positive size and offset", r.Crop)
+	case c.w%2 != 0 || c.h%2 != 0:
+		return fmt.Errorf("crop %q must have an even width and height", r.Crop)
+	case width > 0 && c.x+c.w > width, height > 0 && c.y+c.h > height:
+		return fmt.Errorf("crop %q exceeds the %dx%d source", r.Crop, width, height)
+	}
+	return nil
+}
+
+// cropBoxFilter returns the filter outlining the picture a crop keeps.
+func cropBoxFilter(filter string) (string, error) {
+	c, err := parseCrop(filter)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("drawbox=x=%d:y=%d:w=%d:h=%d:color=red@0.8:t=4", c.x, c.y, c.w, c.h), nil
+}
+
+// cropPreviewArgs extracts the frame at ts with the crop outlined on it, the
+// frame is left unmarked without a crop.
+func cropPreviewArgs(source string, ts float64, filter, out string) ([]string, error) {
+	args := append(append([]string{}, ffquiet...), "-ss", formatSeconds(ts), "-i", source, "-map", "0:v:0", "-frames:v", "1")
+	if filter != "" {
+		box, err := cropBoxFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, "-vf", box)
+	}
+	return append(args, "-q:v", "2", out), nil
+}
+
+// RenderCropPreviews draws the crop on frames spread across the duration
+// seconds of the source following the start of the request. The frames are
+// written to the artifact directory of the request's destination.
+func (tr TranscodeRequest) RenderCropPreviews(ctx context.Context, filter string, duration float64) ([]Artifact, error) {
+	a := Artifacts{}
+	if tr.Artifacts != nil {
+		a = *tr.Artifacts
+	}
+	dir := a.directory(tr.Destination)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create artifact directory: %w", err)
+	}
+	var previews []Artifact
+	var errs []error
+	for i, ts := range samplePoints(tr.Start, tr.OutputDuration(duration), cropPreviewSamples) {
+		out := filepath.Join(dir, fmt.Sprintf("crop_preview_%03d.jpg", i+1))
+		args, err := cropPreviewArgs(tr.Source, ts, filter, out)
+		if err != nil {
+			return nil, err
+		}
+		if err := runArtifactCommand(ctx, args); errors.Is(err, context.Canceled) {
+			return previews, err
+		} else if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		previews = append(previews, Artifact{Kind: ArtifactCropPreview, Path: out, Timestamp: ts})
+	}
+	return previews, errors.Join(errs...)
+}
+
+// validateCropReview checks that crop review is only requested for autocropped
+// jobs.
+func (tr TranscodeRequest) validateCropReview() error {
+	if tr.Review_crop && !tr.Autocrop {
+		return fmt.Errorf("crop review requires autocrop")
+	}
+	return nil
+}
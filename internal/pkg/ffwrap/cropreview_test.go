@@ -0,0 +1,53 @@
+package ffwrap
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCropReview(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		review      CropReview
+		filter      string
+		shouldError bool
+	}{
+		{desc: "approve", review: CropReview{Action: "approve"}, filter: "crop=1920:800:0:140"},
+		{desc: "disable", review: CropReview{Action: "disable"}},
+		{desc: "adjust", review: CropReview{Action: "adjust", Crop: "crop=1920:816:0:132"}, filter: "crop=1920:816:0:132"},
+		{desc: "adjust without crop", review: CropReview{Action: "adjust"}, shouldError: true},
+		{desc: "odd width", review: CropReview{Action: "adjust", Crop: "crop=1919:816:0:132"}, shouldError: true},
+		{desc: "outside the frame", review: CropReview{Action: "adjust", Crop: "crop=1920:816:0:300"}, shouldError: true},
+		{desc: "unknown action", review: CropReview{Action: "skip"}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if err := tc.review.Validate(1920, 1080); (err != nil) != tc.shouldError {
+				t.Fatalf("%q: Validate() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+			if tc.shouldError {
+				return
+			}
+			if got := tc.review.Filter("crop=1920:800:0:140"); got != tc.filter {
+				t.Errorf("%q: Filter() = %q, want %q", tc.desc, got, tc.filter)
+			}
+		})
+	}
+}
+
+func TestCropPreviewArgs(t *testing.T) {
+	got, err := cropPreviewArgs("in.mkv", 60, "crop=1920:800:0:140", "out.jpg")
+	if err != nil {
+		t.Fatalf("cropPreviewArgs() unexpected error: %v", err)
+	}
+	want := append(append([]string{}, ffquiet...), "-ss", "60", "-i", "in.mkv", "-map", "0:v:0", "-frames:v", "1",
+		"-vf", "drawbox=x=0:y=140:w=1920:h=800:color=red@0.8:t=4", "-q:v", "2", "out.jpg")
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected preview args: %s", diff)
+	}
+	if _, err := cropPreviewArgs("in.mkv", 60, "crop=1920", "out.jpg"); err == nil {
+		t.Errorf("cropPreviewArgs() expected an error for a malformed crop")
+	}
+}
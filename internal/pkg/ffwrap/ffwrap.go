@@ -14,7 +14,6 @@
 package ffwrap
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -22,7 +21,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
@@ -31,8 +29,6 @@ import (
 )
 
 var (
-	// cdregex extracts the correct crop filter from an ffmpeg cropdetect run
-	cdregex       = regexp.MustCompile(`t:([\d]*).*?(crop=[-\d:]*)`)
 	ffquiet       = []string{"-y", "-hide_banner", "-stats", "-loglevel", "error"}
 	ffcommon      = []string{"-probesize", "6000M", "-analyzeduration", "6000M"}
 	ffmpegbinary  string
@@ -44,105 +40,90 @@ func SetBinaryLocations(ffmpeg, ffprobe string) {
 	ffprobebinary = ffprobe
 }
 
-// detectCrop uses the FFmpeg tool to automatically detect and return the crop filter string for an input video file.
-// It constructs a command with arguments suitable for the FFmpeg cropdetect filter, runs the command, and processes its output
-// to extract and return the detected crop parameters as a string. If any error occurs during this process, it returns an error message.
-func DetectCrop(ctx context.Context, inputFile string) (string, error) {
-	args := append([]string{"-hide_banner"}, ffcommon...)
-
-	args = append(args, "-i", inputFile, "-vf", "cropdetect=round=2", "-t", "300", "-f", "null", "NUL")
-
-	var sout, serr bytes.Buffer
-	logger.Infof("cropdetect with args %#v", args)
-	cmd := exec.CommandContext(ctx, ffmpegbinary, args...)
-	cmd.Stdout = &sout
-	cmd.Stderr = &serr
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to exec cropdetect: %v", err)
-	}
-	m := cdregex.FindAllSubmatch(serr.Bytes(), -1)
-	if len(m) < 1 {
-		return "", fmt.Errorf("failed to extract crop string")
-	}
-	if len(m[len(m)-1]) < 3 {
-		return "", fmt.Errorf("failed to extract crop string")
-	}
-	return string(m[len(m)-1][2]), nil
-}
-
-// probeMetadata uses the FFprobe tool to retrieve metadata about an input video file.
-// It constructs a command with arguments suitable for retrieving stream information and runs it using FFprobe.
-// The output is then parsed as JSON and converted into a MediaMetadata struct, which includes details such as codec name, width, height, duration.
-// If any error occurs during the process, it returns an appropriate error message along with zero values in the MediaMetadata structure.
-func ProbeMetadata(ctx context.Context, source string) (MediaMetadata, error) {
+// ProbeSource uses ffprobe to build a full inventory of the source file: every
+// stream, the chapters and the container format including its tags.
+func ProbeSource(ctx context.Context, source string) (FfprobeOutput, error) {
 	args := []string{
-		"-threads", "32", "-v", "error", "-select_streams", "v:0", "-show_format", "-show_entries",
-		"stream=codec_name,width,height,", "-print_format", "json", "-sexagesimal", source,
+		"-threads", "32", "-v", "error", "-show_format", "-show_streams", "-show_chapters", "-print_format", "json", source,
 	}
 	logger.Infof("calling ffprobe with: %#v", args)
 	cmd := exec.CommandContext(ctx, ffprobebinary, args...)
 	sto, err := cmd.Output()
 	if err != nil && cmd.ProcessState.ExitCode() != 0 {
-		return MediaMetadata{}, fmt.Errorf("%q ffprobe unexpect output: %v or exit code: %d", source, err, cmd.ProcessState.ExitCode())
+		return FfprobeOutput{}, fmt.Errorf("%q ffprobe unexpect output: %v or exit code: %d", source, err, cmd.ProcessState.ExitCode())
 	}
 
 	var ffp FfprobeOutput
 	if err := json.Unmarshal(sto, &ffp); err != nil {
-		return MediaMetadata{}, fmt.Errorf("unmarshall ffprobe data %#v: %w", sto, err)
+		return FfprobeOutput{}, fmt.Errorf("unmarshall ffprobe data %#v: %w", sto, err)
 	}
+	return ffp, nil
+}
 
-	if len(ffp.Streams) != 1 {
-		return MediaMetadata{}, fmt.Errorf("got %d streams in ffprobe output; expected 1", len(ffp.Streams))
+// probeMetadata uses the FFprobe tool to retrieve metadata about an input video file.
+// The inventory returned by ProbeSource is reduced to the codec, width and
+// height of the first video stream and the duration of the file.
+func ProbeMetadata(ctx context.Context, source string) (MediaMetadata, error) {
+	ffp, err := ProbeSource(ctx, source)
+	if err != nil {
+		return MediaMetadata{}, err
 	}
-
-	return MediaMetadata{
-		Duration: ffp.Format.Duration,
-		Codec:    ffp.Streams[0].Codec,
-		Width:    ffp.Streams[0].Width,
-		Height:   ffp.Streams[0].Height,
-	}, nil
+	return ffp.MediaMetadata()
 }
 
 // ffmpegTranscode transcodes media files using FFmpeg based on the provided TranscodeJob configuration.
 // It constructs and executes an FFmpeg command with various options to handle video, audio, subtitles, and other metadata from the source file.
 // The function supports copying streams where specified ('copy' codec), applying video filters if defined, and handling additional subtitle files specified in srt_files.
 // It captures stderr output for logging purposes and returns the FFmpeg command arguments upon successful completion or an error otherwise.
-func FfmpegTranscode(ctx context.Context, tr TranscodeRequest) ([]string, error) {
-	args := append(ffquiet, ffcommon...)
-
-	args = append(args, "-i", tr.Source)
-
-	mapargs := []string{
-		"-map", "0:v:0",
-		"-map", "0:a:m:language:eng:?",
-		"-map", "0:s:m:language:eng:?",
-		"-map", "0:t:?"}
-
-	if len(tr.Srt_files) > 0 {
-		for m, i := range tr.Srt_files {
-			if len(i) > 0 {
-				args = append(args, "-i", i)
-				mapargs = append(mapargs, "-map", fmt.Sprintf("%d", m+1), "-metadata:s:s", "language=eng")
+//
+// The source is probed when no inventory is given, concat jobs probe each of
+// their sources.
+func FfmpegTranscode(ctx context.Context, tr TranscodeRequest, inventory *FfprobeOutput) ([]string, error) {
+	if strings.EqualFold(tr.Job_type, JobTypeConcat) {
+		return concatTranscode(ctx, tr)
+	}
+	if inventory == nil {
+		if ffp, err := ProbeSource(ctx, tr.Source); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil, err
 			}
+			logger.Errorf("failed to probe streams, falling back to default stream mapping: %v", err)
+		} else {
+			inventory = &ffp
 		}
 	}
-	if strings.ToLower(tr.Codec) != "copy" && tr.Video_filters != "" {
-		args = append(args, "-vf", tr.Video_filters)
+	var streams []FfprobeStreams
+	if inventory != nil {
+		streams = inventory.Streams
+	}
+
+	if tr.Packaging != nil && len(streams) == 0 {
+		return nil, fmt.Errorf("packaging %q requires a stream inventory of the source", tr.Source)
+	}
+	if len(tr.Audio_files) > 0 && len(streams) == 0 {
+		return nil, fmt.Errorf("audio files require a stream inventory of %q to be mapped", tr.Source)
 	}
 
-	colorMeta, err := parseColorInfo(ctx, tr.Source)
+	colorMeta, err := tr.sourceColor(ctx, tr.Source, inventory)
 	if err != nil {
-		logger.Errorf("failed to parse color metadata: %v", err)
+		return nil, err
 	}
-	logger.Infof("got color metadata: %#v", colorMeta)
-	args = append(args, codec.BuildCodec(tr.Codec, tr.Crf, colorMeta)...)
-	args = append(args, "-c:a", "copy", "-c:s", "copy", "-c:t", "copy")
-	args = append(args, mapargs...)
-	args = append(args, tr.Destination)
+	if err := tr.checkHdr(detectHdr(streams, colorMeta), colorMeta); err != nil {
+		return nil, err
+	}
+
+	args := buildTranscodeArgs(tr, streams, colorMeta)
+	if err := runFfmpeg(ctx, args, tr.LogDestination); err != nil {
+		return nil, err
+	}
+	return args, nil
+}
 
-	log, err := os.Create(tr.LogDestination)
+// runFfmpeg runs ffmpeg with args writing its output to the log at logDestination.
+func runFfmpeg(ctx context.Context, args []string, logDestination string) error {
+	log, err := os.Create(logDestination)
 	if err != nil {
-		logger.Errorf("failed to start log file at %q error: %v", tr.LogDestination, err)
+		logger.Errorf("failed to start log file at %q error: %v", logDestination, err)
 	}
 
 	cmd := exec.CommandContext(ctx, ffmpegbinary, args...)
@@ -152,46 +133,124 @@ func FfmpegTranscode(ctx context.Context, tr TranscodeRequest) ([]string, error)
 	logger.Infof("calling ffmpeg with args: %#v", args)
 	err = cmd.Start()
 	if err != nil {
-		return nil, fmt.Errorf("failed to start ffmpeg: %q", err)
+		return fmt.Errorf("failed to start ffmpeg: %q", err)
 	}
 
 	err = cmd.Wait()
 	if errors.Is(err, context.Canceled) {
-		return nil, err
+		return err
 	} else if err != nil || cmd.ProcessState.ExitCode() != 0 {
-		return nil, fmt.Errorf("execution failed: %w check log at %q", err, log.Name())
+		return fmt.Errorf("execution failed: %w check log at %q", err, logDestination)
+	}
+	return nil
+}
+
+// buildTranscodeArgs assembles the complete ffmpeg argument list for a request.
+// streams is the ffprobe inventory of the source; when it is empty streams are
+// selected by language tag and the top level audio settings apply to all tracks.
+func buildTranscodeArgs(tr TranscodeRequest, streams []FfprobeStreams, colorMeta codec.ColorInfo) []string {
+	if strings.EqualFold(tr.Job_type, JobTypeMetadata) {
+		return buildMetadataJobArgs(tr, streams)
 	}
+	args := append(append([]string{}, ffquiet...), ffcommon...)
+	args = append(args, tr.deviceArgs()...)
 
-	return args, nil
+	trim := tr.trimInputArgs()
+	args = append(args, trim...)
+	args = append(args, "-i", tr.Source)
+	for _, sf := range tr.Srt_files {
+		if sf.Path != "" {
+			args = append(args, trim...)
+			args = append(args, sf.inputArgs()...)
+		}
+	}
+	for _, af := range tr.Audio_files {
+		args = append(args, trim...)
+		args = append(args, af.inputArgs()...)
+	}
+	if tr.Metadata != nil {
+		args = append(args, tr.Metadata.inputArgs()...)
+	}
+
+	graph, videoMaps := buildSplitGraph(tr, colorMeta)
+	if graph != "" {
+		args = append(args, "-filter_complex", graph)
+	}
+	if tr.Packaging != nil {
+		return append(args, buildPackagingArgs(tr, streams, colorMeta, videoMaps)...)
+	}
+	for i, o := range tr.renditions() {
+		args = append(args, buildOutputArgs(o, streams, colorMeta, videoMaps[i], graph == "")...)
+	}
+	return args
 }
 
-// parseColorInfo extracts detailed color information about the video stream of an input file using ffprobe.
-// It constructs and executes a command to extract specific metadata related to color spaces, primary colors, transfer characteristics, pixel formats, and other frame details.
-// The function returns the parsed color information or an error if extraction fails.
-func parseColorInfo(ctx context.Context, inputFile string) (codec.ColorInfo, error) {
-	args := []string{
-		"-hide_banner",
-		"-loglevel", "warning",
-		"-select_streams", "v:0",
-		"-analyzeduration", "6000M",
-		"-probesize", "6000M",
-		"-print_format", "json",
-		"-show_frames",
-		"-read_intervals", "%+#1",
-		"-show_entries", "frame=color_space,color_primaries,color_transfer,side_data_list,pix_fmt",
-		"-i", inputFile,
-	}
-	logger.Infof("parsing color info, calling ffprobe with args: %#v", args)
-	cmd := exec.CommandContext(ctx, ffprobebinary, args...)
-	o, err := cmd.Output()
-	if err != nil && cmd.ProcessState.ExitCode() != 0 {
-		return codec.ColorInfo{}, fmt.Errorf("failed to extract color information err: %v output: %s exit code: %d", err, o, cmd.ProcessState.ExitCode())
+// buildOutputArgs generates the options of a single output file reading its
+// video from videoMap. The video filters of the output are only applied when
+// applyVF is set, otherwise they are part of the filter graph.
+func buildOutputArgs(tr TranscodeRequest, streams []FfprobeStreams, colorMeta codec.ColorInfo, videoMap string, applyVF bool) []string {
+	var args []string
+	container := tr.outputContainer()
+	mapargs := []string{"-map", videoMap}
+	audio, subtitles := selectStreams(streams, tr.Streams)
+	subtitles = storableSubtitles(subtitles, container)
+	var dispositions []string
+	if len(streams) > 0 {
+		for _, s := range append(append([]FfprobeStreams{}, audio...), subtitles...) {
+			mapargs = append(mapargs, "-map", fmt.Sprintf("0:%d", s.Index))
+		}
+		dispositions = buildDispositionArgs(audio, subtitles, tr.Audio_files, tr.Streams, originalLanguage(streams))
+	} else {
+		sel := tr.Streams
+		if sel == nil {
+			sel = &defaultSelection
+		}
+		mapargs = append(mapargs, languageMaps("a", sel.Audio_languages)...)
+		mapargs = append(mapargs, languageMaps("s", sel.Subtitle_languages)...)
+	}
+	if allowsAttachments(container) {
+		mapargs = append(mapargs, "-map", "0:t:?")
 	}
 
-	var ci ColorInfoWrapper
-	if err := json.Unmarshal(o, &ci); err != nil {
-		return codec.ColorInfo{}, fmt.Errorf("failed to unmarshal color info %q from ffprobe: %w", o, err)
+	input := 1
+	for _, sf := range tr.Srt_files {
+		if sf.Path == "" {
+			continue
+		}
+		if len(streams) > 0 {
+			mapargs = append(mapargs, sf.outputArgs(input, len(subtitles)+input-1, tr.outputContainer())...)
+		} else {
+			// without an inventory the output index of the file is unknown
+			mapargs = append(mapargs, "-map", fmt.Sprintf("%d", input), "-metadata:s:s", fmt.Sprintf("language=%s", sf.language()))
+		}
+		input++
+	}
+	for i, af := range tr.Audio_files {
+		// audio files are refused without an inventory as their output index
+		// isn't known, see FfmpegTranscode
+		if len(streams) > 0 {
+			mapargs = append(mapargs, af.outputArgs(input, len(audio)+i, tr.audioFilters())...)
+		}
+		input++
+	}
+	if tr.Metadata != nil {
+		mapargs = append(mapargs, tr.Metadata.outputArgs(input, 1, streams, container)...)
+	}
+	if vf := joinFilters(tr.Video_filters, tr.toneMapFilter(colorMeta), tr.hdr10PlusFilter(colorMeta), tr.uploadFilter()); applyVF && strings.ToLower(tr.Codec) != "copy" && vf != "" {
+		args = append(args, "-vf", vf)
 	}
 
-	return ci.Frames[0], nil
+	args = append(args, tr.codecArgs(colorMeta)...)
+	args = append(args, tr.hdrArgs(detectHdr(streams, colorMeta), colorMeta)...)
+	args = append(args, tr.frameRateArgs(streams)...)
+	args = append(args, buildAudioArgs(audio, tr.Audio, tr.audioFilters(), container)...)
+	args = append(args, "-c:s", subtitleCodec(container))
+	if allowsAttachments(container) {
+		args = append(args, "-c:t", "copy")
+	}
+	args = append(args, mapargs...)
+	args = append(args, dispositions...)
+	args = append(args, tr.trimOutputArgs()...)
+	args = append(args, tr.containerArgs()...)
+	return append(args, tr.Destination)
 }
@@ -0,0 +1,310 @@
+package ffwrap
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestBuildTranscodeArgs(t *testing.T) {
+	videoTrack := FfprobeStreams{Index: 0, Codec: "h264", Codec_type: "video"}
+	frenchTrack := FfprobeStreams{Index: 5, Codec: "ac3", Codec_type: "audio", Channels: 6, Tags: FfprobeTags{Language: "fre"}}
+
+	testCases := []struct {
+		desc     string
+		request  TranscodeRequest
+		streams  []FfprobeStreams
+		expected []string
+	}{
+		{
+			desc: "copy without stream inventory",
+			request: TranscodeRequest{
+				Source:      "/src.mkv",
+				Destination: "/dst.mkv",
+				Codec:       "copy",
+			},
+			expected: []string{
+				"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+				"-i", "/src.mkv",
+				"-c:v", "copy",
+				"-c:a", "copy",
+				"-c:s", "copy", "-c:t", "copy",
+				"-map", "0:v:0", "-map", "0:a:m:language:eng:?", "-map", "0:s:m:language:eng:?", "-map", "0:t:?",
+				"/dst.mkv",
+			},
+		},
+		{
+			desc: "audio tracks mapped individually",
+			request: TranscodeRequest{
+				Source:      "/src.mkv",
+				Destination: "/dst.mkv",
+				Srt_files:   []SubtitleFile{{Path: "/subs.srt"}},
+				Codec:       "copy",
+				Audio: &AudioSettings{
+					Codec: "copy",
+					Rules: []AudioRule{{Match_commentary: true, Codec: "opus", Bitrate: "96k"}},
+				},
+			},
+			streams: []FfprobeStreams{videoTrack, truehdTrack, frenchTrack, commentary},
+			expected: []string{
+				"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+				"-i", "/src.mkv",
+				"-i", "/subs.srt",
+				"-c:v", "copy",
+				"-c:a:0", "copy",
+				"-c:a:1", "libopus", "-b:a:1", "96k",
+				"-c:s", "copy", "-c:t", "copy",
+				"-map", "0:v:0", "-map", "0:1", "-map", "0:4", "-map", "0:t:?",
+				"-map", "1", "-metadata:s:s:0", "language=eng",
+				"/dst.mkv",
+			},
+		},
+		{
+			desc: "trimmed copy with subtitle file",
+			request: TranscodeRequest{
+				Source:      "/src.mkv",
+				Destination: "/dst.mkv",
+				Srt_files:   []SubtitleFile{{Path: "/subs.srt"}},
+				Codec:       "copy",
+				Start:       90,
+				End:         150.5,
+			},
+			streams: []FfprobeStreams{videoTrack, truehdTrack},
+			expected: []string{
+				"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+				"-ss", "90", "-t", "60.5", "-i", "/src.mkv",
+				"-ss", "90", "-t", "60.5", "-i", "/subs.srt",
+				"-c:v", "copy",
+				"-c:a:0", "copy",
+				"-c:s", "copy", "-c:t", "copy",
+				"-map", "0:v:0", "-map", "0:1", "-map", "0:t:?",
+				"-map", "1", "-metadata:s:s:0", "language=eng",
+				"-avoid_negative_ts", "make_zero",
+				"/dst.mkv",
+			},
+		},
+		{
+			desc: "original language plus english",
+			request: TranscodeRequest{
+				Source:      "/src.mkv",
+				Destination: "/dst.mkv",
+				Codec:       "copy",
+				Streams: &StreamSelection{
+					Audio_languages:    []string{"original", "eng"},
+					Subtitle_languages: []string{"eng"},
+					Forced_subtitle:    "eng",
+				},
+			},
+			streams: []FfprobeStreams{
+				videoTrack,
+				{Index: 1, Codec_type: "audio", Codec: "dts", Tags: FfprobeTags{Language: "eng"}},
+				{Index: 2, Codec_type: "audio", Codec: "ac3", Tags: FfprobeTags{Language: "fre"}, Disposition: map[string]int{"default": 1}},
+				{Index: 3, Codec_type: "subtitle", Codec: "hdmv_pgs_subtitle", Tags: FfprobeTags{Language: "eng"}},
+				{Index: 4, Codec_type: "subtitle", Codec: "hdmv_pgs_subtitle", Tags: FfprobeTags{Language: "eng", Title: "Forced"}},
+			},
+			expected: []string{
+				"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+				"-i", "/src.mkv",
+				"-c:v", "copy",
+				"-c:a:0", "copy",
+				"-c:a:1", "copy",
+				"-c:s", "copy", "-c:t", "copy",
+				"-map", "0:v:0", "-map", "0:2", "-map", "0:1", "-map", "0:3", "-map", "0:4", "-map", "0:t:?",
+				"-disposition:a:0", "default", "-disposition:a:1", "0",
+				"-disposition:s:0", "0", "-disposition:s:1", "forced",
+				"/dst.mkv",
+			},
+		},
+		{
+			desc: "language selection without inventory",
+			request: TranscodeRequest{
+				Source:      "/src.mkv",
+				Destination: "/dst.mkv",
+				Codec:       "copy",
+				Streams: &StreamSelection{
+					Audio_languages:    []string{"jpn", "eng"},
+					Subtitle_languages: []string{"all"},
+				},
+			},
+			expected: []string{
+				"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+				"-i", "/src.mkv",
+				"-c:v", "copy",
+				"-c:a", "copy",
+				"-c:s", "copy", "-c:t", "copy",
+				"-map", "0:v:0", "-map", "0:a:m:language:jpn:?", "-map", "0:a:m:language:eng:?", "-map", "0:s?", "-map", "0:t:?",
+				"/dst.mkv",
+			},
+		},
+		{
+			desc: "subtitle files converted for mp4",
+			request: TranscodeRequest{
+				Source:      "/src.mkv",
+				Destination: "/dst.mp4",
+				Codec:       "copy",
+				Srt_files: []SubtitleFile{
+					{Path: "/forced.ass", Language: "ger", Title: "Forced", Forced: true, Offset: -1.5},
+					{Path: ""},
+					{Path: "/full.vtt", Language: "ger", Default: true},
+				},
+			},
+			streams: []FfprobeStreams{videoTrack, {Index: 1, Codec_type: "subtitle", Codec: "mov_text", Tags: FfprobeTags{Language: "eng"}}},
+			expected: []string{
+				"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+				"-i", "/src.mkv",
+				"-itsoffset", "-1.5", "-i", "/forced.ass",
+				"-i", "/full.vtt",
+				"-c:v", "copy",
+				"-c:a", "copy",
+				"-c:s", "mov_text",
+				"-map", "0:v:0", "-map", "0:1",
+				"-map", "1", "-c:s:1", "mov_text", "-metadata:s:s:1", "language=ger", "-metadata:s:s:1", "title=Forced", "-disposition:s:1", "forced",
+				"-map", "2", "-c:s:2", "mov_text", "-metadata:s:s:2", "language=ger", "-disposition:s:2", "default",
+				"-movflags", "+faststart",
+				"/dst.mp4",
+			},
+		},
+		{
+			desc: "external audio file flagged default",
+			request: TranscodeRequest{
+				Source:      "/src.mkv",
+				Destination: "/dst.mkv",
+				Codec:       "copy",
+				Audio_files: []AudioFile{
+					{Path: "/dub.ac3", Language: "GER", Title: "Dub", Delay: 0.25, Codec: "opus", Bitrate: "128k", Default: true},
+				},
+			},
+			streams: []FfprobeStreams{videoTrack, ac3Track},
+			expected: []string{
+				"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+				"-i", "/src.mkv",
+				"-itsoffset", "0.25", "-i", "/dub.ac3",
+				"-c:v", "copy",
+				"-c:a:0", "copy",
+				"-c:s", "copy", "-c:t", "copy",
+				"-map", "0:v:0", "-map", "0:3", "-map", "0:t:?",
+				"-map", "1:a:0", "-c:a:1", "libopus", "-b:a:1", "128k", "-metadata:s:a:1", "language=ger", "-metadata:s:a:1", "title=Dub",
+				"-disposition:a:0", "0", "-disposition:a:1", "default",
+				"/dst.mkv",
+			},
+		},
+		{
+			desc: "default audio file overrides the selected default",
+			request: TranscodeRequest{
+				Source:      "/src.mkv",
+				Destination: "/dst.mkv",
+				Codec:       "copy",
+				Streams:     &StreamSelection{Audio_languages: []string{"eng"}, Default_audio: "eng"},
+				Audio_files: []AudioFile{{Path: "/dub.ac3", Language: "ger", Default: true}},
+			},
+			streams: []FfprobeStreams{videoTrack, ac3Track},
+			expected: []string{
+				"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+				"-i", "/src.mkv",
+				"-i", "/dub.ac3",
+				"-c:v", "copy",
+				"-c:a:0", "copy",
+				"-c:s", "copy", "-c:t", "copy",
+				"-map", "0:v:0", "-map", "0:3", "-map", "0:t:?",
+				"-map", "1:a:0", "-c:a:1", "copy", "-metadata:s:a:1", "language=ger",
+				"-disposition:a:0", "0", "-disposition:a:1", "default",
+				"/dst.mkv",
+			},
+		},
+		{
+			desc: "encoding ladder split from one decode",
+			request: TranscodeRequest{
+				Source:        "/src.mkv",
+				Destination:   "/2160p.mkv",
+				Codec:         "libx265",
+				Crf:           18,
+				Video_filters: "crop=3840:1600:0:280",
+				Outputs: []Rendition{
+					{Destination: "/1080p.mkv", Crf: 20, Video_filters: "scale=-2:1080"},
+					{Destination: "/remux.mkv", Codec: "copy"},
+					{Destination: "/720p.mp4", Codec: "libsvtav1", Crf: 30, Video_filters: "scale=-2:720"},
+				},
+			},
+			streams: []FfprobeStreams{videoTrack, ac3Track},
+			expected: slices.Concat(
+				[]string{
+					"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+					"-i", "/src.mkv",
+					"-filter_complex", "[0:v:0]crop=3840:1600:0:280,split=3[s0][s1][s2];[s1]scale=-2:1080[v1];[s2]scale=-2:720[v3]",
+				},
+				mustBuildCodec("libx265", 18, codec.ColorInfo{}),
+				[]string{"-c:a:0", "copy", "-c:s", "copy", "-c:t", "copy", "-map", "[s0]", "-map", "0:3", "-map", "0:t:?", "/2160p.mkv"},
+				mustBuildCodec("libx265", 20, codec.ColorInfo{}),
+				[]string{"-c:a:0", "copy", "-c:s", "copy", "-c:t", "copy", "-map", "[v1]", "-map", "0:3", "-map", "0:t:?", "/1080p.mkv"},
+				[]string{"-c:v", "copy", "-c:a:0", "copy", "-c:s", "copy", "-c:t", "copy", "-map", "0:v:0", "-map", "0:3", "-map", "0:t:?", "/remux.mkv"},
+				mustBuildCodec("libsvtav1", 30, codec.ColorInfo{}),
+				[]string{"-c:a:0", "copy", "-c:s", "mov_text", "-map", "[v3]", "-map", "0:3", "-movflags", "+faststart", "/720p.mp4"},
+			),
+		},
+		{
+			desc: "hardware encoder uploads after the filters",
+			request: TranscodeRequest{
+				Source:        "/src.mkv",
+				Destination:   "/dst.mkv",
+				Codec:         "hevc_vaapi",
+				Crf:           22,
+				Video_filters: "scale=-2:1080",
+			},
+			streams: []FfprobeStreams{videoTrack, ac3Track},
+			expected: slices.Concat(
+				[]string{
+					"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+					"-init_hw_device", "vaapi=va:/dev/dri/renderD128", "-filter_hw_device", "va",
+					"-i", "/src.mkv",
+					"-vf", "scale=-2:1080,format=p010le,hwupload",
+				},
+				mustBuildCodec("hevc_vaapi", 22, codec.ColorInfo{}),
+				[]string{"-c:a:0", "copy", "-c:s", "copy", "-c:t", "copy", "-map", "0:v:0", "-map", "0:3", "-map", "0:t:?", "/dst.mkv"},
+			),
+		},
+		{
+			desc: "hardware rendition in a ladder",
+			request: TranscodeRequest{
+				Source:      "/src.mkv",
+				Destination: "/2160p.mkv",
+				Codec:       "libx265",
+				Crf:         18,
+				Outputs:     []Rendition{{Destination: "/1080p.mkv", Codec: "hevc_qsv", Crf: 24, Video_filters: "scale=-2:1080"}},
+			},
+			streams: []FfprobeStreams{videoTrack, ac3Track},
+			expected: slices.Concat(
+				[]string{
+					"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+					"-init_hw_device", "qsv=qs", "-filter_hw_device", "qs",
+					"-i", "/src.mkv",
+					"-filter_complex", "[0:v:0]split=2[s0][s1];[s1]scale=-2:1080,format=p010le,hwupload=extra_hw_frames=64,format=qsv[v1]",
+				},
+				mustBuildCodec("libx265", 18, codec.ColorInfo{}),
+				[]string{"-c:a:0", "copy", "-c:s", "copy", "-c:t", "copy", "-map", "[s0]", "-map", "0:3", "-map", "0:t:?", "/2160p.mkv"},
+				mustBuildCodec("hevc_qsv", 24, codec.ColorInfo{}),
+				[]string{"-c:a:0", "copy", "-c:s", "copy", "-c:t", "copy", "-map", "[v1]", "-map", "0:3", "-map", "0:t:?", "/1080p.mkv"},
+			),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			result := buildTranscodeArgs(tc.request, tc.streams, codec.ColorInfo{})
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected args: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+// mustBuildCodec returns the options of a registered encoder.
+func mustBuildCodec(name string, crf int, colorMeta codec.ColorInfo) []string {
+	args, err := codec.BuildCodec(name, crf, colorMeta)
+	if err != nil {
+		panic(err)
+	}
+	return args
+}
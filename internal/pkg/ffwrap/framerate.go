@@ -0,0 +1,286 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/logger"
+)
+
+const (
+	// FrameRateModeCfr converts the output to a constant frame rate,
+	// duplicating and dropping frames to keep the timeline of the source.
+	FrameRateModeCfr = "cfr"
+	// FrameRateModeVfr keeps the timestamps of the source frames unchanged.
+	FrameRateModeVfr = "vfr"
+
+	// vfrRateTolerance is the relative difference between the base and the
+	// average frame rate of a stream above which it is variable.
+	vfrRateTolerance = 0.002
+	// frameIntervalTolerance is the relative difference from the median frame
+	// interval above which an interval is irregular.
+	frameIntervalTolerance = 0.1
+	// vfrIrregularShare is the share of irregular frame intervals above which
+	// a stream is variable.
+	vfrIrregularShare = 0.02
+	// frameRateProbeSeconds is the length of the start of the source whose
+	// frame timestamps are analysed.
+	frameRateProbeSeconds = 60
+	// standardRateTolerance is the relative difference up to which the average
+	// rate of a variable source is snapped to a standard rate.
+	standardRateTolerance = 0.02
+	// maxFrameRate is the highest frame rate a request may ask for.
+	maxFrameRate = 240
+)
+
+// standardFrameRates are the rates the average rate of a variable source is
+// converted to when it is close to one of them.
+var standardFrameRates = []string{"24000/1001", "24", "25", "30000/1001", "30", "48", "50", "60000/1001", "60", "120"}
+
+// videoStream returns the first video stream of the inventory that is not
+// cover art.
+func videoStream(streams []FfprobeStreams) (FfprobeStreams, bool) {
+	for _, s := range streams {
+		if s.Codec_type == "video" && !isAttachedPicture(s) {
+			return s, true
+		}
+	}
+	return FfprobeStreams{}, false
+}
+
+// variableRate reports whether the container signals a variable frame rate
+// for the stream: its average rate differs from its base rate.
+func (s FfprobeStreams) variableRate() bool {
+	base, avg := parseRational(s.R_frame_rate), parseRational(s.Avg_frame_rate)
+	if base <= 0 || avg <= 0 {
+		return false
+	}
+	return math.Abs(base-avg)/base > vfrRateTolerance
+}
+
+// frameRate returns the rate of the stream as an ffprobe ratio, the average
+// rate unless it is unknown.
+func (s FfprobeStreams) frameRate() string {
+	if parseRational(s.Avg_frame_rate) > 0 {
+		return s.Avg_frame_rate
+	}
+	if parseRational(s.R_frame_rate) > 0 {
+		return s.R_frame_rate
+	}
+	return ""
+}
+
+// cfrRate returns the constant rate a stream is converted to at its source
+// rate. Variable streams are converted to the standard rate closest to their
+// average, or to the average itself when no standard rate is close to it.
+func (s FfprobeStreams) cfrRate(vfr bool) string {
+	if !vfr && parseRational(s.R_frame_rate) > 0 {
+		return s.R_frame_rate
+	}
+	avg := s.FrameRate()
+	if avg <= 0 {
+		return ""
+	}
+	closest, distance := "", standardRateTolerance
+	for _, r := range standardFrameRates {
+		if d := math.Abs(parseRational(r)-avg) / avg; d <= distance {
+			closest, distance = r, d
+		}
+	}
+	if closest != "" {
+		return closest
+	}
+	return strconv.FormatFloat(math.Round(avg*1000)/1000, 'f', -1, 64)
+}
+
+// formatFrameRate formats an ffprobe ratio in frames per second, e.g. 23.976.
+func formatFrameRate(r string) string {
+	return strconv.FormatFloat(math.Round(parseRational(r)*1000)/1000, 'f', -1, 64)
+}
+
+// FrameRateString describes the frame rate of the source for display on the
+// status page, e.g. 29.97 fps (variable).
+func (m MediaMetadata) FrameRateString() string {
+	if parseRational(m.Frame_rate) <= 0 {
+		return ""
+	}
+	s := formatFrameRate(m.Frame_rate) + " fps"
+	if m.Vfr {
+		s += " (variable)"
+	}
+	return s
+}
+
+// frameTimestampArgs returns the ffprobe arguments listing the presentation
+// timestamps of the video packets at the start of the source.
+func frameTimestampArgs(source string) []string {
+	return []string{"-v", "error", "-select_streams", "v:0", "-read_intervals", fmt.Sprintf("%%+%d", frameRateProbeSeconds),
+		"-show_entries", "packet=pts_time", "-of", "csv=p=0", source}
+}
+
+// parseFrameIntervals returns the intervals between consecutive frames of a
+// list of packet timestamps, which are in decode order.
+func parseFrameIntervals(out []byte) []float64 {
+	var pts []float64
+	for _, l := range strings.Split(string(out), "\n") {
+		if t, err := strconv.ParseFloat(strings.Trim(l, " \r,"), 64); err == nil {
+			pts = append(pts, t)
+		}
+	}
+	slices.Sort(pts)
+	var intervals []float64
+	for i := 1; i < len(pts); i++ {
+		if d := pts[i] - pts[i-1]; d > 0 {
+			intervals = append(intervals, d)
+		}
+	}
+	return intervals
+}
+
+// irregularIntervals reports whether enough frame intervals differ from the
+// median interval for the frames to have been captured at a variable rate.
+func irregularIntervals(intervals []float64) bool {
+	if len(intervals) < 2 {
+		return false
+	}
+	sorted := slices.Clone(intervals)
+	slices.Sort(sorted)
+	median := sorted[len(sorted)/2]
+	var irregular int
+	for _, d := range intervals {
+		if math.Abs(d-median)/median > frameIntervalTolerance {
+			irregular++
+		}
+	}
+	return float64(irregular)/float64(len(intervals)) > vfrIrregularShare
+}
+
+// DetectVfr analyses the timestamps of the frames at the start of the source
+// and reports whether they are spaced irregularly, as they are in phone
+// recordings and screen captures whose container signals a constant rate.
+func DetectVfr(ctx context.Context, source string) (bool, error) {
+	args := frameTimestampArgs(source)
+	logger.Infof("calling ffprobe with: %#v", args)
+	var serr bytes.Buffer
+	cmd := exec.CommandContext(ctx, ffprobebinary, args...)
+	cmd.Stderr = &serr
+	out, err := cmd.Output()
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return false, ctx.Err()
+	} else if err != nil {
+		return false, fmt.Errorf("%q failed to probe frame timestamps: %v: %s", source, err, serr.String())
+	}
+	return irregularIntervals(parseFrameIntervals(out)), nil
+}
+
+// frameRateMode returns the frame rate mode of the request, a chosen rate
+// implying cfr.
+func (tr TranscodeRequest) frameRateMode() string {
+	if tr.Frame_rate_mode == "" && tr.Frame_rate != "" {
+		return FrameRateModeCfr
+	}
+	return strings.ToLower(tr.Frame_rate_mode)
+}
+
+// FrameRateSetting describes the frame rate the request writes for display on
+// the status page.
+func (tr TranscodeRequest) FrameRateSetting() string {
+	switch tr.frameRateMode() {
+	case FrameRateModeCfr:
+		if tr.Frame_rate != "" {
+			return fmt.Sprintf("cfr %s fps", formatFrameRate(tr.Frame_rate))
+		}
+		return "cfr at source rate"
+	case FrameRateModeVfr:
+		return "vfr"
+	}
+	return ""
+}
+
+// frameRateArgs returns the options setting the frame rate of an encoded
+// output. Cfr outputs are written at the request's rate or the source rate of
+// the first video stream, vfr outputs keep the source timestamps.
+func (tr TranscodeRequest) frameRateArgs(streams []FfprobeStreams) []string {
+	if strings.EqualFold(tr.Codec, "copy") {
+		return nil
+	}
+	switch tr.frameRateMode() {
+	case FrameRateModeCfr:
+		args := []string{"-fps_mode:v", "cfr"}
+		rate := tr.Frame_rate
+		if s, ok := videoStream(streams); ok && rate == "" {
+			rate = s.cfrRate(s.variableRate())
+		}
+		if rate != "" {
+			args = append(args, "-r:v", rate)
+		}
+		return args
+	case FrameRateModeVfr:
+		return []string{"-fps_mode:v", "passthrough"}
+	}
+	return nil
+}
+
+// audioSyncFilter returns the filter keeping encoded audio in sync with video
+// converted to a constant rate by stretching and padding it to the timestamps
+// of the source. Copied audio keeps its timestamps and needs no filter.
+func (tr TranscodeRequest) audioSyncFilter() string {
+	if tr.frameRateMode() != FrameRateModeCfr {
+		return ""
+	}
+	return "aresample=async=1:first_pts=0"
+}
+
+// audioFilters returns the audio filters of the request followed by the filter
+// keeping audio in sync with its video.
+func (tr TranscodeRequest) audioFilters() string {
+	return joinFilters(tr.Audio_filters, tr.audioSyncFilter())
+}
+
+// validateFrameRate checks the frame rate mode and the chosen rate of a
+// request.
+func (tr TranscodeRequest) validateFrameRate() error {
+	switch tr.frameRateMode() {
+	case "":
+		return nil
+	case FrameRateModeCfr, FrameRateModeVfr:
+	default:
+		return fmt.Errorf("unsupported frame rate mode %q", tr.Frame_rate_mode)
+	}
+	if !tr.encodesVideo() {
+		return fmt.Errorf("frame rate options require an encoded video output")
+	}
+	if tr.Frame_rate == "" {
+		if tr.frameRateMode() == FrameRateModeVfr && strings.EqualFold(tr.Job_type, JobTypeConcat) {
+			return fmt.Errorf("concat jobs are converted to a constant frame rate")
+		}
+		return nil
+	}
+	if tr.frameRateMode() == FrameRateModeVfr {
+		return fmt.Errorf("frame rate %q can't be kept variable", tr.Frame_rate)
+	}
+	if r := parseRational(tr.Frame_rate); r <= 0 || r > maxFrameRate {
+		return fmt.Errorf("invalid frame rate %q, use a rate such as 25 or 24000/1001 up to %d", tr.Frame_rate, maxFrameRate)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package ffwrap
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCfrRate(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		stream FfprobeStreams
+		vfr    bool
+		rate   string
+	}{
+		{desc: "film", stream: FfprobeStreams{R_frame_rate: "24000/1001", Avg_frame_rate: "24000/1001"}, rate: "24000/1001"},
+		{desc: "phone recording", stream: FfprobeStreams{R_frame_rate: "30/1", Avg_frame_rate: "1795/60"}, vfr: true, rate: "30000/1001"},
+		{desc: "screen capture", stream: FfprobeStreams{R_frame_rate: "1000/1", Avg_frame_rate: "12300/1000"}, vfr: true, rate: "12.3"},
+		{desc: "irregular timestamps", stream: FfprobeStreams{R_frame_rate: "60/1", Avg_frame_rate: "60/1"}, rate: "60/1"},
+		{desc: "unknown rate", stream: FfprobeStreams{R_frame_rate: "0/0"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if got := tc.stream.variableRate(); got != tc.vfr {
+				t.Errorf("%q: variableRate() = %v, want %v", tc.desc, got, tc.vfr)
+			}
+			if got := tc.stream.cfrRate(tc.stream.variableRate()); got != tc.rate {
+				t.Errorf("%q: cfrRate() = %q, want %q", tc.desc, got, tc.rate)
+			}
+		})
+	}
+}
+
+func TestIrregularIntervals(t *testing.T) {
+	constant := []byte("0.000000\n0.066733\n0.033367\n0.100100\n0.133467\n0.166833\n")
+	if diff := cmp.Diff([]float64{0.033367, 0.033366, 0.033367, 0.033367, 0.033366}, parseFrameIntervals(constant), cmp.Comparer(func(a, b float64) bool { return a-b < 1e-9 && b-a < 1e-9 })); diff != "" {
+		t.Errorf("unexpected frame intervals: %s", diff)
+	}
+	if irregularIntervals(parseFrameIntervals(constant)) {
+		t.Errorf("irregularIntervals() = true for frames in decode order at a constant rate")
+	}
+	variable := []byte("0.000000\n0.033000\n0.066000\n0.140000\n0.173000\n0.206000\n0.300000\n0.333000\n")
+	if !irregularIntervals(parseFrameIntervals(variable)) {
+		t.Errorf("irregularIntervals() = false for frames with gaps")
+	}
+}
+
+func TestFrameRateArgs(t *testing.T) {
+	streams := []FfprobeStreams{
+		{Codec_type: "video", Disposition: map[string]int{"attached_pic": 1}},
+		{Codec_type: "video", R_frame_rate: "30/1", Avg_frame_rate: "1795/60"},
+	}
+	testCases := []struct {
+		desc    string
+		request TranscodeRequest
+		streams []FfprobeStreams
+		args    []string
+		audio   string
+	}{
+		{desc: "unset", request: TranscodeRequest{Audio_filters: "loudnorm"}, streams: streams, audio: "loudnorm"},
+		{desc: "source rate", request: TranscodeRequest{Frame_rate_mode: "cfr"}, streams: streams, args: []string{"-fps_mode:v", "cfr", "-r:v", "30000/1001"}, audio: "aresample=async=1:first_pts=0"},
+		{desc: "chosen rate", request: TranscodeRequest{Frame_rate: "25", Audio_filters: "loudnorm"}, streams: streams, args: []string{"-fps_mode:v", "cfr", "-r:v", "25"}, audio: "loudnorm,aresample=async=1:first_pts=0"},
+		{desc: "source rate without inventory", request: TranscodeRequest{Frame_rate_mode: "CFR"}, args: []string{"-fps_mode:v", "cfr"}, audio: "aresample=async=1:first_pts=0"},
+		{desc: "variable", request: TranscodeRequest{Frame_rate_mode: "vfr"}, streams: streams, args: []string{"-fps_mode:v", "passthrough"}},
+		{desc: "copy", request: TranscodeRequest{Codec: "copy", Frame_rate_mode: "cfr"}, streams: streams, audio: "aresample=async=1:first_pts=0"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(tc.args, tc.request.frameRateArgs(tc.streams)); diff != "" {
+				t.Errorf("%q: frameRateArgs() differed: %s", tc.desc, diff)
+			}
+			if got := tc.request.audioFilters(); got != tc.audio {
+				t.Errorf("%q: audioFilters() = %q, want %q", tc.desc, got, tc.audio)
+			}
+		})
+	}
+}
+
+func TestValidateFrameRate(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		shouldError bool
+	}{
+		{desc: "defaults"},
+		{desc: "cfr at source rate", request: TranscodeRequest{Frame_rate_mode: "cfr"}},
+		{desc: "ntsc rate", request: TranscodeRequest{Frame_rate: "30000/1001"}},
+		{desc: "decimal rate", request: TranscodeRequest{Frame_rate_mode: "cfr", Frame_rate: "23.976"}},
+		{desc: "keep vfr", request: TranscodeRequest{Frame_rate_mode: "vfr"}},
+		{desc: "vfr with a rate", request: TranscodeRequest{Frame_rate_mode: "vfr", Frame_rate: "30"}, shouldError: true},
+		{desc: "unknown mode", request: TranscodeRequest{Frame_rate_mode: "auto"}, shouldError: true},
+		{desc: "malformed rate", request: TranscodeRequest{Frame_rate: "fast"}, shouldError: true},
+		{desc: "rate too high", request: TranscodeRequest{Frame_rate: "1000"}, shouldError: true},
+		{desc: "copy", request: TranscodeRequest{Codec: "copy", Frame_rate: "25"}, shouldError: true},
+		{desc: "concat kept variable", request: TranscodeRequest{Job_type: "concat", Frame_rate_mode: "vfr"}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if err := tc.request.validateFrameRate(); (err != nil) != tc.shouldError {
+				t.Errorf("%q: validateFrameRate() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/logger"
+)
+
+const (
+	// HdrPolicyPreserve keeps Dolby Vision and HDR10+ where the encoder and
+	// container of an output can carry them and converts them to HDR10
+	// otherwise.
+	HdrPolicyPreserve = "preserve"
+	// HdrPolicyHdr10 strips Dolby Vision and HDR10+ from every output, leaving
+	// the HDR10 compatible base layer.
+	HdrPolicyHdr10 = "hdr10"
+	// HdrPolicyRefuse fails jobs whose source carries dynamic HDR metadata.
+	HdrPolicyRefuse = "refuse"
+
+	// SideDataTypeDovi is the stream side data describing a Dolby Vision
+	// stream.
+	SideDataTypeDovi = "DOVI configuration record"
+
+	// hdr10PlusStripFilter removes the HDR10+ metadata from decoded frames so
+	// that encoders passing it through don't write it.
+	hdr10PlusStripFilter = "sidedata=mode=delete:type=DYNAMIC_HDR_PLUS"
+)
+
+// hdr10PlusUnits maps the codecs carrying HDR10+ in band to the units holding
+// it, the prefix SEI NAL units of HEVC and the metadata OBUs of AV1. Removing
+// them also removes the mastering display and light level SEI or OBUs, so
+// copies are only stripped when the container describes the mastering display.
+var hdr10PlusUnits = map[string]string{"hevc": "39", "av1": "5"}
+
+// ErrUnsupportedHdr is returned for sources whose HDR metadata the hdr policy
+// refuses or an output can't be written without.
+var ErrUnsupportedHdr = errors.New("unsupported hdr source")
+
+// HdrInfo describes the dynamic range of a source: the codec and transfer of
+// its base layer, its Dolby Vision configuration, whether its frames carry
+// HDR10+ dynamic metadata and whether its container describes the mastering
+// display.
+type HdrInfo struct {
+	Codec               string
+	Transfer            string
+	Dovi                *FfprobeSideData
+	Hdr10_plus          bool
+	Container_mastering bool
+}
+
+// detectHdr combines the Dolby Vision configuration and mastering display of
+// the first video stream with the side data of the sampled frames.
+func detectHdr(streams []FfprobeStreams, colorMeta codec.ColorInfo) HdrInfo {
+	info := HdrInfo{Transfer: strings.ToLower(colorMeta.Color_transfer)}
+	for _, s := range streams {
+		if s.Codec_type != "video" || isAttachedPicture(s) {
+			continue
+		}
+		info.Codec = strings.ToLower(s.Codec)
+		if info.Transfer == "" {
+			info.Transfer = strings.ToLower(s.Color_transfer)
+		}
+		for _, sd := range s.Side_data_list {
+			switch {
+			case strings.EqualFold(sd.Side_data_type, SideDataTypeDovi):
+				dovi := sd
+				info.Dovi = &dovi
+			case strings.EqualFold(sd.Side_data_type, codec.SideDataTypeMastering):
+				info.Container_mastering = true
+			}
+		}
+		break
+	}
+	for _, sd := range colorMeta.Side_data_list {
+		if strings.EqualFold(sd.Side_data_type, codec.SideDataTypeHdr10Plus) {
+			info.Hdr10_plus = true
+		}
+	}
+	return info
+}
+
+// dynamic reports whether the source carries Dolby Vision or HDR10+ metadata.
+func (h HdrInfo) dynamic() bool {
+	return h.Dovi != nil || h.Hdr10_plus
+}
+
+// doviStrippable reports whether removing the Dolby Vision metadata leaves a
+// playable base layer. Profile 7 and the compatible variants of profile 8
+// carry an HDR10, SDR or HLG base layer, profile 5 does not.
+func (h HdrInfo) doviStrippable() bool {
+	switch h.Dovi.Dv_profile {
+	case 7:
+		return true
+	case 8:
+		return h.Dovi.Dv_bl_signal_compatibility_id != 0
+	}
+	return false
+}
+
+// String names the HDR format of the source for display on the status page.
+func (h HdrInfo) String() string {
+	var formats []string
+	if h.Dovi != nil {
+		if h.Dovi.Dv_profile == 8 {
+			formats = append(formats, fmt.Sprintf("Dolby Vision %d.%d", h.Dovi.Dv_profile, h.Dovi.Dv_bl_signal_compatibility_id))
+		} else {
+			formats = append(formats, fmt.Sprintf("Dolby Vision %d", h.Dovi.Dv_profile))
+		}
+	}
+	if h.Hdr10_plus {
+		formats = append(formats, "HDR10+")
+	}
+	switch h.Transfer {
+	case "smpte2084":
+		if !h.Hdr10_plus {
+			formats = append(formats, "HDR10")
+		}
+	case "arib-std-b67":
+		formats = append(formats, "HLG")
+	default:
+		if len(formats) == 0 {
+			formats = append(formats, "SDR")
+		}
+	}
+	return strings.Join(formats, ", ")
+}
+
+// hdrPolicy returns the policy of the request, preserve unless set.
+func (tr TranscodeRequest) hdrPolicy() string {
+	if tr.Hdr_policy == "" {
+		return HdrPolicyPreserve
+	}
+	return strings.ToLower(tr.Hdr_policy)
+}
+
+// doviEncoder reports whether the encoder writes the Dolby Vision RPUs of the
+// decoded frames, controlled by its dolbyvision option.
+func doviEncoder(enc string) bool {
+	enc = strings.ToLower(ffmpegEncoder(enc))
+	return enc == "libx265" || enc == "libsvtav1"
+}
+
+// hdrContainer returns the container the video of an output is written to,
+// packaged outputs are written to fmp4 or ts segments.
+func (tr TranscodeRequest) hdrContainer() string {
+	if tr.Packaging == nil {
+		return tr.outputContainer()
+	}
+	return tr.Packaging.segmentContainer()
+}
+
+// preservesDovi reports whether an output keeps the Dolby Vision metadata of
+// the source.
+func (tr TranscodeRequest) preservesDovi(colorMeta codec.ColorInfo) bool {
+	if tr.hdrPolicy() != HdrPolicyPreserve || tr.toneMapFilter(colorMeta) != "" {
+		return false
+	}
+	if !strings.EqualFold(tr.Codec, "copy") && !doviEncoder(tr.Codec) {
+		return false
+	}
+	switch tr.hdrContainer() {
+	case ContainerMkv, ContainerMp4, ContainerMov:
+		return true
+	}
+	return false
+}
+
+// hdr10PlusEncoder reports whether the encoder writes the HDR10+ metadata of
+// the decoded frames.
+func hdr10PlusEncoder(enc string) bool {
+	return strings.ToLower(ffmpegEncoder(enc)) == "libx265"
+}
+
+// keepsMastering reports whether the container writes the mastering display
+// of copied video outside of its bitstream.
+func keepsMastering(container string) bool {
+	switch container {
+	case ContainerMkv, ContainerMp4, ContainerMov:
+		return true
+	}
+	return false
+}
+
+// preservesHdr10Plus reports whether an output keeps the HDR10+ metadata of
+// the source. Copies keep it as the source carries it, encodes only with an
+// encoder passing it through.
+func (tr TranscodeRequest) preservesHdr10Plus(colorMeta codec.ColorInfo) bool {
+	if tr.hdrPolicy() != HdrPolicyPreserve {
+		return false
+	}
+	if strings.EqualFold(tr.Codec, "copy") {
+		return true
+	}
+	return tr.toneMapFilter(colorMeta) == "" && hdr10PlusEncoder(tr.videoCodec())
+}
+
+// hdr10PlusFilter returns the filter removing the HDR10+ metadata of the
+// source from the frames of an encoded output that doesn't keep it but whose
+// encoder would pass it through.
+func (tr TranscodeRequest) hdr10PlusFilter(colorMeta codec.ColorInfo) string {
+	if strings.EqualFold(tr.Codec, "copy") || !detectHdr(nil, colorMeta).Hdr10_plus {
+		return ""
+	}
+	if !hdr10PlusEncoder(tr.videoCodec()) || tr.preservesHdr10Plus(colorMeta) {
+		return ""
+	}
+	return hdr10PlusStripFilter
+}
+
+// hdrArgs returns the options keeping or stripping the Dolby Vision and HDR10+
+// metadata of the source in an output. Copies are stripped with the dovi_rpu
+// and filter_units bitstream filters, encoders with a dolbyvision option are
+// told not to write it. HDR10+ is removed from the frames of encodes by
+// hdr10PlusFilter.
+func (tr TranscodeRequest) hdrArgs(info HdrInfo, colorMeta codec.ColorInfo) []string {
+	copying := strings.EqualFold(tr.Codec, "copy")
+	var args, bsfs []string
+	if info.Dovi != nil {
+		switch keep := tr.preservesDovi(colorMeta); {
+		case keep && copying:
+		case keep:
+			args = append(args, "-dolbyvision", "1")
+		case copying:
+			bsfs = append(bsfs, "dovi_rpu=strip=1")
+		case doviEncoder(tr.Codec):
+			args = append(args, "-dolbyvision", "0")
+		}
+	}
+	if units, ok := hdr10PlusUnits[info.Codec]; ok && info.Hdr10_plus && copying && !tr.preservesHdr10Plus(colorMeta) {
+		bsfs = append(bsfs, "filter_units=remove_types="+units)
+	}
+	if len(bsfs) > 0 {
+		args = append(args, "-bsf:v", strings.Join(bsfs, ","))
+	}
+	return args
+}
+
+// checkHdr fails jobs the hdr policy refuses, outputs that would lose the
+// Dolby Vision metadata of a source without a playable base layer and copies
+// HDR10+ can't be stripped from without losing their HDR10 metadata. Outputs converted from HDR10+ to HDR10 under
+// the preserve policy are logged.
+func (tr TranscodeRequest) checkHdr(info HdrInfo, colorMeta codec.ColorInfo) error {
+	if tr.hdrPolicy() == HdrPolicyRefuse && info.dynamic() {
+		return fmt.Errorf("%w: source is %s", ErrUnsupportedHdr, info)
+	}
+	for _, o := range tr.renditions() {
+		if info.Dovi != nil && !info.doviStrippable() && !o.preservesDovi(colorMeta) {
+			return fmt.Errorf("%w: %s has no HDR10 base layer and can't be written to %q without its metadata", ErrUnsupportedHdr, info, o.Destination)
+		}
+		if !info.Hdr10_plus || o.preservesHdr10Plus(colorMeta) {
+			continue
+		}
+		if strings.EqualFold(o.Codec, "copy") {
+			if _, ok := hdr10PlusUnits[info.Codec]; !ok {
+				return fmt.Errorf("%w: HDR10+ can't be stripped from the %s video copied to %q", ErrUnsupportedHdr, info.Codec, o.Destination)
+			}
+			if !info.Container_mastering || !keepsMastering(o.hdrContainer()) {
+				return fmt.Errorf("%w: stripping HDR10+ from the video copied to %q would remove its mastering display metadata, encode it instead", ErrUnsupportedHdr, o.Destination)
+			}
+		}
+		if o.hdrPolicy() == HdrPolicyPreserve {
+			logger.Warningf("%q is written without the HDR10+ metadata of the source, %s doesn't carry it", o.Destination, o.videoCodec())
+		}
+	}
+	return nil
+}
+
+// validateHdrPolicy checks the hdr policy of a request.
+func (tr TranscodeRequest) validateHdrPolicy() error {
+	switch tr.hdrPolicy() {
+	case HdrPolicyPreserve, HdrPolicyHdr10, HdrPolicyRefuse:
+		return nil
+	}
+	return fmt.Errorf("unsupported hdr policy %q", tr.Hdr_policy)
+}
+
+// ProbeHdr detects the HDR format of a source from its inventory and the side
+// data of frames sampled across it.
+func ProbeHdr(ctx context.Context, source string, inventory FfprobeOutput) (HdrInfo, error) {
+	colorMeta, err := probeColor(ctx, source, &inventory)
+	if err != nil && !errors.Is(err, ErrColorUnknown) {
+		return HdrInfo{}, err
+	}
+	return detectHdr(inventory.Streams, colorMeta), nil
+}
@@ -0,0 +1,188 @@
+package ffwrap
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/go-cmp/cmp"
+)
+
+var (
+	dovi81Track = FfprobeStreams{Index: 0, Codec: "hevc", Codec_type: "video", Side_data_list: []FfprobeSideData{
+		{Side_data_type: SideDataTypeDovi, Dv_profile: 8, Dv_level: 6, Rpu_present_flag: 1, Bl_present_flag: 1, Dv_bl_signal_compatibility_id: 1},
+	}}
+	dovi5Track = FfprobeStreams{Index: 0, Codec: "hevc", Codec_type: "video", Side_data_list: []FfprobeSideData{
+		{Side_data_type: SideDataTypeDovi, Dv_profile: 5, Dv_level: 6, Rpu_present_flag: 1, Bl_present_flag: 1},
+	}}
+	hevcTrack         = FfprobeStreams{Index: 0, Codec: "hevc", Codec_type: "video"}
+	hevcMasteredTrack = FfprobeStreams{Index: 0, Codec: "hevc", Codec_type: "video", Side_data_list: []FfprobeSideData{
+		{Side_data_type: codec.SideDataTypeMastering},
+	}}
+	hdr10PlusColor = codec.ColorInfo{
+		Color_space:     "bt2020nc",
+		Color_primaries: "bt2020",
+		Color_transfer:  "smpte2084",
+		Side_data_list:  []codec.ColorSideInfo{{Side_data_type: codec.SideDataTypeHdr10Plus}},
+	}
+)
+
+func TestDetectHdr(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		streams   []FfprobeStreams
+		colorMeta codec.ColorInfo
+		expected  string
+	}{
+		{desc: "dolby vision with hdr10+", streams: []FfprobeStreams{dovi81Track}, colorMeta: hdr10PlusColor, expected: "Dolby Vision 8.1, HDR10+"},
+		{desc: "dolby vision profile 5", streams: []FfprobeStreams{dovi5Track}, colorMeta: codec.ColorInfo{Color_transfer: "smpte2084"}, expected: "Dolby Vision 5, HDR10"},
+		{desc: "hdr10", colorMeta: hdr10Color, expected: "HDR10"},
+		{desc: "hlg from the stream", streams: []FfprobeStreams{{Codec_type: "video", Color_transfer: "arib-std-b67"}}, expected: "HLG"},
+		{desc: "sdr", colorMeta: codec.ColorInfo{Color_transfer: "bt709"}, expected: "SDR"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if got := detectHdr(tc.streams, tc.colorMeta).String(); got != tc.expected {
+				t.Errorf("%q: detectHdr() = %q, want %q", tc.desc, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHdrArgs(t *testing.T) {
+	dovi := detectHdr([]FfprobeStreams{dovi81Track}, hdr10Color)
+	hdr10Plus := detectHdr([]FfprobeStreams{hevcTrack}, hdr10PlusColor)
+	testCases := []struct {
+		desc     string
+		request  TranscodeRequest
+		info     HdrInfo
+		expected []string
+	}{
+		{desc: "no dolby vision", request: TranscodeRequest{Destination: "/out.mkv", Codec: "libx265"}, info: detectHdr(nil, hdr10PlusColor)},
+		{desc: "preserved copy", request: TranscodeRequest{Destination: "/out.mkv", Codec: "copy"}, info: dovi},
+		{desc: "stripped copy", request: TranscodeRequest{Destination: "/out.mkv", Codec: "copy", Hdr_policy: "hdr10"}, info: dovi, expected: []string{"-bsf:v", "dovi_rpu=strip=1"}},
+		{desc: "preserved encode", request: TranscodeRequest{Destination: "/out.mp4", Codec: "libsvtav1"}, info: dovi, expected: []string{"-dolbyvision", "1"}},
+		{desc: "stripped encode", request: TranscodeRequest{Destination: "/out.mkv", Codec: "libx265", Hdr_policy: "hdr10"}, info: dovi, expected: []string{"-dolbyvision", "0"}},
+		{desc: "tone mapped", request: TranscodeRequest{Destination: "/out.mkv", Codec: "libx265", Tonemap: &ToneMapping{}}, info: dovi, expected: []string{"-dolbyvision", "0"}},
+		{
+			desc:     "ts segments",
+			request:  TranscodeRequest{Destination: "/hls", Codec: "libx265", Packaging: &Packaging{Format: "hls", Segment_type: "ts"}},
+			info:     dovi,
+			expected: []string{"-dolbyvision", "0"},
+		},
+		{desc: "encoder without dolby vision", request: TranscodeRequest{Destination: "/out.mkv", Codec: "hevc_nvenc"}, info: dovi},
+		{desc: "preserved hdr10+ copy", request: TranscodeRequest{Destination: "/out.mkv", Codec: "copy"}, info: hdr10Plus},
+		{
+			desc:     "stripped hdr10+ copy",
+			request:  TranscodeRequest{Destination: "/out.mp4", Codec: "copy", Hdr_policy: "hdr10"},
+			info:     hdr10Plus,
+			expected: []string{"-bsf:v", "filter_units=remove_types=39"},
+		},
+		{
+			desc:     "stripped av1 hdr10+ copy",
+			request:  TranscodeRequest{Destination: "/out.mkv", Codec: "copy", Hdr_policy: "hdr10"},
+			info:     detectHdr([]FfprobeStreams{{Codec: "av1", Codec_type: "video"}}, hdr10PlusColor),
+			expected: []string{"-bsf:v", "filter_units=remove_types=5"},
+		},
+		{
+			desc:     "stripped dolby vision and hdr10+ copy",
+			request:  TranscodeRequest{Destination: "/out.mkv", Codec: "copy", Hdr_policy: "hdr10"},
+			info:     detectHdr([]FfprobeStreams{dovi81Track}, hdr10PlusColor),
+			expected: []string{"-bsf:v", "dovi_rpu=strip=1,filter_units=remove_types=39"},
+		},
+		{desc: "stripped hdr10+ encode", request: TranscodeRequest{Destination: "/out.mkv", Codec: "libx265", Hdr_policy: "hdr10"}, info: hdr10Plus},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(tc.expected, tc.request.hdrArgs(tc.info, hdr10Color)); diff != "" {
+				t.Errorf("%q: unexpected hdr args: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestCheckHdr(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		streams     []FfprobeStreams
+		colorMeta   codec.ColorInfo
+		shouldError bool
+	}{
+		{desc: "refused hdr10+", request: TranscodeRequest{Destination: "/out.mkv", Codec: "libx265", Hdr_policy: "refuse"}, colorMeta: hdr10PlusColor, shouldError: true},
+		{desc: "refuse static hdr10", request: TranscodeRequest{Destination: "/out.mkv", Codec: "libx265", Hdr_policy: "refuse"}, colorMeta: hdr10Color},
+		{desc: "profile 8 to hdr10", request: TranscodeRequest{Destination: "/out.mkv", Codec: "hevc_nvenc"}, streams: []FfprobeStreams{dovi81Track}, colorMeta: hdr10Color},
+		{desc: "profile 5 copied", request: TranscodeRequest{Destination: "/out.mkv", Codec: "copy"}, streams: []FfprobeStreams{dovi5Track}, colorMeta: hdr10Color},
+		{desc: "profile 5 stripped", request: TranscodeRequest{Destination: "/out.mkv", Codec: "hevc_nvenc"}, streams: []FfprobeStreams{dovi5Track}, colorMeta: hdr10Color, shouldError: true},
+		{desc: "hdr10+ copy preserved", request: TranscodeRequest{Destination: "/out.mkv", Codec: "copy"}, streams: []FfprobeStreams{hevcTrack}, colorMeta: hdr10PlusColor},
+		{desc: "hdr10+ stripped from hevc copy", request: TranscodeRequest{Destination: "/out.mkv", Codec: "copy", Hdr_policy: "hdr10"}, streams: []FfprobeStreams{hevcMasteredTrack}, colorMeta: hdr10PlusColor},
+		{
+			desc:        "hdr10+ stripped from hevc copy without container mastering",
+			request:     TranscodeRequest{Destination: "/out.mkv", Codec: "copy", Hdr_policy: "hdr10"},
+			streams:     []FfprobeStreams{hevcTrack},
+			colorMeta:   hdr10PlusColor,
+			shouldError: true,
+		},
+		{
+			desc:        "hdr10+ stripped from hevc copied to ts segments",
+			request:     TranscodeRequest{Destination: "/hls", Codec: "copy", Hdr_policy: "hdr10", Packaging: &Packaging{Format: "hls", Segment_type: "ts"}},
+			streams:     []FfprobeStreams{hevcMasteredTrack},
+			colorMeta:   hdr10PlusColor,
+			shouldError: true,
+		},
+		{
+			desc:        "hdr10+ stripped from vp9 copy",
+			request:     TranscodeRequest{Destination: "/out.webm", Codec: "copy", Hdr_policy: "hdr10"},
+			streams:     []FfprobeStreams{{Index: 0, Codec: "vp9", Codec_type: "video"}},
+			colorMeta:   hdr10PlusColor,
+			shouldError: true,
+		},
+		{desc: "hdr10+ converted to hdr10 by nvenc", request: TranscodeRequest{Destination: "/out.mkv", Codec: "hevc_nvenc"}, streams: []FfprobeStreams{hevcTrack}, colorMeta: hdr10PlusColor},
+		{
+			desc:        "profile 5 rendition stripped",
+			request:     TranscodeRequest{Destination: "/out.mkv", Codec: "libx265", Outputs: []Rendition{{Destination: "/out.webm", Codec: "libsvtav1"}}},
+			streams:     []FfprobeStreams{dovi5Track},
+			colorMeta:   hdr10Color,
+			shouldError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.request.checkHdr(detectHdr(tc.streams, tc.colorMeta), tc.colorMeta)
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("%q: checkHdr() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+			if err != nil && !errors.Is(err, ErrUnsupportedHdr) {
+				t.Errorf("%q: checkHdr() err = %v, want ErrUnsupportedHdr", tc.desc, err)
+			}
+		})
+	}
+}
+
+func TestHdr10PlusFilter(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		request   TranscodeRequest
+		colorMeta codec.ColorInfo
+		expected  string
+	}{
+		{desc: "preserved by libx265", request: TranscodeRequest{Codec: "libx265"}, colorMeta: hdr10PlusColor},
+		{desc: "stripped before libx265", request: TranscodeRequest{Codec: "libx265_grain", Hdr_policy: "hdr10"}, colorMeta: hdr10PlusColor, expected: hdr10PlusStripFilter},
+		{desc: "stripped before the default codec", request: TranscodeRequest{Hdr_policy: "hdr10"}, colorMeta: hdr10PlusColor, expected: hdr10PlusStripFilter},
+		{desc: "tone mapped", request: TranscodeRequest{Codec: "libx265", Tonemap: &ToneMapping{}}, colorMeta: hdr10PlusColor, expected: hdr10PlusStripFilter},
+		{desc: "encoder dropping it", request: TranscodeRequest{Codec: "hevc_nvenc", Hdr_policy: "hdr10"}, colorMeta: hdr10PlusColor},
+		{desc: "copy", request: TranscodeRequest{Codec: "copy", Hdr_policy: "hdr10"}, colorMeta: hdr10PlusColor},
+		{desc: "static hdr10", request: TranscodeRequest{Codec: "libx265", Hdr_policy: "hdr10"}, colorMeta: hdr10Color},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if got := tc.request.hdr10PlusFilter(tc.colorMeta); got != tc.expected {
+				t.Errorf("%q: hdr10PlusFilter() = %q, want %q", tc.desc, got, tc.expected)
+			}
+		})
+	}
+}
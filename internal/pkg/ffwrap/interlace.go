@@ -0,0 +1,212 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/logger"
+)
+
+const (
+	// ScanAuto classifies the source by analysing samples with idet.
+	ScanAuto        = "auto"
+	ScanProgressive = "progressive"
+	ScanInterlaced  = "interlaced"
+	ScanTelecined   = "telecined"
+
+	DeinterlacerBwdif = "bwdif"
+	DeinterlacerYadif = "yadif"
+
+	// scanSamples is the number of points of the source analysed by idet.
+	scanSamples = 5
+	// scanSampleFrames is the number of frames analysed at every point.
+	scanSampleFrames = 500
+	// interlacedThreshold is the share of interlaced frames above which the
+	// source is not treated as progressive.
+	interlacedThreshold = 0.1
+	// repeatedThreshold is the share of frames with a repeated field above
+	// which interlaced frames are attributed to telecine, 3:2 pulldown repeats
+	// a field in two of every five frames.
+	repeatedThreshold = 0.15
+)
+
+var (
+	idetMultiRegex  = regexp.MustCompile(`Multi frame detection:\s*TFF:\s*(\d+)\s*BFF:\s*(\d+)\s*Progressive:\s*(\d+)\s*Undetermined:\s*(\d+)`)
+	idetRepeatRegex = regexp.MustCompile(`Repeated Fields:\s*Neither:\s*(\d+)\s*Top:\s*(\d+)\s*Bottom:\s*(\d+)`)
+)
+
+// ScanAnalysis holds the frame counts reported by idet for the sampled frames.
+type ScanAnalysis struct {
+	Tff              int `json:"tff"`
+	Bff              int `json:"bff"`
+	Progressive      int `json:"progressive"`
+	Undetermined     int `json:"undetermined"`
+	Repeated_neither int `json:"repeated_neither"`
+	Repeated_top     int `json:"repeated_top"`
+	Repeated_bottom  int `json:"repeated_bottom"`
+}
+
+// add sums the counts of another sample into a.
+func (a *ScanAnalysis) add(b ScanAnalysis) {
+	a.Tff += b.Tff
+	a.Bff += b.Bff
+	a.Progressive += b.Progressive
+	a.Undetermined += b.Undetermined
+	a.Repeated_neither += b.Repeated_neither
+	a.Repeated_top += b.Repeated_top
+	a.Repeated_bottom += b.Repeated_bottom
+}
+
+// Classify returns the scan type of the analysed frames. Sources with few
+// interlaced frames are progressive, interlaced frames accompanied by
+// repeated fields are the result of telecine.
+func (a ScanAnalysis) Classify() string {
+	interlaced := a.Tff + a.Bff
+	if total := interlaced + a.Progressive; total == 0 || float64(interlaced)/float64(total) < interlacedThreshold {
+		return ScanProgressive
+	}
+	repeated := a.Repeated_top + a.Repeated_bottom
+	if total := repeated + a.Repeated_neither; total > 0 && float64(repeated)/float64(total) >= repeatedThreshold {
+		return ScanTelecined
+	}
+	return ScanInterlaced
+}
+
+// String summarises the analysis for display on the status page.
+func (a *ScanAnalysis) String() string {
+	if a == nil {
+		return "not analysed"
+	}
+	return fmt.Sprintf("%d tff, %d bff, %d progressive, %d repeated fields", a.Tff, a.Bff, a.Progressive, a.Repeated_top+a.Repeated_bottom)
+}
+
+// parseIdet extracts the final multi frame and repeated field counts from the
+// output of an idet run.
+func parseIdet(out []byte) (ScanAnalysis, error) {
+	multi := idetMultiRegex.FindAllSubmatch(out, -1)
+	repeat := idetRepeatRegex.FindAllSubmatch(out, -1)
+	if len(multi) == 0 || len(repeat) == 0 {
+		return ScanAnalysis{}, fmt.Errorf("failed to extract idet statistics")
+	}
+	atoi := func(b []byte) int {
+		n, _ := strconv.Atoi(string(b))
+		return n
+	}
+	m, r := multi[len(multi)-1], repeat[len(repeat)-1]
+	return ScanAnalysis{
+		Tff:              atoi(m[1]),
+		Bff:              atoi(m[2]),
+		Progressive:      atoi(m[3]),
+		Undetermined:     atoi(m[4]),
+		Repeated_neither: atoi(r[1]),
+		Repeated_top:     atoi(r[2]),
+		Repeated_bottom:  atoi(r[3]),
+	}, nil
+}
+
+// samplePoints returns the offsets in seconds of n samples analysed in the
+// duration seconds following start, spread evenly across them.
+func samplePoints(start, duration float64, n int) []float64 {
+	if duration <= 0 {
+		return []float64{start}
+	}
+	points := make([]float64, 0, n)
+	for i := range n {
+		points = append(points, start+float64(int(duration*(float64(i)+0.5)/float64(n))))
+	}
+	return points
+}
+
+// idetArgs returns the arguments running idet on a sample starting at start.
+func idetArgs(source string, start float64) []string {
+	args := append([]string{"-hide_banner"}, ffcommon...)
+	return append(args, "-ss", formatSeconds(start), "-i", source, "-map", "0:v:0",
+		"-vf", "idet", "-frames:v", strconv.Itoa(scanSampleFrames), "-an", "-f", "null", "NUL")
+}
+
+// DetectScanType runs idet on samples taken from the duration seconds of the
+// source following start and returns the combined counts.
+func DetectScanType(ctx context.Context, source string, start, duration float64) (ScanAnalysis, error) {
+	var total ScanAnalysis
+	for _, p := range samplePoints(start, duration, scanSamples) {
+		args := idetArgs(source, p)
+		logger.Infof("idet with args %#v", args)
+		var serr bytes.Buffer
+		cmd := exec.CommandContext(ctx, ffmpegbinary, args...)
+		cmd.Stderr = &serr
+		if err := cmd.Run(); errors.Is(ctx.Err(), context.Canceled) {
+			return ScanAnalysis{}, ctx.Err()
+		} else if err != nil {
+			return ScanAnalysis{}, fmt.Errorf("failed to exec idet: %v", err)
+		}
+		a, err := parseIdet(serr.Bytes())
+		if err != nil {
+			return ScanAnalysis{}, err
+		}
+		total.add(a)
+	}
+	return total, nil
+}
+
+// ScanFilter returns the filters converting a source of the given scan type to
+// progressive frames: a deinterlacer for interlaced sources and field matching
+// and decimation for telecined sources, with combed frames left over from
+// edits deinterlaced.
+func ScanFilter(scanType, deinterlacer string) string {
+	d := strings.ToLower(deinterlacer)
+	if d == "" {
+		d = DeinterlacerBwdif
+	}
+	switch strings.ToLower(scanType) {
+	case ScanInterlaced:
+		return d
+	case ScanTelecined:
+		return fmt.Sprintf("fieldmatch,%s=deint=interlaced,decimate", d)
+	}
+	return ""
+}
+
+// DetectsScan reports whether the scan type of the source is detected before
+// transcoding. Detection is requested with the auto scan type and is the
+// default for jobs that are autocropped.
+func (tr TranscodeRequest) DetectsScan() bool {
+	return strings.EqualFold(tr.Scan_type, ScanAuto) || tr.Scan_type == "" && tr.Autocrop
+}
+
+// validateScan checks the scan type and deinterlacer of a request.
+func (tr TranscodeRequest) validateScan() error {
+	switch strings.ToLower(tr.Scan_type) {
+	case "", ScanAuto, ScanProgressive, ScanInterlaced, ScanTelecined:
+	default:
+		return fmt.Errorf("unsupported scan type %q", tr.Scan_type)
+	}
+	switch strings.ToLower(tr.Deinterlacer) {
+	case "", DeinterlacerBwdif, DeinterlacerYadif:
+	default:
+		return fmt.Errorf("unsupported deinterlacer %q", tr.Deinterlacer)
+	}
+	copying := strings.EqualFold(tr.Codec, "copy")
+	if copying && (strings.EqualFold(tr.Scan_type, ScanAuto) || ScanFilter(tr.Scan_type, tr.Deinterlacer) != "") {
+		return fmt.Errorf("scan type %q requires filtering and can't be used when copying video", tr.Scan_type)
+	}
+	return nil
+}
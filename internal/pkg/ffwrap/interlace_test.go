@@ -0,0 +1,103 @@
+package ffwrap
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseIdet(t *testing.T) {
+	out := []byte(`[Parsed_idet_0 @ 0x55d0c8a0] Repeated Fields: Neither:   301 Top:   100 Bottom:    99
+[Parsed_idet_0 @ 0x55d0c8a0] Single frame detection: TFF:   120 BFF:     0 Progressive:   290 Undetermined:    90
+[Parsed_idet_0 @ 0x55d0c8a0] Multi frame detection: TFF:   198 BFF:     0 Progressive:   299 Undetermined:     3
+`)
+	want := ScanAnalysis{Tff: 198, Progressive: 299, Undetermined: 3, Repeated_neither: 301, Repeated_top: 100, Repeated_bottom: 99}
+	got, err := parseIdet(out)
+	if err != nil {
+		t.Fatalf("parseIdet() unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected idet statistics: %s", diff)
+	}
+	if _, err := parseIdet([]byte("Conversion failed!")); err == nil {
+		t.Errorf("parseIdet() expected an error without idet statistics")
+	}
+}
+
+func TestClassifyScan(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		analysis ScanAnalysis
+		expected string
+	}{
+		{desc: "progressive", analysis: ScanAnalysis{Tff: 3, Progressive: 2497, Repeated_neither: 2500}, expected: ScanProgressive},
+		{desc: "interlaced", analysis: ScanAnalysis{Tff: 2400, Progressive: 60, Undetermined: 40, Repeated_neither: 2490, Repeated_top: 5, Repeated_bottom: 5}, expected: ScanInterlaced},
+		{desc: "telecined", analysis: ScanAnalysis{Tff: 1000, Progressive: 1490, Undetermined: 10, Repeated_neither: 1500, Repeated_top: 500, Repeated_bottom: 500}, expected: ScanTelecined},
+		{desc: "nothing analysed", expected: ScanProgressive},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if got := tc.analysis.Classify(); got != tc.expected {
+				t.Errorf("%q: Classify() = %q, want %q", tc.desc, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestScanFilter(t *testing.T) {
+	testCases := []struct {
+		desc         string
+		scanType     string
+		deinterlacer string
+		expected     string
+	}{
+		{desc: "progressive", scanType: ScanProgressive},
+		{desc: "undetected", scanType: ScanAuto},
+		{desc: "interlaced", scanType: ScanInterlaced, expected: "bwdif"},
+		{desc: "interlaced with yadif", scanType: ScanInterlaced, deinterlacer: "yadif", expected: "yadif"},
+		{desc: "telecined", scanType: ScanTelecined, expected: "fieldmatch,bwdif=deint=interlaced,decimate"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if got := ScanFilter(tc.scanType, tc.deinterlacer); got != tc.expected {
+				t.Errorf("%q: ScanFilter() = %q, want %q", tc.desc, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestValidateScan(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		shouldError bool
+	}{
+		{desc: "unset", request: TranscodeRequest{Codec: "copy"}},
+		{desc: "detected", request: TranscodeRequest{Codec: "libx265", Scan_type: "auto"}},
+		{desc: "progressive copy", request: TranscodeRequest{Codec: "copy", Scan_type: "progressive"}},
+		{desc: "detected copy", request: TranscodeRequest{Codec: "copy", Scan_type: "auto"}, shouldError: true},
+		{desc: "interlaced copy", request: TranscodeRequest{Codec: "copy", Scan_type: "interlaced"}, shouldError: true},
+		{desc: "unknown scan type", request: TranscodeRequest{Codec: "libx265", Scan_type: "mixed"}, shouldError: true},
+		{desc: "unknown deinterlacer", request: TranscodeRequest{Codec: "libx265", Scan_type: "interlaced", Deinterlacer: "w3fdif"}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.request.validateScan()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validateScan() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
+
+func TestSamplePoints(t *testing.T) {
+	if diff := cmp.Diff([]float64{70, 150, 230, 310, 390}, samplePoints(30, 400, scanSamples)); diff != "" {
+		t.Errorf("unexpected sample points: %s", diff)
+	}
+	if diff := cmp.Diff([]float64{0}, samplePoints(0, 0, scanSamples)); diff != "" {
+		t.Errorf("unexpected sample points without duration: %s", diff)
+	}
+}
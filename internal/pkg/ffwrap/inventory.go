@@ -0,0 +1,149 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// pixFmtDepthRegex extracts the bit depth from pixel formats such as yuv420p10le.
+var pixFmtDepthRegex = regexp.MustCompile(`p(\d{2})(le|be)$`)
+
+// BitDepth returns the bits per sample of the stream, or 0 when ffprobe did
+// not report it. Video streams fall back to the depth implied by the pixel format.
+func (s FfprobeStreams) BitDepth() int {
+	if n, err := strconv.Atoi(s.Bits_per_raw_sample); err == nil && n > 0 {
+		return n
+	}
+	if s.Bits_per_sample > 0 {
+		return s.Bits_per_sample
+	}
+	if m := pixFmtDepthRegex.FindStringSubmatch(s.Pix_fmt); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		return n
+	}
+	if s.Codec_type == "video" && s.Pix_fmt != "" {
+		return 8
+	}
+	return 0
+}
+
+// FrameRate returns the average frame rate of the stream in frames per second,
+// falling back to the base rate when the average is unknown.
+func (s FfprobeStreams) FrameRate() float64 {
+	if r := parseRational(s.Avg_frame_rate); r > 0 {
+		return r
+	}
+	return parseRational(s.R_frame_rate)
+}
+
+// parseRational converts ffprobe ratios such as 24000/1001 to a float, returning
+// 0 for malformed or undefined values.
+func parseRational(r string) float64 {
+	num, den, found := strings.Cut(r, "/")
+	if !found {
+		den = "1"
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// isAttachedPicture reports whether a video stream is embedded cover art.
+func isAttachedPicture(s FfprobeStreams) bool {
+	return s.Disposition["attached_pic"] == 1
+}
+
+// MediaMetadata summarises the first video stream of the inventory in the form
+// stored in source_metadata. The stream is variable rate when its container
+// says so, DetectVfr also finds sources signalling a constant rate.
+func (o FfprobeOutput) MediaMetadata() (MediaMetadata, error) {
+	for _, s := range o.Streams {
+		if s.Codec_type != "video" || isAttachedPicture(s) {
+			continue
+		}
+		return MediaMetadata{
+			Duration:   formatSexagesimal(o.Format.Duration),
+			Codec:      s.Codec,
+			Width:      s.Width,
+			Height:     s.Height,
+			Frame_rate: s.frameRate(),
+			Vfr:        s.variableRate(),
+		}, nil
+	}
+	return MediaMetadata{}, fmt.Errorf("no video stream found in %d streams", len(o.Streams))
+}
+
+// formatSexagesimal formats a duration in seconds the way ffprobe does when
+// called with -sexagesimal, e.g. 0:42:00.500000.
+func formatSexagesimal(seconds string) string {
+	f, err := strconv.ParseFloat(seconds, 64)
+	if err != nil {
+		return seconds
+	}
+	h := math.Floor(f / 3600)
+	m := math.Floor((f - h*3600) / 60)
+	return fmt.Sprintf("%d:%02d:%09.6f", int(h), int(m), f-h*3600-m*60)
+}
+
+// summary describes a single stream for display on the status page.
+func (s FfprobeStreams) summary() string {
+	parts := []string{s.Codec}
+	switch s.Codec_type {
+	case "video":
+		parts = append(parts, fmt.Sprintf("%dx%d", s.Width, s.Height))
+		if d := s.BitDepth(); d > 0 {
+			parts = append(parts, fmt.Sprintf("%d-bit", d))
+		}
+		if r := s.FrameRate(); r > 0 {
+			parts = append(parts, strings.TrimRight(strings.TrimRight(fmt.Sprintf("%.3f", r), "0"), ".")+"fps")
+		}
+	case "audio":
+		parts = append(parts, streamLanguage(s), fmt.Sprintf("%dch", s.Channels))
+	case "subtitle":
+		parts = append(parts, streamLanguage(s))
+	}
+	return strings.Join(parts, " ")
+}
+
+// String summarises the video, audio and subtitle streams of the inventory for
+// display on the status page.
+func (o *FfprobeOutput) String() string {
+	if o == nil {
+		return "not probed"
+	}
+	var streams []string
+	for _, s := range o.Streams {
+		switch s.Codec_type {
+		case "video", "audio", "subtitle":
+			if !isAttachedPicture(s) {
+				streams = append(streams, s.summary())
+			}
+		}
+	}
+	if len(o.Chapters) > 0 {
+		streams = append(streams, fmt.Sprintf("%d chapters", len(o.Chapters)))
+	}
+	return strings.Join(streams, ", ")
+}
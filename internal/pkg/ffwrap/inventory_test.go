@@ -0,0 +1,53 @@
+package ffwrap
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const sampleProbe = `{
+	"streams": [
+		{"index": 0, "codec_name": "hevc", "codec_type": "video", "profile": "Main 10", "width": 3840, "height": 2160, "pix_fmt": "yuv420p10le",
+		 "r_frame_rate": "24000/1001", "avg_frame_rate": "24000/1001", "sample_aspect_ratio": "1:1", "display_aspect_ratio": "16:9", "disposition": {"default": 1}},
+		{"index": 1, "codec_name": "truehd", "codec_type": "audio", "channels": 8, "channel_layout": "7.1", "bits_per_raw_sample": "24", "tags": {"language": "eng"}},
+		{"index": 2, "codec_name": "hdmv_pgs_subtitle", "codec_type": "subtitle", "tags": {"language": "ger", "title": "Forced"}, "disposition": {"forced": 1}},
+		{"index": 3, "codec_name": "mjpeg", "codec_type": "video", "width": 600, "height": 900, "disposition": {"attached_pic": 1}}
+	],
+	"chapters": [
+		{"id": 0, "start_time": "0.000000", "end_time": "600.000000", "tags": {"title": "Chapter 1"}}
+	],
+	"format": {"format_name": "matroska,webm", "duration": "2520.500000", "bit_rate": "52000000", "tags": {"title": "Example"}}
+}`
+
+func TestFfprobeOutput(t *testing.T) {
+	var inv FfprobeOutput
+	if err := json.Unmarshal([]byte(sampleProbe), &inv); err != nil {
+		t.Fatalf("failed to unmarshal sample probe: %v", err)
+	}
+
+	mm, err := inv.MediaMetadata()
+	if err != nil {
+		t.Fatalf("MediaMetadata() returned error: %v", err)
+	}
+	if diff := cmp.Diff(MediaMetadata{Duration: "0:42:00.500000", Codec: "hevc", Width: 3840, Height: 2160, Frame_rate: "24000/1001"}, mm); diff != "" {
+		t.Errorf("unexpected media metadata: %s", diff)
+	}
+
+	if d := inv.Streams[0].BitDepth(); d != 10 {
+		t.Errorf("video bit depth got %d want 10", d)
+	}
+	if d := inv.Streams[1].BitDepth(); d != 24 {
+		t.Errorf("audio bit depth got %d want 24", d)
+	}
+
+	want := "hevc 3840x2160 10-bit 23.976fps, truehd eng 8ch, hdmv_pgs_subtitle ger, 1 chapters"
+	if got := inv.String(); got != want {
+		t.Errorf("String() got %q want %q", got, want)
+	}
+
+	if _, err := (FfprobeOutput{Streams: inv.Streams[1:3]}).MediaMetadata(); err == nil {
+		t.Errorf("MediaMetadata() without a video stream should fail")
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+const (
+	// JobTypeMetadata rewrites tags, chapters and cover art of the source
+	// without re-encoding any stream.
+	JobTypeMetadata = "metadata"
+
+	ChaptersCopy = "copy"
+	ChaptersDrop = "drop"
+
+	CoverArtKeep = "keep"
+	CoverArtDrop = "drop"
+)
+
+var (
+	// coverImageFormats are the image types accepted for embedding as cover art.
+	coverImageFormats = map[string]bool{".jpg": true, ".jpeg": true, ".png": true}
+
+	// coverMimetypes are the mimetypes of matroska attachments treated as cover art.
+	coverMimetypes = []string{"image/jpeg", "image/png"}
+)
+
+// validate checks the metadata options against the output container.
+func (m MetadataOptions) validate(container string) error {
+	for k := range m.Tags {
+		if k == "" || strings.ContainsAny(k, "=\n") {
+			return fmt.Errorf("invalid metadata tag name %q", k)
+		}
+	}
+	switch strings.ToLower(m.Chapters) {
+	case "", ChaptersCopy:
+	case ChaptersDrop:
+		if m.Chapters_file != "" {
+			return fmt.Errorf("chapters cannot be dropped and imported from %q", m.Chapters_file)
+		}
+	default:
+		return fmt.Errorf("unsupported chapters option %q", m.Chapters)
+	}
+	switch strings.ToLower(m.Cover_art) {
+	case "", CoverArtKeep, CoverArtDrop:
+	default:
+		return fmt.Errorf("unsupported cover_art option %q", m.Cover_art)
+	}
+	if m.Cover_image != "" {
+		if !coverImageFormats[strings.ToLower(filepath.Ext(m.Cover_image))] {
+			return fmt.Errorf("cover image %q is not a jpeg or png file", m.Cover_image)
+		}
+		if container == ContainerWebm {
+			return fmt.Errorf("webm can't store cover art")
+		}
+	}
+	return nil
+}
+
+// inputArgs returns the input options for the chapters file and cover image.
+func (m MetadataOptions) inputArgs() []string {
+	var args []string
+	if m.Chapters_file != "" {
+		args = append(args, "-f", "ffmetadata", "-i", m.Chapters_file)
+	}
+	if m.Cover_image != "" {
+		args = append(args, "-i", m.Cover_image)
+	}
+	return args
+}
+
+// coverStreams returns the source's cover art stored as attached pictures.
+func coverStreams(streams []FfprobeStreams) []FfprobeStreams {
+	var covers []FfprobeStreams
+	for _, s := range streams {
+		if s.Codec_type == "video" && isAttachedPicture(s) {
+			covers = append(covers, s)
+		}
+	}
+	return covers
+}
+
+// outputArgs returns the options applying the global tags, chapters and cover
+// art. input is the number of the first input added by inputArgs and
+// videoIndex the output index of the first cover art stream.
+func (m MetadataOptions) outputArgs(input, videoIndex int, streams []FfprobeStreams, container string) []string {
+	var args []string
+	if m.Clear_tags {
+		args = append(args, "-map_metadata:g", "-1")
+	}
+	keys := make([]string, 0, len(m.Tags))
+	for k := range m.Tags {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		args = append(args, "-metadata", fmt.Sprintf("%s=%s", k, m.Tags[k]))
+	}
+
+	if m.Chapters_file != "" {
+		args = append(args, "-map_chapters", fmt.Sprintf("%d", input))
+		input++
+	} else if strings.EqualFold(m.Chapters, ChaptersDrop) {
+		args = append(args, "-map_chapters", "-1")
+	}
+
+	var covers []string
+	switch {
+	case container == ContainerWebm:
+	case m.Cover_image != "":
+		covers = append(covers, fmt.Sprintf("%d:v:0", input))
+	case !strings.EqualFold(m.Cover_art, CoverArtDrop):
+		for _, s := range coverStreams(streams) {
+			covers = append(covers, fmt.Sprintf("0:%d", s.Index))
+		}
+	}
+	for i, c := range covers {
+		spec := fmt.Sprintf("v:%d", videoIndex+i)
+		args = append(args, "-map", c, "-c:"+spec, "copy", "-disposition:"+spec, "attached_pic")
+	}
+	if allowsAttachments(container) && (m.Cover_image != "" || strings.EqualFold(m.Cover_art, CoverArtDrop)) {
+		// matroska stores cover art as attachments
+		for _, mt := range coverMimetypes {
+			args = append(args, "-map", fmt.Sprintf("-0:t:m:mimetype:%s", mt))
+		}
+	}
+	return args
+}
+
+// buildMetadataJobArgs generates the arguments of a metadata job, which copies
+// every stream of the source and only rewrites tags, chapters and cover art.
+func buildMetadataJobArgs(tr TranscodeRequest, streams []FfprobeStreams) []string {
+	var m MetadataOptions
+	if tr.Metadata != nil {
+		m = *tr.Metadata
+	}
+	container := tr.outputContainer()
+	args := append(append([]string{}, ffquiet...), ffcommon...)
+	args = append(args, tr.trimInputArgs()...)
+	args = append(args, "-i", tr.Source)
+	args = append(args, m.inputArgs()...)
+
+	videos := 0
+	if len(streams) == 0 {
+		// without an inventory cover art can't be told apart from other streams
+		args = append(args, "-map", "0")
+		m.Cover_art = CoverArtKeep
+		m.Cover_image = ""
+	}
+	for _, s := range streams {
+		switch {
+		case s.Codec_type == "attachment":
+			continue
+		case s.Codec_type == "video" && isAttachedPicture(s):
+			continue
+		case s.Codec_type == "video":
+			videos++
+		}
+		args = append(args, "-map", fmt.Sprintf("0:%d", s.Index))
+	}
+	if len(streams) > 0 && allowsAttachments(container) {
+		args = append(args, "-map", "0:t:?")
+	}
+	args = append(args, "-c", "copy")
+	args = append(args, m.outputArgs(1, videos, streams, container)...)
+	args = append(args, tr.trimOutputArgs()...)
+	args = append(args, tr.containerArgs()...)
+	return append(args, tr.Destination)
+}
+
+// String summarises the metadata options for display on the status page.
+func (m *MetadataOptions) String() string {
+	if m == nil {
+		return "unchanged"
+	}
+	var parts []string
+	if m.Clear_tags {
+		parts = append(parts, "clear tags")
+	}
+	if len(m.Tags) > 0 {
+		parts = append(parts, fmt.Sprintf("%d tags", len(m.Tags)))
+	}
+	switch {
+	case m.Chapters_file != "":
+		parts = append(parts, "chapters from "+m.Chapters_file)
+	case strings.EqualFold(m.Chapters, ChaptersDrop):
+		parts = append(parts, "drop chapters")
+	}
+	switch {
+	case m.Cover_image != "":
+		parts = append(parts, "cover "+m.Cover_image)
+	case strings.EqualFold(m.Cover_art, CoverArtDrop):
+		parts = append(parts, "drop cover art")
+	}
+	if len(parts) == 0 {
+		return "unchanged"
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,149 @@
+package ffwrap
+
+import (
+	"testing"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/go-cmp/cmp"
+)
+
+var metadataStreams = []FfprobeStreams{
+	{Index: 0, Codec: "hevc", Codec_type: "video"},
+	{Index: 1, Codec: "eac3", Codec_type: "audio", Channels: 6, Tags: FfprobeTags{Language: "eng"}},
+	{Index: 2, Codec: "subrip", Codec_type: "subtitle", Tags: FfprobeTags{Language: "eng"}},
+	{Index: 3, Codec: "mjpeg", Codec_type: "video", Disposition: map[string]int{"attached_pic": 1}},
+}
+
+func TestMetadataValidate(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		metadata    MetadataOptions
+		container   string
+		shouldError bool
+	}{
+		{desc: "tags", metadata: MetadataOptions{Tags: map[string]string{"title": "A Film"}, Clear_tags: true}, container: ContainerMkv},
+		{desc: "empty tag name", metadata: MetadataOptions{Tags: map[string]string{"": "x"}}, container: ContainerMkv, shouldError: true},
+		{desc: "tag name with equals", metadata: MetadataOptions{Tags: map[string]string{"a=b": "x"}}, container: ContainerMkv, shouldError: true},
+		{desc: "drop chapters", metadata: MetadataOptions{Chapters: "DROP"}, container: ContainerMkv},
+		{desc: "import chapters", metadata: MetadataOptions{Chapters_file: "/chapters.txt"}, container: ContainerMp4},
+		{desc: "drop and import chapters", metadata: MetadataOptions{Chapters: "drop", Chapters_file: "/chapters.txt"}, container: ContainerMkv, shouldError: true},
+		{desc: "unknown chapters option", metadata: MetadataOptions{Chapters: "merge"}, container: ContainerMkv, shouldError: true},
+		{desc: "unknown cover art option", metadata: MetadataOptions{Cover_art: "replace"}, container: ContainerMkv, shouldError: true},
+		{desc: "png cover", metadata: MetadataOptions{Cover_image: "/cover.PNG"}, container: ContainerMp4},
+		{desc: "gif cover", metadata: MetadataOptions{Cover_image: "/cover.gif"}, container: ContainerMkv, shouldError: true},
+		{desc: "webm cover", metadata: MetadataOptions{Cover_image: "/cover.jpg"}, container: ContainerWebm, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.metadata.validate(tc.container)
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validate() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
+
+func TestMetadataJobValidate(t *testing.T) {
+	metadata := &MetadataOptions{Tags: map[string]string{"title": "A Film"}}
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		shouldError bool
+	}{
+		{desc: "metadata job", request: TranscodeRequest{Source: "/a.mkv", Destination: "/b.mkv", Codec: "copy", Job_type: "metadata", Metadata: metadata}},
+		{desc: "unknown job type", request: TranscodeRequest{Source: "/a.mkv", Destination: "/b.mkv", Codec: "copy", Job_type: "remux"}, shouldError: true},
+		{desc: "missing options", request: TranscodeRequest{Source: "/a.mkv", Destination: "/b.mkv", Codec: "copy", Job_type: "metadata"}, shouldError: true},
+		{desc: "in place", request: TranscodeRequest{Source: "/a.mkv", Destination: "/a.mkv", Codec: "copy", Job_type: "metadata", Metadata: metadata}, shouldError: true},
+		{desc: "encoding", request: TranscodeRequest{Source: "/a.mkv", Destination: "/b.mkv", Codec: "libx265", Job_type: "metadata", Metadata: metadata}, shouldError: true},
+		{desc: "filters", request: TranscodeRequest{Source: "/a.mkv", Destination: "/b.mkv", Codec: "copy", Autocrop: true, Job_type: "metadata", Metadata: metadata}, shouldError: true},
+		{
+			desc:        "renditions",
+			request:     TranscodeRequest{Source: "/a.mkv", Destination: "/b.mkv", Codec: "copy", Job_type: "metadata", Metadata: metadata, Outputs: []Rendition{{Destination: "/c.mkv"}}},
+			shouldError: true,
+		},
+		{
+			desc:        "metadata with packaging",
+			request:     TranscodeRequest{Source: "/a.mkv", Destination: "/www", Codec: "libx265", Metadata: metadata, Packaging: &Packaging{Format: "hls"}},
+			shouldError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.request.Validate()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: Validate() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
+
+func TestBuildMetadataJobArgs(t *testing.T) {
+	common := []string{"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M", "-i", "/src.mkv"}
+	testCases := []struct {
+		desc     string
+		request  TranscodeRequest
+		streams  []FfprobeStreams
+		expected []string
+	}{
+		{
+			desc:    "tags and cover art kept",
+			request: TranscodeRequest{Source: "/src.mkv", Destination: "/dst.mkv", Metadata: &MetadataOptions{Clear_tags: true, Tags: map[string]string{"title": "A Film", "comment": ""}}},
+			streams: metadataStreams,
+			expected: append(append([]string{}, common...),
+				"-map", "0:0", "-map", "0:1", "-map", "0:2", "-map", "0:t:?",
+				"-c", "copy",
+				"-map_metadata:g", "-1",
+				"-metadata", "comment=", "-metadata", "title=A Film",
+				"-map", "0:3", "-c:v:1", "copy", "-disposition:v:1", "attached_pic",
+				"/dst.mkv",
+			),
+		},
+		{
+			desc:    "chapters file and replaced cover",
+			request: TranscodeRequest{Source: "/src.mkv", Destination: "/dst.mkv", Metadata: &MetadataOptions{Chapters_file: "/chapters.txt", Cover_image: "/cover.jpg"}},
+			streams: metadataStreams,
+			expected: append(append([]string{}, common...),
+				"-f", "ffmetadata", "-i", "/chapters.txt", "-i", "/cover.jpg",
+				"-map", "0:0", "-map", "0:1", "-map", "0:2", "-map", "0:t:?",
+				"-c", "copy",
+				"-map_chapters", "1",
+				"-map", "2:v:0", "-c:v:1", "copy", "-disposition:v:1", "attached_pic",
+				"-map", "-0:t:m:mimetype:image/jpeg", "-map", "-0:t:m:mimetype:image/png",
+				"/dst.mkv",
+			),
+		},
+		{
+			desc:    "mp4 without chapters or cover",
+			request: TranscodeRequest{Source: "/src.mkv", Destination: "/dst.mp4", Metadata: &MetadataOptions{Chapters: "drop", Cover_art: "drop"}},
+			streams: metadataStreams,
+			expected: append(append([]string{}, common...),
+				"-map", "0:0", "-map", "0:1", "-map", "0:2",
+				"-c", "copy",
+				"-map_chapters", "-1",
+				"-movflags", "+faststart",
+				"/dst.mp4",
+			),
+		},
+		{
+			desc:    "no inventory",
+			request: TranscodeRequest{Source: "/src.mkv", Destination: "/dst.mkv", Metadata: &MetadataOptions{Tags: map[string]string{"title": "A Film"}, Cover_art: "drop"}},
+			expected: append(append([]string{}, common...),
+				"-map", "0",
+				"-c", "copy",
+				"-metadata", "title=A Film",
+				"/dst.mkv",
+			),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			tc.request.Job_type = JobTypeMetadata
+			if diff := cmp.Diff(tc.expected, buildTranscodeArgs(tc.request, tc.streams, codec.ColorInfo{})); diff != "" {
+				t.Errorf("%q: unexpected args: %s", tc.desc, diff)
+			}
+		})
+	}
+}
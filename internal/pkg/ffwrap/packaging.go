@@ -0,0 +1,299 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+)
+
+const (
+	PackagingHls  = "hls"
+	PackagingDash = "dash"
+
+	SegmentFmp4 = "fmp4"
+	SegmentTs   = "ts"
+
+	defaultSegmentSeconds = 6
+
+	// primaryVariant names the variant encoded with the request's own settings.
+	primaryVariant = "main"
+)
+
+// variantNameRegex restricts variant names to ones usable as directory names
+// and in the hls var_stream_map.
+var variantNameRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// segmentType returns the segment container, fMP4 unless TS was requested.
+func (p Packaging) segmentType() string {
+	if strings.EqualFold(p.Segment_type, SegmentTs) {
+		return SegmentTs
+	}
+	return SegmentFmp4
+}
+
+// segmentContainer returns the container the streams of the segments are
+// stored in, dash always writes fMP4 segments.
+func (p Packaging) segmentContainer() string {
+	if strings.EqualFold(p.Format, PackagingDash) || p.segmentType() == SegmentFmp4 {
+		return ContainerMp4
+	}
+	return SegmentTs
+}
+
+// segmentSeconds returns the target segment duration.
+func (p Packaging) segmentSeconds() int {
+	if p.Segment_seconds > 0 {
+		return p.Segment_seconds
+	}
+	return defaultSegmentSeconds
+}
+
+// manifest returns the path of the master playlist or MPD written to dir.
+func (p Packaging) manifest(dir string) string {
+	if strings.EqualFold(p.Format, PackagingDash) {
+		return filepath.Join(dir, "manifest.mpd")
+	}
+	return filepath.Join(dir, "master.m3u8")
+}
+
+// variantNames returns the name of every video variant in the order of renditions.
+func (tr TranscodeRequest) variantNames() []string {
+	names := []string{primaryVariant}
+	for _, r := range tr.Outputs {
+		names = append(names, r.Destination)
+	}
+	return names
+}
+
+// validate checks the packaging options against the request. Every rendition
+// has to be encoded so keyframes can be aligned, and the rendition
+// destinations are used as variant names.
+func (p Packaging) validate(tr TranscodeRequest) error {
+	switch strings.ToLower(p.Format) {
+	case PackagingHls:
+	case PackagingDash:
+		if p.segmentType() == SegmentTs {
+			return fmt.Errorf("dash packaging only supports fmp4 segments")
+		}
+	default:
+		return fmt.Errorf("unsupported packaging format %q", p.Format)
+	}
+	if t := strings.ToLower(p.Segment_type); t != "" && t != SegmentFmp4 && t != SegmentTs {
+		return fmt.Errorf("unsupported segment type %q", p.Segment_type)
+	}
+	if p.Segment_seconds < 0 {
+		return fmt.Errorf("segment_seconds cannot be negative")
+	}
+	for _, o := range tr.renditions() {
+		if strings.EqualFold(o.Codec, "copy") {
+			return fmt.Errorf("packaged renditions cannot copy video")
+		}
+	}
+	for _, r := range tr.Outputs {
+		if !variantNameRegex.MatchString(r.Destination) || r.Destination == primaryVariant {
+			return fmt.Errorf("invalid variant name %q, packaged outputs are named by their destination", r.Destination)
+		}
+	}
+	for _, sf := range tr.Srt_files {
+		if sf.Path == "" {
+			continue
+		}
+		// packaged subtitles are always webvtt
+		if err := sf.validate(ContainerWebm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// streamSpecific rewrites the options built for a single video stream to
+// address the video stream spec, e.g. -crf becomes -crf:v:1. Only option names
+// are rewritten, values such as -1 and flags without a value are kept.
+func streamSpecific(args []string, spec string) []string {
+	out := make([]string, len(args))
+	for i, a := range args {
+		switch {
+		case !isOptionName(a):
+			out[i] = a
+		case strings.HasSuffix(a, ":v"):
+			out[i] = strings.TrimSuffix(a, ":v") + ":" + spec
+		default:
+			out[i] = a + ":" + spec
+		}
+	}
+	return out
+}
+
+// isOptionName reports whether an argument names an ffmpeg option rather than
+// holding a value.
+func isOptionName(a string) bool {
+	return len(a) > 1 && a[0] == '-' && unicode.IsLetter(rune(a[1]))
+}
+
+// buildPackagingArgs generates the options of the single hls or dash output
+// holding every rendition, the selected audio tracks and the text subtitles.
+// Video renditions share an audio group and a subtitle group and keyframes
+// are forced on segment boundaries so that players can switch between them.
+func buildPackagingArgs(tr TranscodeRequest, streams []FfprobeStreams, colorMeta codec.ColorInfo, videoMaps []string) []string {
+	p := *tr.Packaging
+	seg := p.segmentSeconds()
+	outputs := tr.renditions()
+
+	var args, mapargs []string
+	for i, o := range outputs {
+		mapargs = append(mapargs, "-map", videoMaps[i])
+		spec := fmt.Sprintf("v:%d", i)
+		if vf := joinFilters(o.Video_filters, o.toneMapFilter(colorMeta), o.hdr10PlusFilter(colorMeta), o.uploadFilter()); videoMaps[i] == "0:v:0" && vf != "" {
+			args = append(args, "-filter:"+spec, vf)
+		}
+		args = append(args, streamSpecific(o.codecArgs(colorMeta), spec)...)
+		args = append(args, streamSpecific(o.hdrArgs(detectHdr(streams, colorMeta), colorMeta), spec)...)
+		args = append(args, streamSpecific(o.frameRateArgs(streams), spec)...)
+		args = append(args, "-force_key_frames:"+spec, fmt.Sprintf("expr:gte(t,n_forced*%d)", seg))
+	}
+
+	audio, subtitles := selectStreams(streams, tr.Streams)
+	// bitmap subtitles can't be converted to webvtt
+	subtitles = storableSubtitles(subtitles, ContainerWebm)
+	for _, s := range audio {
+		mapargs = append(mapargs, "-map", fmt.Sprintf("0:%d", s.Index))
+	}
+	for _, s := range subtitles {
+		mapargs = append(mapargs, "-map", fmt.Sprintf("0:%d", s.Index))
+	}
+
+	input := 1
+	subLangs := make([]string, 0, len(subtitles))
+	for _, s := range subtitles {
+		subLangs = append(subLangs, streamLanguage(s))
+	}
+	for _, sf := range tr.Srt_files {
+		if sf.Path == "" {
+			continue
+		}
+		mapargs = append(mapargs, sf.outputArgs(input, len(subLangs), ContainerWebm)...)
+		subLangs = append(subLangs, sf.language())
+		input++
+	}
+	audioLangs := make([]string, 0, len(audio))
+	for _, s := range audio {
+		audioLangs = append(audioLangs, streamLanguage(s))
+	}
+	for _, af := range tr.Audio_files {
+		mapargs = append(mapargs, af.outputArgs(input, len(audioLangs), tr.audioFilters())...)
+		audioLangs = append(audioLangs, af.language())
+		input++
+	}
+
+	args = append(args, buildAudioArgs(audio, tr.Audio, tr.audioFilters(), tr.Packaging.segmentContainer())...)
+	if len(subLangs) > 0 {
+		args = append(args, "-c:s", "webvtt")
+	}
+	args = append(args, mapargs...)
+
+	if strings.EqualFold(p.Format, PackagingDash) {
+		return append(args, dashArgs(p, len(outputs), audioLangs, subLangs, tr.Destination)...)
+	}
+	return append(args, hlsArgs(p, tr.variantNames(), audioLangs, subLangs, tr.Destination)...)
+}
+
+// hlsArgs returns the hls muxer options writing every variant to a directory
+// of its own below dir, alongside the master playlist.
+func hlsArgs(p Packaging, variants, audioLangs, subLangs []string, dir string) []string {
+	var groups string
+	if len(audioLangs) > 0 {
+		groups += ",agroup:audio"
+	}
+	if len(subLangs) > 0 {
+		groups += ",sgroup:subs"
+	}
+	var streamMap []string
+	for i, name := range variants {
+		streamMap = append(streamMap, fmt.Sprintf("v:%d%s,name:%s", i, groups, name))
+	}
+	for i, l := range audioLangs {
+		entry := fmt.Sprintf("a:%d,agroup:audio,language:%s,name:audio_%d_%s", i, l, i, l)
+		if i == 0 {
+			entry += ",default:yes"
+		}
+		streamMap = append(streamMap, entry)
+	}
+	for i, l := range subLangs {
+		streamMap = append(streamMap, fmt.Sprintf("s:%d,sgroup:subs,language:%s,name:subs_%d_%s", i, l, i, l))
+	}
+
+	ext := "m4s"
+	segType := "fmp4"
+	if p.segmentType() == SegmentTs {
+		ext, segType = "ts", "mpegts"
+	}
+	args := []string{
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%d", p.segmentSeconds()),
+		"-hls_playlist_type", "vod",
+		"-hls_flags", "independent_segments",
+		"-hls_segment_type", segType,
+	}
+	if segType == "fmp4" {
+		args = append(args, "-hls_fmp4_init_filename", "init.mp4")
+	}
+	return append(args,
+		"-hls_segment_filename", filepath.Join(dir, "%v", "segment_%05d."+ext),
+		"-master_pl_name", filepath.Base(p.manifest(dir)),
+		"-var_stream_map", strings.Join(streamMap, " "),
+		filepath.Join(dir, "%v", "playlist.m3u8"),
+	)
+}
+
+// dashArgs returns the dash muxer options with every video rendition in one
+// adaptation set and an adaptation set per audio and subtitle track.
+func dashArgs(p Packaging, videos int, audioLangs, subLangs []string, dir string) []string {
+	sets := []string{"id=0,streams=v"}
+	index := videos
+	for range audioLangs {
+		sets = append(sets, fmt.Sprintf("id=%d,streams=%d", len(sets), index))
+		index++
+	}
+	for range subLangs {
+		sets = append(sets, fmt.Sprintf("id=%d,streams=%d", len(sets), index))
+		index++
+	}
+	return []string{
+		"-f", "dash",
+		"-seg_duration", fmt.Sprintf("%d", p.segmentSeconds()),
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		"-adaptation_sets", strings.Join(sets, " "),
+		p.manifest(dir),
+	}
+}
+
+// String summarises the packaging for display on the status page.
+func (p *Packaging) String() string {
+	if p == nil {
+		return "none"
+	}
+	if strings.EqualFold(p.Format, PackagingDash) {
+		return fmt.Sprintf("dash %ds segments", p.segmentSeconds())
+	}
+	return fmt.Sprintf("hls %s %ds segments", p.segmentType(), p.segmentSeconds())
+}
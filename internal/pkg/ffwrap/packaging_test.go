@@ -0,0 +1,122 @@
+package ffwrap
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestStreamSpecific(t *testing.T) {
+	got := streamSpecific([]string{"-c:v", "libx265", "-crf", "18", "-profile:v", "main10", "-x265-params", "hdr-opt=1"}, "v:1")
+	want := []string{"-c:v:1", "libx265", "-crf:v:1", "18", "-profile:v:1", "main10", "-x265-params:v:1", "hdr-opt=1"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected stream specific args: %s", diff)
+	}
+	got = streamSpecific([]string{"-c:v", "libx264", "-g", "-1", "-bitexact", "-crf", "20"}, "v:0")
+	want = []string{"-c:v:0", "libx264", "-g:v:0", "-1", "-bitexact:v:0", "-crf:v:0", "20"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected stream specific args with a negative value and a flag: %s", diff)
+	}
+}
+
+func TestBuildPackagingArgs(t *testing.T) {
+	streams := []FfprobeStreams{
+		{Index: 0, Codec: "hevc", Codec_type: "video"},
+		{Index: 1, Codec: "eac3", Codec_type: "audio", Channels: 6, Tags: FfprobeTags{Language: "eng"}},
+		{Index: 2, Codec: "subrip", Codec_type: "subtitle", Tags: FfprobeTags{Language: "eng"}},
+		{Index: 3, Codec: "hdmv_pgs_subtitle", Codec_type: "subtitle", Tags: FfprobeTags{Language: "eng"}},
+	}
+	ladder := TranscodeRequest{
+		Source:      "/src.mkv",
+		Destination: "/www/title",
+		Codec:       "libx265",
+		Crf:         18,
+		Outputs:     []Rendition{{Destination: "720p", Crf: 22, Video_filters: "scale=-2:720"}},
+	}
+	video := slices.Concat(
+		streamSpecific(mustBuildCodec("libx265", 18, codec.ColorInfo{}), "v:0"),
+		[]string{"-force_key_frames:v:0", "expr:gte(t,n_forced*4)"},
+		streamSpecific(mustBuildCodec("libx265", 22, codec.ColorInfo{}), "v:1"),
+		[]string{"-force_key_frames:v:1", "expr:gte(t,n_forced*4)"},
+		[]string{"-c:a:0", "copy", "-c:s", "webvtt", "-map", "[s0]", "-map", "[v1]", "-map", "0:1", "-map", "0:2"},
+	)
+	prefix := []string{
+		"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+		"-i", "/src.mkv",
+		"-filter_complex", "[0:v:0]split=2[s0][s1];[s1]scale=-2:720[v1]",
+	}
+
+	testCases := []struct {
+		desc      string
+		packaging Packaging
+		expected  []string
+	}{
+		{
+			desc:      "hls fmp4",
+			packaging: Packaging{Format: "hls", Segment_seconds: 4},
+			expected: slices.Concat(prefix, video, []string{
+				"-f", "hls", "-hls_time", "4", "-hls_playlist_type", "vod", "-hls_flags", "independent_segments",
+				"-hls_segment_type", "fmp4", "-hls_fmp4_init_filename", "init.mp4",
+				"-hls_segment_filename", "/www/title/%v/segment_%05d.m4s",
+				"-master_pl_name", "master.m3u8",
+				"-var_stream_map", "v:0,agroup:audio,sgroup:subs,name:main v:1,agroup:audio,sgroup:subs,name:720p a:0,agroup:audio,language:eng,name:audio_0_eng,default:yes s:0,sgroup:subs,language:eng,name:subs_0_eng",
+				"/www/title/%v/playlist.m3u8",
+			}),
+		},
+		{
+			desc:      "dash",
+			packaging: Packaging{Format: "dash", Segment_seconds: 4},
+			expected: slices.Concat(prefix, video, []string{
+				"-f", "dash", "-seg_duration", "4", "-use_template", "1", "-use_timeline", "1",
+				"-init_seg_name", "init-$RepresentationID$.m4s", "-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+				"-adaptation_sets", "id=0,streams=v id=1,streams=2 id=2,streams=3",
+				"/www/title/manifest.mpd",
+			}),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			tr := ladder
+			tr.Packaging = &tc.packaging
+			got := buildTranscodeArgs(tr, streams, codec.ColorInfo{})
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("%q: unexpected args: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestPackagingValidate(t *testing.T) {
+	base := TranscodeRequest{Source: "/src.mkv", Destination: "/www/title", Codec: "libx265"}
+	testCases := []struct {
+		desc        string
+		packaging   Packaging
+		outputs     []Rendition
+		srtFiles    []SubtitleFile
+		shouldError bool
+	}{
+		{desc: "hls ts", packaging: Packaging{Format: "hls", Segment_type: "ts"}},
+		{desc: "dash with renditions", packaging: Packaging{Format: "dash"}, outputs: []Rendition{{Destination: "1080p"}}},
+		{desc: "unknown format", packaging: Packaging{Format: "smooth"}, shouldError: true},
+		{desc: "dash with ts", packaging: Packaging{Format: "dash", Segment_type: "ts"}, shouldError: true},
+		{desc: "copied rendition", packaging: Packaging{Format: "hls"}, outputs: []Rendition{{Destination: "remux", Codec: "copy"}}, shouldError: true},
+		{desc: "variant name is a path", packaging: Packaging{Format: "hls"}, outputs: []Rendition{{Destination: "/out/720p.mkv"}}, shouldError: true},
+		{desc: "bitmap subtitle file", packaging: Packaging{Format: "hls"}, srtFiles: []SubtitleFile{{Path: "/subs.sup"}}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			tr := base
+			tr.Outputs = tc.outputs
+			tr.Srt_files = tc.srtFiles
+			tr.Packaging = &tc.packaging
+			err := tr.Validate()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: Validate() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+)
+
+// rendition returns the request for a single rendition with the unset fields
+// taken from tr and the video filters of both joined.
+func (tr TranscodeRequest) rendition(r Rendition) TranscodeRequest {
+	o := tr
+	o.Outputs = nil
+	o.Destination = r.Destination
+	if r.Codec != "" {
+		o.Codec = r.Codec
+	}
+	if r.Crf != 0 {
+		o.Crf = r.Crf
+	}
+	if r.Tonemap != nil {
+		o.Tonemap = r.Tonemap
+	}
+	switch {
+	case tr.Video_filters == "":
+		o.Video_filters = r.Video_filters
+	case r.Video_filters != "":
+		o.Video_filters = tr.Video_filters + "," + r.Video_filters
+	}
+	return o
+}
+
+// renditions returns a request for every output of tr, the request's own
+// destination first.
+func (tr TranscodeRequest) renditions() []TranscodeRequest {
+	primary := tr
+	primary.Outputs = nil
+	outputs := []TranscodeRequest{primary}
+	for _, r := range tr.Outputs {
+		outputs = append(outputs, tr.rendition(r))
+	}
+	return outputs
+}
+
+// Destinations returns the path of the file written for every rendition of the
+// request, in the order of renditions. Packaged renditions are represented by
+// their variant playlist, or by the manifest for dash.
+func (tr TranscodeRequest) Destinations() []string {
+	if tr.Packaging != nil {
+		var d []string
+		for _, name := range tr.variantNames() {
+			if strings.EqualFold(tr.Packaging.Format, PackagingDash) {
+				d = append(d, tr.Packaging.manifest(tr.Destination))
+			} else {
+				d = append(d, filepath.Join(tr.Destination, name, "playlist.m3u8"))
+			}
+		}
+		return d
+	}
+	d := []string{tr.Destination}
+	for _, r := range tr.Outputs {
+		d = append(d, r.Destination)
+	}
+	return d
+}
+
+// buildSplitGraph builds the filter graph that decodes the source video once
+// and fans it out to every encoded output, along with the video map of each
+// output in the order of renditions. No graph is needed when at most one
+// output encodes video, the outputs then read the source video directly.
+// Tone mapping is part of the branch of each output.
+func buildSplitGraph(tr TranscodeRequest, colorMeta codec.ColorInfo) (string, []string) {
+	outputs := tr.renditions()
+	maps := make([]string, len(outputs))
+	var encoded []int
+	for i, o := range outputs {
+		maps[i] = "0:v:0"
+		if strings.ToLower(o.Codec) != "copy" {
+			encoded = append(encoded, i)
+		}
+	}
+	if len(encoded) < 2 {
+		return "", maps
+	}
+
+	split := "[0:v:0]"
+	if tr.Video_filters != "" {
+		split += tr.Video_filters + ","
+	}
+	split += fmt.Sprintf("split=%d", len(encoded))
+	chains := []string{""}
+	for k, i := range encoded {
+		split += fmt.Sprintf("[s%d]", k)
+		var own string
+		if i > 0 {
+			own = tr.Outputs[i-1].Video_filters
+		}
+		own = joinFilters(own, outputs[i].toneMapFilter(colorMeta), outputs[i].hdr10PlusFilter(colorMeta), outputs[i].uploadFilter())
+		if own == "" {
+			maps[i] = fmt.Sprintf("[s%d]", k)
+			continue
+		}
+		maps[i] = fmt.Sprintf("[v%d]", i)
+		chains = append(chains, fmt.Sprintf("[s%d]%s[v%d]", k, own, i))
+	}
+	chains[0] = split
+	return strings.Join(chains, ";"), maps
+}
+
+// validate checks that the rendition writes to a file of its own.
+func (r Rendition) validate(seen map[string]bool) error {
+	if r.Destination == "" {
+		return fmt.Errorf("output destination cannot be empty")
+	}
+	if seen[r.Destination] {
+		return fmt.Errorf("output destination %q is used more than once", r.Destination)
+	}
+	seen[r.Destination] = true
+	return nil
+}
+
+// String summarises the rendition for display on the status page.
+func (r Rendition) String() string {
+	details := []string{}
+	if r.Codec != "" {
+		details = append(details, r.Codec)
+	}
+	if r.Crf != 0 {
+		details = append(details, fmt.Sprintf("crf %d", r.Crf))
+	}
+	if r.Video_filters != "" {
+		details = append(details, r.Video_filters)
+	}
+	if r.Tonemap != nil {
+		details = append(details, r.Tonemap.String())
+	}
+	if len(details) == 0 {
+		return r.Destination
+	}
+	return fmt.Sprintf("%s (%s)", r.Destination, strings.Join(details, ", "))
+}
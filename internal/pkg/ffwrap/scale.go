@@ -0,0 +1,127 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// scaleMultiple is the multiple scaled dimensions are rounded to, chroma
+// subsampled formats require even widths and heights.
+const scaleMultiple = 2
+
+// SampleAspectRatio returns the sample aspect ratio of the stream, 1 when the
+// pixels are square or the ratio is unknown.
+func (s FfprobeStreams) SampleAspectRatio() float64 {
+	if r := parseRational(strings.Replace(s.Sample_aspect_ratio, ":", "/", 1)); r > 0 {
+		return r
+	}
+	return 1
+}
+
+// SampleAspectRatio returns the sample aspect ratio of the first video stream
+// of the inventory, 1 when there is none.
+func (o *FfprobeOutput) SampleAspectRatio() float64 {
+	if o == nil {
+		return 1
+	}
+	for _, s := range o.Streams {
+		if s.Codec_type == "video" && !isAttachedPicture(s) {
+			return s.SampleAspectRatio()
+		}
+	}
+	return 1
+}
+
+// CropSize returns the width and height of the frames output by a crop filter
+// of the form crop=w:h:x:y.
+func CropSize(filter string) (int, int, error) {
+	dims := strings.Split(strings.TrimPrefix(filter, "crop="), ":")
+	if len(dims) < 2 {
+		return 0, 0, fmt.Errorf("splitting crop filter %q for parsing failed", filter)
+	}
+	w, err := strconv.Atoi(dims[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid crop width in %q: %w", filter, err)
+	}
+	h, err := strconv.Atoi(dims[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid crop height in %q: %w", filter, err)
+	}
+	return w, h, nil
+}
+
+// roundScaled rounds a scaled dimension to the nearest multiple of
+// scaleMultiple that does not exceed limit, 0 meaning no limit.
+func roundScaled(v float64, limit int) int {
+	n := int(math.Round(v/scaleMultiple)) * scaleMultiple
+	if limit > 0 && n > limit {
+		n = limit - limit%scaleMultiple
+	}
+	return max(n, scaleMultiple)
+}
+
+// ScaleFilter returns the filter fitting frames of width by height pixels, with
+// the given sample aspect ratio, within maxWidth by maxHeight while preserving
+// the display aspect ratio, or an empty string when they already fit. Limits
+// of 0 are ignored. Non-square pixels are resampled to square ones so the
+// limits apply to the displayed size.
+func ScaleFilter(width, height int, sar float64, maxWidth, maxHeight int) string {
+	if width <= 0 || height <= 0 {
+		return ""
+	}
+	if sar <= 0 {
+		sar = 1
+	}
+	dw, dh := float64(width)*sar, float64(height)
+	factor := 1.0
+	if maxWidth > 0 {
+		factor = min(factor, float64(maxWidth)/dw)
+	}
+	if maxHeight > 0 {
+		factor = min(factor, float64(maxHeight)/dh)
+	}
+	if factor >= 1 {
+		return ""
+	}
+	return fmt.Sprintf("scale=%d:%d:flags=lanczos,setsar=1", roundScaled(dw*factor, maxWidth), roundScaled(dh*factor, maxHeight))
+}
+
+// ResolutionLimit describes the resolution cap of the request for display on
+// the status page, or an empty string when there is none.
+func (tr TranscodeRequest) ResolutionLimit() string {
+	switch {
+	case tr.Max_width > 0 && tr.Max_height > 0:
+		return fmt.Sprintf("%dx%d", tr.Max_width, tr.Max_height)
+	case tr.Max_width > 0:
+		return fmt.Sprintf("%d wide", tr.Max_width)
+	case tr.Max_height > 0:
+		return fmt.Sprintf("%d high", tr.Max_height)
+	}
+	return ""
+}
+
+// validateScale checks the resolution cap of a request.
+func (tr TranscodeRequest) validateScale() error {
+	if tr.Max_width < 0 || tr.Max_height < 0 {
+		return fmt.Errorf("max width and height cannot be negative")
+	}
+	if tr.ResolutionLimit() != "" && strings.EqualFold(tr.Codec, "copy") {
+		return fmt.Errorf("a resolution limit requires scaling and can't be used when copying video")
+	}
+	return nil
+}
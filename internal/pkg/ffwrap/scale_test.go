@@ -0,0 +1,80 @@
+package ffwrap
+
+import (
+	"testing"
+)
+
+func TestScaleFilter(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		width     int
+		height    int
+		sar       float64
+		maxWidth  int
+		maxHeight int
+		expected  string
+	}{
+		{desc: "uhd to 1080p", width: 3840, height: 2160, sar: 1, maxWidth: 1920, maxHeight: 1080, expected: "scale=1920:1080:flags=lanczos,setsar=1"},
+		{desc: "cropped scope uhd", width: 3840, height: 1604, sar: 1, maxWidth: 1920, maxHeight: 1080, expected: "scale=1920:802:flags=lanczos,setsar=1"},
+		{desc: "height only", width: 3840, height: 2160, sar: 1, maxHeight: 720, expected: "scale=1280:720:flags=lanczos,setsar=1"},
+		{desc: "within limits", width: 1920, height: 800, sar: 1, maxWidth: 1920, maxHeight: 1080},
+		{desc: "anamorphic within limits", width: 720, height: 480, sar: 32.0 / 27, maxHeight: 480},
+		{desc: "anamorphic to square pixels", width: 720, height: 480, sar: 32.0 / 27, maxWidth: 640, expected: "scale=640:360:flags=lanczos,setsar=1"},
+		{desc: "no limits", width: 3840, height: 2160, sar: 1},
+		{desc: "unknown size", maxWidth: 1920},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if got := ScaleFilter(tc.width, tc.height, tc.sar, tc.maxWidth, tc.maxHeight); got != tc.expected {
+				t.Errorf("%q: ScaleFilter() = %q, want %q", tc.desc, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestCropSize(t *testing.T) {
+	w, h, err := CropSize("crop=1920:800:0:140")
+	if err != nil || w != 1920 || h != 800 {
+		t.Errorf("CropSize() = %d, %d, %v, want 1920, 800, nil", w, h, err)
+	}
+	if _, _, err := CropSize("crop=1920"); err == nil {
+		t.Errorf("CropSize() expected an error for a malformed filter")
+	}
+}
+
+func TestSampleAspectRatio(t *testing.T) {
+	inv := &FfprobeOutput{Streams: []FfprobeStreams{
+		{Codec_type: "video", Sample_aspect_ratio: "1:1", Disposition: map[string]int{"attached_pic": 1}},
+		{Codec_type: "video", Sample_aspect_ratio: "32:27"},
+	}}
+	if got := inv.SampleAspectRatio(); got != 32.0/27 {
+		t.Errorf("SampleAspectRatio() = %v, want %v", got, 32.0/27)
+	}
+	var missing *FfprobeOutput
+	if got := missing.SampleAspectRatio(); got != 1 {
+		t.Errorf("SampleAspectRatio() of a missing inventory = %v, want 1", got)
+	}
+	if got := (FfprobeStreams{Sample_aspect_ratio: "0:1"}).SampleAspectRatio(); got != 1 {
+		t.Errorf("SampleAspectRatio() of an undefined ratio = %v, want 1", got)
+	}
+}
+
+func TestApplyProfileResolution(t *testing.T) {
+	p := Profile{Max_width: 1920, Max_height: 1080}
+	tr := TranscodeRequest{Codec: "libx265", Max_height: 720}
+	tr.ApplyProfile(p)
+	if tr.Max_width != 0 || tr.Max_height != 720 {
+		t.Errorf("request limits overridden by profile: %s", tr.ResolutionLimit())
+	}
+	tr = TranscodeRequest{Codec: "copy"}
+	tr.ApplyProfile(p)
+	if tr.ResolutionLimit() != "" {
+		t.Errorf("copy inherited resolution limit %s", tr.ResolutionLimit())
+	}
+	tr = TranscodeRequest{}
+	tr.ApplyProfile(p)
+	if tr.ResolutionLimit() != "1920x1080" {
+		t.Errorf("ResolutionLimit() = %q, want %q", tr.ResolutionLimit(), "1920x1080")
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// LanguageOriginal selects the language of the source's original audio track.
+	LanguageOriginal = "original"
+	// LanguageAll selects every stream regardless of language.
+	LanguageAll = "all"
+	// LanguageUndetermined is the ISO 639-2 code for streams without a known language.
+	LanguageUndetermined = "und"
+)
+
+var (
+	languageRegex = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+	// defaultSelection reproduces the historic behaviour of keeping only english audio and subtitles.
+	defaultSelection = StreamSelection{
+		Audio_languages:    []string{"eng"},
+		Subtitle_languages: []string{"eng"},
+	}
+)
+
+// streamLanguage returns the language tag of a stream, treating untagged streams as undetermined.
+func streamLanguage(s FfprobeStreams) string {
+	if s.Tags.Language == "" {
+		return LanguageUndetermined
+	}
+	return strings.ToLower(s.Tags.Language)
+}
+
+// originalLanguage guesses the original language of the source as the
+// language of the first audio track flagged default, or of the first audio
+// track if none is flagged.
+func originalLanguage(streams []FfprobeStreams) string {
+	var first string
+	for _, s := range streams {
+		if s.Codec_type != "audio" {
+			continue
+		}
+		if s.Disposition["default"] == 1 {
+			return streamLanguage(s)
+		}
+		if first == "" {
+			first = streamLanguage(s)
+		}
+	}
+	return first
+}
+
+// resolveLanguage expands the original language token to a language code.
+func resolveLanguage(lang, original string) string {
+	if strings.EqualFold(lang, LanguageOriginal) {
+		return original
+	}
+	return strings.ToLower(lang)
+}
+
+// pickStreams returns the streams of codecType whose language appears in
+// languages, ordered by the position of their language in the list and then by
+// source order. Undetermined streams are used as a fallback when keepUnd is set
+// and no stream matched.
+func pickStreams(streams []FfprobeStreams, codecType string, languages []string, original string, keepUnd bool) []FfprobeStreams {
+	var picked []FfprobeStreams
+	used := make(map[int]bool)
+	for _, l := range languages {
+		l = resolveLanguage(l, original)
+		for _, s := range streams {
+			if s.Codec_type != codecType || used[s.Index] {
+				continue
+			}
+			if l == LanguageAll || l == streamLanguage(s) {
+				picked = append(picked, s)
+				used[s.Index] = true
+			}
+		}
+	}
+	if len(picked) > 0 || !keepUnd {
+		return picked
+	}
+	for _, s := range streams {
+		if s.Codec_type == codecType && streamLanguage(s) == LanguageUndetermined {
+			picked = append(picked, s)
+		}
+	}
+	return picked
+}
+
+// selectStreams returns the audio and subtitle streams of the source to be
+// mapped to the output, in output order.
+func selectStreams(streams []FfprobeStreams, sel *StreamSelection) (audio, subtitles []FfprobeStreams) {
+	if sel == nil {
+		sel = &defaultSelection
+	}
+	original := originalLanguage(streams)
+	audio = pickStreams(streams, "audio", sel.Audio_languages, original, sel.Keep_undetermined)
+	subtitles = pickStreams(streams, "subtitle", sel.Subtitle_languages, original, sel.Keep_undetermined)
+	return audio, subtitles
+}
+
+// isForced reports whether a subtitle stream is flagged or titled as forced.
+func isForced(s FfprobeStreams) bool {
+	return s.Disposition["forced"] == 1 || strings.Contains(strings.ToLower(s.Tags.Title), "forced")
+}
+
+// buildDispositionArgs sets the default and forced dispositions of the mapped
+// streams, the audio files following the audio of the source. The default
+// audio track is the audio file flagged default, otherwise the first track in
+// the requested language or the first mapped track when no language is given
+// or none of the tracks is in it; subtitles are only flagged when a language
+// is requested. Dispositions are left untouched when the request has no
+// stream selection and no default audio file.
+func buildDispositionArgs(audio, subtitles []FfprobeStreams, files []AudioFile, sel *StreamSelection, original string) []string {
+	defaultAudio := -1
+	for i, af := range files {
+		if af.Default {
+			defaultAudio = len(audio) + i
+			break
+		}
+	}
+	if sel == nil && defaultAudio < 0 {
+		return nil
+	}
+	for i, s := range audio {
+		if sel == nil || defaultAudio >= 0 {
+			break
+		}
+		if sel.Default_audio == "" || resolveLanguage(sel.Default_audio, original) == streamLanguage(s) {
+			defaultAudio = i
+		}
+	}
+	if defaultAudio < 0 {
+		defaultAudio = 0
+	}
+	var args []string
+	for i := range len(audio) + len(files) {
+		d := "0"
+		if i == defaultAudio {
+			d = "default"
+		}
+		args = append(args, fmt.Sprintf("-disposition:a:%d", i), d)
+	}
+	if sel == nil {
+		return args
+	}
+
+	defaultSub, forcedSub := -1, -1
+	for i, s := range subtitles {
+		l := streamLanguage(s)
+		if defaultSub < 0 && sel.Default_subtitle != "" && resolveLanguage(sel.Default_subtitle, original) == l && !isForced(s) {
+			defaultSub = i
+		}
+		if forcedSub < 0 && sel.Forced_subtitle != "" && resolveLanguage(sel.Forced_subtitle, original) == l && isForced(s) {
+			forcedSub = i
+		}
+	}
+	for i := range subtitles {
+		var d []string
+		if i == defaultSub {
+			d = append(d, "default")
+		}
+		if i == forcedSub {
+			d = append(d, "forced")
+		}
+		if len(d) == 0 {
+			d = append(d, "0")
+		}
+		args = append(args, fmt.Sprintf("-disposition:s:%d", i), strings.Join(d, "+"))
+	}
+	return args
+}
+
+// languageMaps builds language tag based stream maps for use when no stream
+// inventory of the source is available.
+func languageMaps(streamType string, languages []string) []string {
+	var maps []string
+	for _, l := range languages {
+		switch l = strings.ToLower(l); l {
+		case LanguageAll:
+			maps = append(maps, "-map", fmt.Sprintf("0:%s?", streamType))
+		case LanguageOriginal:
+			// the original language can't be determined without an inventory
+		default:
+			maps = append(maps, "-map", fmt.Sprintf("0:%s:m:language:%s:?", streamType, l))
+		}
+	}
+	return maps
+}
+
+// validate checks that every language in the selection is a ISO 639 code or a
+// supported keyword.
+func (sel StreamSelection) validate() error {
+	check := func(field, l string, allowAll bool) error {
+		l = strings.ToLower(l)
+		if l == LanguageAll {
+			if allowAll {
+				return nil
+			}
+		} else if l == LanguageOriginal || languageRegex.MatchString(l) {
+			return nil
+		}
+		return fmt.Errorf("invalid language %q in %s", l, field)
+	}
+	for _, l := range sel.Audio_languages {
+		if err := check("audio_languages", l, true); err != nil {
+			return err
+		}
+	}
+	for _, l := range sel.Subtitle_languages {
+		if err := check("subtitle_languages", l, true); err != nil {
+			return err
+		}
+	}
+	for field, l := range map[string]string{
+		"default_audio":    sel.Default_audio,
+		"default_subtitle": sel.Default_subtitle,
+		"forced_subtitle":  sel.Forced_subtitle,
+	} {
+		if l == "" {
+			continue
+		}
+		if err := check(field, l, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// String summarises the selection for display on the status page.
+func (sel *StreamSelection) String() string {
+	if sel == nil {
+		sel = &defaultSelection
+	}
+	a := strings.Join(sel.Audio_languages, ",")
+	if a == "" {
+		a = "none"
+	}
+	s := strings.Join(sel.Subtitle_languages, ",")
+	if s == "" {
+		s = "none"
+	}
+	return fmt.Sprintf("audio: %s subtitles: %s", a, s)
+}
@@ -0,0 +1,137 @@
+package ffwrap
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSelectStreams(t *testing.T) {
+	jpnAudio := FfprobeStreams{Index: 1, Codec_type: "audio", Tags: FfprobeTags{Language: "jpn"}, Disposition: map[string]int{"default": 1}}
+	engAudio := FfprobeStreams{Index: 2, Codec_type: "audio", Tags: FfprobeTags{Language: "eng"}}
+	undAudio := FfprobeStreams{Index: 3, Codec_type: "audio"}
+	engSub := FfprobeStreams{Index: 4, Codec_type: "subtitle", Tags: FfprobeTags{Language: "eng"}}
+	undSub := FfprobeStreams{Index: 5, Codec_type: "subtitle", Tags: FfprobeTags{Language: "und"}}
+
+	testCases := []struct {
+		desc          string
+		streams       []FfprobeStreams
+		selection     *StreamSelection
+		wantAudio     []FfprobeStreams
+		wantSubtitles []FfprobeStreams
+	}{
+		{
+			desc:          "default keeps english",
+			streams:       []FfprobeStreams{jpnAudio, engAudio, undAudio, engSub, undSub},
+			wantAudio:     []FfprobeStreams{engAudio},
+			wantSubtitles: []FfprobeStreams{engSub},
+		},
+		{
+			desc:          "original first then english",
+			streams:       []FfprobeStreams{engAudio, jpnAudio, engSub},
+			selection:     &StreamSelection{Audio_languages: []string{"original", "eng"}, Subtitle_languages: []string{"eng"}},
+			wantAudio:     []FfprobeStreams{jpnAudio, engAudio},
+			wantSubtitles: []FfprobeStreams{engSub},
+		},
+		{
+			desc:          "original matching a later entry is not duplicated",
+			streams:       []FfprobeStreams{engAudio, undAudio},
+			selection:     &StreamSelection{Audio_languages: []string{"original", "eng"}},
+			wantAudio:     []FfprobeStreams{engAudio},
+			wantSubtitles: nil,
+		},
+		{
+			desc:          "undetermined kept when nothing matches",
+			streams:       []FfprobeStreams{jpnAudio, undAudio, undSub},
+			selection:     &StreamSelection{Audio_languages: []string{"fre"}, Subtitle_languages: []string{"eng"}, Keep_undetermined: true},
+			wantAudio:     []FfprobeStreams{undAudio},
+			wantSubtitles: []FfprobeStreams{undSub},
+		},
+		{
+			desc:          "undetermined dropped when another stream matched",
+			streams:       []FfprobeStreams{jpnAudio, undAudio},
+			selection:     &StreamSelection{Audio_languages: []string{"jpn"}, Keep_undetermined: true},
+			wantAudio:     []FfprobeStreams{jpnAudio},
+			wantSubtitles: nil,
+		},
+		{
+			desc:          "all",
+			streams:       []FfprobeStreams{jpnAudio, engAudio, undAudio, engSub, undSub},
+			selection:     &StreamSelection{Audio_languages: []string{"all"}, Subtitle_languages: []string{"all"}},
+			wantAudio:     []FfprobeStreams{jpnAudio, engAudio, undAudio},
+			wantSubtitles: []FfprobeStreams{engSub, undSub},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			audio, subtitles := selectStreams(tc.streams, tc.selection)
+			if diff := cmp.Diff(tc.wantAudio, audio); diff != "" {
+				t.Errorf("%q: audio selection diff: %s", tc.desc, diff)
+			}
+			if diff := cmp.Diff(tc.wantSubtitles, subtitles); diff != "" {
+				t.Errorf("%q: subtitle selection diff: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestStreamSelectionValidate(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		selection   StreamSelection
+		shouldError bool
+	}{
+		{desc: "keywords", selection: StreamSelection{Audio_languages: []string{"original", "eng", "all"}, Default_audio: "original"}},
+		{desc: "two letter code", selection: StreamSelection{Subtitle_languages: []string{"en"}}},
+		{desc: "bad language", selection: StreamSelection{Audio_languages: []string{"english"}}, shouldError: true},
+		{desc: "all is not a default", selection: StreamSelection{Default_subtitle: "all"}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.selection.validate()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validate() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
+
+func TestBuildDispositionArgs(t *testing.T) {
+	jpnAudio := FfprobeStreams{Index: 1, Codec_type: "audio", Tags: FfprobeTags{Language: "jpn"}}
+	engAudio := FfprobeStreams{Index: 2, Codec_type: "audio", Tags: FfprobeTags{Language: "eng"}}
+
+	testCases := []struct {
+		desc      string
+		audio     []FfprobeStreams
+		selection *StreamSelection
+		expected  []string
+	}{
+		{
+			desc:      "default language",
+			audio:     []FfprobeStreams{jpnAudio, engAudio},
+			selection: &StreamSelection{Default_audio: "eng"},
+			expected:  []string{"-disposition:a:0", "0", "-disposition:a:1", "default"},
+		},
+		{
+			desc:      "default language not mapped",
+			audio:     []FfprobeStreams{jpnAudio, engAudio},
+			selection: &StreamSelection{Default_audio: "fre"},
+			expected:  []string{"-disposition:a:0", "default", "-disposition:a:1", "0"},
+		},
+		{
+			desc:  "no selection",
+			audio: []FfprobeStreams{jpnAudio, engAudio},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(tc.expected, buildDispositionArgs(tc.audio, nil, nil, tc.selection, "jpn")); diff != "" {
+				t.Errorf("%q: unexpected dispositions: %s", tc.desc, diff)
+			}
+		})
+	}
+}
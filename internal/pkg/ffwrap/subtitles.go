@@ -0,0 +1,130 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// subtitleFormats maps the supported subtitle file extensions to their ffmpeg codec.
+var subtitleFormats = map[string]string{
+	".srt": "subrip",
+	".ass": "ass",
+	".ssa": "ass",
+	".vtt": "webvtt",
+	".sup": "hdmv_pgs_subtitle",
+}
+
+// UnmarshalJSON accepts either a subtitle object or a bare path as submitted
+// by older clients.
+func (sf *SubtitleFile) UnmarshalJSON(b []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte(`"`)) {
+		*sf = SubtitleFile{}
+		return json.Unmarshal(b, &sf.Path)
+	}
+	type plain SubtitleFile
+	return json.Unmarshal(b, (*plain)(sf))
+}
+
+// format returns the ffmpeg codec of the subtitle file based on its extension.
+func (sf SubtitleFile) format() (string, error) {
+	f, ok := subtitleFormats[strings.ToLower(filepath.Ext(sf.Path))]
+	if !ok {
+		return "", fmt.Errorf("unsupported subtitle file %q", sf.Path)
+	}
+	return f, nil
+}
+
+// language returns the language tag of the subtitle file, english when unset.
+func (sf SubtitleFile) language() string {
+	if sf.Language == "" {
+		return "eng"
+	}
+	return strings.ToLower(sf.Language)
+}
+
+// validate checks that the subtitle file is a supported format that the output
+// container can store.
+func (sf SubtitleFile) validate(container string) error {
+	f, err := sf.format()
+	if err != nil {
+		return err
+	}
+	if _, err := subtitleEncoder(f, container); err != nil {
+		return fmt.Errorf("subtitle file %q: %w", sf.Path, err)
+	}
+	if sf.Language != "" && !languageRegex.MatchString(strings.ToLower(sf.Language)) {
+		return fmt.Errorf("invalid language %q for subtitle file %q", sf.Language, sf.Path)
+	}
+	return nil
+}
+
+// inputArgs returns the ffmpeg input options that add the subtitle file.
+func (sf SubtitleFile) inputArgs() []string {
+	var args []string
+	if sf.Offset != 0 {
+		args = append(args, "-itsoffset", strconv.FormatFloat(sf.Offset, 'f', -1, 64))
+	}
+	return append(args, "-i", sf.Path)
+}
+
+// outputArgs returns the map, encoder, metadata and disposition options for
+// the subtitle file read from input number input and written as the
+// output subtitle stream number index.
+func (sf SubtitleFile) outputArgs(input, index int, container string) []string {
+	args := []string{"-map", fmt.Sprintf("%d", input)}
+	if f, err := sf.format(); err == nil {
+		if enc, err := subtitleEncoder(f, container); err == nil && enc != "copy" {
+			args = append(args, fmt.Sprintf("-c:s:%d", index), enc)
+		}
+	}
+	args = append(args, fmt.Sprintf("-metadata:s:s:%d", index), fmt.Sprintf("language=%s", sf.language()))
+	if sf.Title != "" {
+		args = append(args, fmt.Sprintf("-metadata:s:s:%d", index), fmt.Sprintf("title=%s", sf.Title))
+	}
+	var d []string
+	if sf.Default {
+		d = append(d, "default")
+	}
+	if sf.Forced {
+		d = append(d, "forced")
+	}
+	if len(d) > 0 {
+		args = append(args, fmt.Sprintf("-disposition:s:%d", index), strings.Join(d, "+"))
+	}
+	return args
+}
+
+// String summarises the subtitle file for display on the status page.
+func (sf SubtitleFile) String() string {
+	details := []string{sf.language()}
+	if sf.Title != "" {
+		details = append(details, strconv.Quote(sf.Title))
+	}
+	if sf.Default {
+		details = append(details, "default")
+	}
+	if sf.Forced {
+		details = append(details, "forced")
+	}
+	if sf.Offset != 0 {
+		details = append(details, fmt.Sprintf("offset %+gs", sf.Offset))
+	}
+	return fmt.Sprintf("%s (%s)", sf.Path, strings.Join(details, ", "))
+}
@@ -0,0 +1,76 @@
+package ffwrap
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSubtitleFileUnmarshalJSON(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		input       string
+		expected    []SubtitleFile
+		shouldError bool
+	}{
+		{
+			desc:     "bare paths",
+			input:    `["/a.srt", "/b.srt"]`,
+			expected: []SubtitleFile{{Path: "/a.srt"}, {Path: "/b.srt"}},
+		},
+		{
+			desc:     "objects and paths",
+			input:    `[{"path":"/a.ass","language":"jpn","title":"Signs","forced":true,"offset":0.25}, "/b.srt"]`,
+			expected: []SubtitleFile{{Path: "/a.ass", Language: "jpn", Title: "Signs", Forced: true, Offset: 0.25}, {Path: "/b.srt"}},
+		},
+		{
+			desc:        "wrong type",
+			input:       `[3]`,
+			shouldError: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			var result []SubtitleFile
+			err := json.Unmarshal([]byte(tc.input), &result)
+			if (err != nil) != tc.shouldError {
+				t.Fatalf("%q: Unmarshal() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+			if tc.shouldError {
+				return
+			}
+			if diff := cmp.Diff(tc.expected, result); diff != "" {
+				t.Errorf("%q: unexpected subtitle files: %s", tc.desc, diff)
+			}
+		})
+	}
+}
+
+func TestSubtitleFileValidate(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		file        SubtitleFile
+		container   string
+		shouldError bool
+	}{
+		{desc: "srt in mkv", file: SubtitleFile{Path: "/a.srt"}, container: ContainerMkv},
+		{desc: "sup in mkv", file: SubtitleFile{Path: "/a.SUP"}, container: ContainerMkv},
+		{desc: "ass in mp4", file: SubtitleFile{Path: "/a.ass"}, container: ContainerMp4},
+		{desc: "srt in webm", file: SubtitleFile{Path: "/a.srt"}, container: ContainerWebm},
+		{desc: "sup in mp4", file: SubtitleFile{Path: "/a.sup"}, container: ContainerMp4, shouldError: true},
+		{desc: "sup in webm", file: SubtitleFile{Path: "/a.sup"}, container: ContainerWebm, shouldError: true},
+		{desc: "unknown extension", file: SubtitleFile{Path: "/a.idx"}, container: ContainerMkv, shouldError: true},
+		{desc: "bad language", file: SubtitleFile{Path: "/a.srt", Language: "german"}, container: ContainerMkv, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.file.validate(tc.container)
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validate() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+)
+
+const (
+	defaultToneMapAlgorithm = "hable"
+	// toneMapNominalPeak is the luminance in cd/m² of the linearised signal's
+	// reference white, peaks are given to the tonemap filter relative to it.
+	toneMapNominalPeak = 100
+)
+
+// toneMapAlgorithms are the algorithms of ffmpeg's tonemap filter.
+var toneMapAlgorithms = []string{"none", "clip", "linear", "gamma", "reinhard", "hable", "mobius"}
+
+// sdrColor is the color description of tone mapped outputs. It carries no
+// mastering display or content light level side data.
+var sdrColor = codec.ColorInfo{Color_space: "bt709", Color_primaries: "bt709", Color_transfer: "bt709"}
+
+// isHDR reports whether the color description is of a PQ or HLG source.
+func isHDR(colorMeta codec.ColorInfo) bool {
+	switch strings.ToLower(colorMeta.Color_transfer) {
+	case "smpte2084", "arib-std-b67":
+		return true
+	}
+	return false
+}
+
+func (t ToneMapping) algorithm() string {
+	if t.Algorithm == "" {
+		return defaultToneMapAlgorithm
+	}
+	return strings.ToLower(t.Algorithm)
+}
+
+// filter returns the chain linearising the signal, converting it to bt709
+// primaries, tone mapping it and encoding it with the bt709 transfer.
+func (t ToneMapping) filter() string {
+	tm := "tonemap=tonemap=" + t.algorithm() + ":desat=0"
+	if t.Peak > 0 {
+		tm += ":peak=" + formatSeconds(t.Peak/toneMapNominalPeak)
+	}
+	return strings.Join([]string{
+		fmt.Sprintf("zscale=t=linear:npl=%d", toneMapNominalPeak),
+		"format=gbrpf32le",
+		"zscale=p=bt709",
+		tm,
+		"zscale=t=bt709:m=bt709:r=tv",
+		"format=yuv420p10le",
+	}, ",")
+}
+
+// validate checks the algorithm and peak of the tone mapping.
+func (t ToneMapping) validate() error {
+	if !slices.Contains(toneMapAlgorithms, t.algorithm()) {
+		return fmt.Errorf("unsupported tone mapping algorithm %q", t.Algorithm)
+	}
+	if t.Peak < 0 {
+		return fmt.Errorf("tone mapping peak cannot be negative")
+	}
+	return nil
+}
+
+// toneMapFilter returns the tone mapping filters of an output, or an empty
+// string when the output keeps the dynamic range of the source.
+func (tr TranscodeRequest) toneMapFilter(colorMeta codec.ColorInfo) string {
+	if tr.Tonemap == nil || !isHDR(colorMeta) {
+		return ""
+	}
+	return tr.Tonemap.filter()
+}
+
+// outputColor returns the color description the encoder of an output is
+// configured with, bt709 without HDR metadata when the output is tone mapped.
+func (tr TranscodeRequest) outputColor(colorMeta codec.ColorInfo) codec.ColorInfo {
+	if tr.toneMapFilter(colorMeta) == "" {
+		return colorMeta
+	}
+	return sdrColor
+}
+
+// joinFilters joins the non empty filter chains.
+func joinFilters(chains ...string) string {
+	var f []string
+	for _, c := range chains {
+		if c != "" {
+			f = append(f, c)
+		}
+	}
+	return strings.Join(f, ",")
+}
+
+// validateToneMapping checks the tone mapping of every output.
+func (tr TranscodeRequest) validateToneMapping() error {
+	for _, o := range tr.renditions() {
+		if o.Tonemap == nil {
+			continue
+		}
+		if err := o.Tonemap.validate(); err != nil {
+			return err
+		}
+		if strings.EqualFold(o.Codec, "copy") {
+			return fmt.Errorf("tone mapping %q requires encoding and can't be used when copying video", o.Destination)
+		}
+	}
+	return nil
+}
+
+// String summarises the tone mapping for display on the status page.
+func (t *ToneMapping) String() string {
+	if t == nil {
+		return ""
+	}
+	s := "sdr, " + t.algorithm()
+	if t.Peak > 0 {
+		s += fmt.Sprintf(" from %s nits", formatSeconds(t.Peak))
+	}
+	return s
+}
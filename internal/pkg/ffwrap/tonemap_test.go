@@ -0,0 +1,112 @@
+package ffwrap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
+	"github.com/google/go-cmp/cmp"
+)
+
+var hdr10Color = codec.ColorInfo{
+	Color_space:     "bt2020nc",
+	Color_primaries: "bt2020",
+	Color_transfer:  "smpte2084",
+	Side_data_list: []codec.ColorSideInfo{
+		{Side_data_type: codec.SideDataTypeLightLevel, Max_content: 1000, Max_average: 400},
+	},
+}
+
+func TestToneMapFilter(t *testing.T) {
+	testCases := []struct {
+		desc      string
+		tonemap   *ToneMapping
+		colorMeta codec.ColorInfo
+		expected  string
+	}{
+		{desc: "no tone mapping", colorMeta: hdr10Color},
+		{desc: "sdr source", tonemap: &ToneMapping{}, colorMeta: codec.ColorInfo{Color_transfer: "bt709"}},
+		{
+			desc:      "default algorithm",
+			tonemap:   &ToneMapping{},
+			colorMeta: hdr10Color,
+			expected:  "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p10le",
+		},
+		{
+			desc:      "hlg with peak",
+			tonemap:   &ToneMapping{Algorithm: "Mobius", Peak: 1000},
+			colorMeta: codec.ColorInfo{Color_transfer: "arib-std-b67"},
+			expected:  "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709,tonemap=tonemap=mobius:desat=0:peak=10,zscale=t=bt709:m=bt709:r=tv,format=yuv420p10le",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			tr := TranscodeRequest{Tonemap: tc.tonemap}
+			if got := tr.toneMapFilter(tc.colorMeta); got != tc.expected {
+				t.Errorf("%q: toneMapFilter() = %q, want %q", tc.desc, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestBuildToneMappedArgs(t *testing.T) {
+	streams := []FfprobeStreams{{Index: 0, Codec: "hevc", Codec_type: "video"}, ac3Track}
+	tr := TranscodeRequest{
+		Source:      "/src.mkv",
+		Destination: "/2160p.mkv",
+		Codec:       "libx265",
+		Crf:         18,
+		Outputs:     []Rendition{{Destination: "/1080p.mkv", Video_filters: "scale=-2:1080", Tonemap: &ToneMapping{}}},
+	}
+	tonemap := ToneMapping{}.filter()
+	expected := slices.Concat(
+		[]string{
+			"-y", "-hide_banner", "-stats", "-loglevel", "error", "-probesize", "6000M", "-analyzeduration", "6000M",
+			"-i", "/src.mkv",
+			"-filter_complex", "[0:v:0]split=2[s0][s1];[s1]scale=-2:1080," + tonemap + "[v1]",
+		},
+		mustBuildCodec("libx265", 18, hdr10Color),
+		[]string{"-c:a:0", "copy", "-c:s", "copy", "-c:t", "copy", "-map", "[s0]", "-map", "0:3", "-map", "0:t:?", "/2160p.mkv"},
+		mustBuildCodec("libx265", 18, sdrColor),
+		[]string{"-c:a:0", "copy", "-c:s", "copy", "-c:t", "copy", "-map", "[v1]", "-map", "0:3", "-map", "0:t:?", "/1080p.mkv"},
+	)
+	if diff := cmp.Diff(expected, buildTranscodeArgs(tr, streams, hdr10Color)); diff != "" {
+		t.Errorf("unexpected tone mapped args: %s", diff)
+	}
+	for _, arg := range mustBuildCodec("libx265", 18, sdrColor) {
+		if strings.Contains(arg, "master-display") || strings.Contains(arg, "content-light") {
+			t.Errorf("tone mapped output carries hdr metadata: %v", arg)
+		}
+	}
+
+	tr.Outputs = nil
+	tr.Tonemap = &ToneMapping{Algorithm: "reinhard"}
+	single := buildTranscodeArgs(tr, streams, hdr10Color)
+	if !slices.Contains(single, tr.Tonemap.filter()) {
+		t.Errorf("single output args %v don't apply the tone mapping", single)
+	}
+}
+
+func TestValidateToneMapping(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		shouldError bool
+	}{
+		{desc: "rendition", request: TranscodeRequest{Codec: "libx265", Outputs: []Rendition{{Destination: "/sdr.mkv", Tonemap: &ToneMapping{Algorithm: "hable", Peak: 1000}}}}},
+		{desc: "unknown algorithm", request: TranscodeRequest{Codec: "libx265", Tonemap: &ToneMapping{Algorithm: "aces"}}, shouldError: true},
+		{desc: "negative peak", request: TranscodeRequest{Codec: "libx265", Tonemap: &ToneMapping{Peak: -1}}, shouldError: true},
+		{desc: "copied rendition", request: TranscodeRequest{Codec: "libx265", Outputs: []Rendition{{Destination: "/sdr.mkv", Codec: "copy", Tonemap: &ToneMapping{}}}}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.request.validateToneMapping()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validateToneMapping() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
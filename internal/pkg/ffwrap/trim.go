@@ -0,0 +1,117 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// trimmed reports whether only part of the source is transcoded.
+func (tr TranscodeRequest) trimmed() bool {
+	return tr.Start > 0 || tr.End > 0 || tr.Duration > 0
+}
+
+// trimLength returns the requested length of the output in seconds, or 0 when
+// the output runs to the end of the source.
+func (tr TranscodeRequest) trimLength() float64 {
+	switch {
+	case tr.Duration > 0:
+		return tr.Duration
+	case tr.End > 0:
+		return tr.End - tr.Start
+	}
+	return 0
+}
+
+// validateTrim checks that the trim describes a non empty part of the source.
+func (tr TranscodeRequest) validateTrim() error {
+	if tr.Start < 0 || tr.End < 0 || tr.Duration < 0 {
+		return fmt.Errorf("start, end and duration cannot be negative")
+	}
+	if tr.End > 0 && tr.Duration > 0 {
+		return fmt.Errorf("only one of end and duration can be set")
+	}
+	if tr.End > 0 && tr.End <= tr.Start {
+		return fmt.Errorf("end %v must be after start %v", tr.End, tr.Start)
+	}
+	return nil
+}
+
+// trimInputArgs returns the input options seeking to the start of the trim
+// and limiting the length read. They are given for the source and every
+// external file so that subtitles and audio tracks stay aligned. Seeking on the
+// input is frame accurate when encoding and snaps to the preceding keyframe
+// when copying.
+func (tr TranscodeRequest) trimInputArgs() []string {
+	var args []string
+	if tr.Start > 0 {
+		args = append(args, "-ss", formatSeconds(tr.Start))
+	}
+	if l := tr.trimLength(); l > 0 {
+		args = append(args, "-t", formatSeconds(l))
+	}
+	return args
+}
+
+// trimOutputArgs returns the output options of a trimmed output. Copied
+// streams start at the keyframe before the start and are shifted to begin at
+// zero.
+func (tr TranscodeRequest) trimOutputArgs() []string {
+	if tr.Start > 0 && strings.EqualFold(tr.Codec, "copy") {
+		return []string{"-avoid_negative_ts", "make_zero"}
+	}
+	return nil
+}
+
+// OutputDuration returns the length in seconds of the output transcoded from a
+// source of the given duration.
+func (tr TranscodeRequest) OutputDuration(source float64) float64 {
+	remaining := max(source-tr.Start, 0)
+	if l := tr.trimLength(); l > 0 {
+		return min(l, remaining)
+	}
+	return remaining
+}
+
+// Trim describes the part of the source that is transcoded for display on the
+// status page, or an empty string when the whole source is.
+func (tr TranscodeRequest) Trim() string {
+	if !tr.trimmed() {
+		return ""
+	}
+	s := "from " + formatSexagesimal(formatSeconds(tr.Start))
+	switch {
+	case tr.Duration > 0:
+		s += fmt.Sprintf(" for %ss", formatSeconds(tr.Duration))
+	case tr.End > 0:
+		s += " to " + formatSexagesimal(formatSeconds(tr.End))
+	}
+	return s
+}
+
+// ParseDuration parses a duration given in seconds or in the sexagesimal form
+// reported by ffprobe, e.g. 0:42:00.500000.
+func ParseDuration(d string) (float64, error) {
+	var total float64
+	for _, part := range strings.Split(strings.TrimSpace(d), ":") {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q: %w", d, err)
+		}
+		total = total*60 + v
+	}
+	return total, nil
+}
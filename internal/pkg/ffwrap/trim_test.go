@@ -0,0 +1,106 @@
+package ffwrap
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestValidateTrim(t *testing.T) {
+	testCases := []struct {
+		desc        string
+		request     TranscodeRequest
+		shouldError bool
+	}{
+		{desc: "untrimmed", request: TranscodeRequest{}},
+		{desc: "start only", request: TranscodeRequest{Start: 30}},
+		{desc: "start and end", request: TranscodeRequest{Start: 30, End: 90}},
+		{desc: "duration only", request: TranscodeRequest{Duration: 60}},
+		{desc: "negative start", request: TranscodeRequest{Start: -1}, shouldError: true},
+		{desc: "end and duration", request: TranscodeRequest{End: 90, Duration: 60}, shouldError: true},
+		{desc: "end before start", request: TranscodeRequest{Start: 90, End: 30}, shouldError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			err := tc.request.validateTrim()
+			if (err != nil) != tc.shouldError {
+				t.Errorf("%q: validateTrim() err = %v, shouldError %v", tc.desc, err, tc.shouldError)
+			}
+		})
+	}
+}
+
+func TestTrim(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		request  TranscodeRequest
+		input    []string
+		output   []string
+		duration float64
+		display  string
+	}{
+		{desc: "untrimmed", request: TranscodeRequest{Codec: "copy"}, duration: 600},
+		{
+			desc:     "intro removed",
+			request:  TranscodeRequest{Codec: "libx265", Start: 95.5},
+			input:    []string{"-ss", "95.5"},
+			duration: 504.5,
+			display:  "from 0:01:35.500000",
+		},
+		{
+			desc:     "sample copied",
+			request:  TranscodeRequest{Codec: "copy", Start: 60, Duration: 30},
+			input:    []string{"-ss", "60", "-t", "30"},
+			output:   []string{"-avoid_negative_ts", "make_zero"},
+			duration: 30,
+			display:  "from 0:01:00.000000 for 30s",
+		},
+		{
+			desc:     "end past the source",
+			request:  TranscodeRequest{Codec: "libx265", End: 900},
+			input:    []string{"-t", "900"},
+			duration: 600,
+			display:  "from 0:00:00.000000 to 0:15:00.000000",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			t.Parallel()
+			if diff := cmp.Diff(tc.input, tc.request.trimInputArgs()); diff != "" {
+				t.Errorf("%q: unexpected input args: %s", tc.desc, diff)
+			}
+			if diff := cmp.Diff(tc.output, tc.request.trimOutputArgs()); diff != "" {
+				t.Errorf("%q: unexpected output args: %s", tc.desc, diff)
+			}
+			if got := tc.request.OutputDuration(600); got != tc.duration {
+				t.Errorf("%q: OutputDuration(600) = %v, want %v", tc.desc, got, tc.duration)
+			}
+			if got := tc.request.Trim(); got != tc.display {
+				t.Errorf("%q: Trim() = %q, want %q", tc.desc, got, tc.display)
+			}
+		})
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	testCases := []struct {
+		in          string
+		expected    float64
+		shouldError bool
+	}{
+		{in: "42.5", expected: 42.5},
+		{in: "0:42:00.500000", expected: 2520.5},
+		{in: "01:00:00", expected: 3600},
+		{in: "unknown", shouldError: true},
+	}
+	for _, tc := range testCases {
+		got, err := ParseDuration(tc.in)
+		if (err != nil) != tc.shouldError {
+			t.Errorf("ParseDuration(%q) err = %v, shouldError %v", tc.in, err, tc.shouldError)
+		}
+		if got != tc.expected {
+			t.Errorf("ParseDuration(%q) = %v, want %v", tc.in, got, tc.expected)
+		}
+	}
+}
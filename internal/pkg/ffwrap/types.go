@@ -3,38 +3,300 @@ package ffwrap
 import libCodec "github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
 
 type MediaMetadata struct {
-	Duration string
-	Codec    string
-	Width    int
-	Height   int
+	Duration   string
+	Codec      string
+	Width      int
+	Height     int
+	Hdr_format string
+	Frame_rate string
+	Vfr        bool
 }
 
+// FfprobeOutput is the inventory of a source file as reported by ffprobe.
 type FfprobeOutput struct {
-	Streams []FfprobeStreams
-	Format  FfprobeFormat
+	Streams  []FfprobeStreams `json:"streams"`
+	Format   FfprobeFormat    `json:"format"`
+	Chapters []FfprobeChapter `json:"chapters,omitempty"`
+	// Light_level is the content light level measured by MeasureLightLevel,
+	// ffprobe doesn't report it.
+	Light_level *libCodec.ColorSideInfo `json:"light_level,omitempty"`
 }
 
 type FfprobeStreams struct {
-	Codec      string `json:"codec_name"`
-	Codec_type string `json:"codec_type"`
-	Width      int    `json:"width"`
-	Height     int    `json:"height"`
+	Index                int               `json:"index"`
+	Codec                string            `json:"codec_name"`
+	Codec_type           string            `json:"codec_type"`
+	Profile              string            `json:"profile"`
+	Width                int               `json:"width"`
+	Height               int               `json:"height"`
+	Channels             int               `json:"channels"`
+	Channel_layout       string            `json:"channel_layout,omitempty"`
+	Sample_rate          string            `json:"sample_rate,omitempty"`
+	Pix_fmt              string            `json:"pix_fmt,omitempty"`
+	Bits_per_raw_sample  string            `json:"bits_per_raw_sample,omitempty"`
+	Bits_per_sample      int               `json:"bits_per_sample,omitempty"`
+	R_frame_rate         string            `json:"r_frame_rate,omitempty"`
+	Avg_frame_rate       string            `json:"avg_frame_rate,omitempty"`
+	Sample_aspect_ratio  string            `json:"sample_aspect_ratio,omitempty"`
+	Display_aspect_ratio string            `json:"display_aspect_ratio,omitempty"`
+	Bit_rate             string            `json:"bit_rate,omitempty"`
+	Color_space          string            `json:"color_space,omitempty"`
+	Color_primaries      string            `json:"color_primaries,omitempty"`
+	Color_transfer       string            `json:"color_transfer,omitempty"`
+	Tags                 FfprobeTags       `json:"tags"`
+	Disposition          map[string]int    `json:"disposition"`
+	Side_data_list       []FfprobeSideData `json:"side_data_list,omitempty"`
+}
+
+// FfprobeSideData is stream side data, such as the Dolby Vision configuration
+// record of a video stream.
+type FfprobeSideData struct {
+	Side_data_type                string `json:"side_data_type"`
+	Dv_profile                    int    `json:"dv_profile,omitempty"`
+	Dv_level                      int    `json:"dv_level,omitempty"`
+	Rpu_present_flag              int    `json:"rpu_present_flag,omitempty"`
+	El_present_flag               int    `json:"el_present_flag,omitempty"`
+	Bl_present_flag               int    `json:"bl_present_flag,omitempty"`
+	Dv_bl_signal_compatibility_id int    `json:"dv_bl_signal_compatibility_id,omitempty"`
+}
+
+type FfprobeTags struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
 }
 
 type FfprobeFormat struct {
-	Duration string `json:"duration"`
+	Format_name string            `json:"format_name,omitempty"`
+	Duration    string            `json:"duration"`
+	Bit_rate    string            `json:"bit_rate,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
 }
 
+type FfprobeChapter struct {
+	Id         int64             `json:"id"`
+	Start_time string            `json:"start_time"`
+	End_time   string            `json:"end_time"`
+	Tags       map[string]string `json:"tags,omitempty"`
+}
+
+// TranscodeRequest describes a job. Start, End and Duration are in seconds and
+// trim the source, only one of End and Duration may be set. Sources lists the
+// files joined by a concat job, Source is then the first of them. Scan_type
+// is auto to classify the source with idet before transcoding, or the scan type
+// to assume; Scan_analysis holds the counts the classification was based on.
+// Max_width and Max_height cap the displayed resolution of the output, the
+// cropped source is scaled down to fit when it exceeds either. Hdr_policy
+// decides what happens to Dolby Vision and HDR10+ metadata: preserve, hdr10 or
+// refuse. Autocrop crops are aligned to Crop_alignment, 2 or 16, and applied
+// when at least Crop_confidence of the sampled frames agree with them; below
+// it Crop_fallback encodes the source uncropped or holds the job for review.
+// Crop_analysis holds the candidates the crop was selected from. Aspect_policy
+// decides how sources whose aspect ratio changes between scenes are cropped:
+// largest, dominant or flag to hold them for review. Review_crop holds every
+// autocropped job for an operator to approve, adjust or disable its crop.
+// Frame_rate_mode is cfr to write a constant frame rate, Frame_rate or the
+// rate of the source, or vfr to keep the timestamps of the source; unset it
+// leaves the choice to the muxer.
 type TranscodeRequest struct {
-	Source         string   `json:"source"`
-	Destination    string   `json:"destination"`
-	Srt_files      []string `json:"srt_files"`
-	Crf            int      `json:"crf"`
-	Autocrop       bool     `json:"autocrop"`
-	Video_filters  string   `json:"video_filters"`
-	Audio_filters  string   `json:"audio_filters"`
-	Codec          string   `json:"codec"`
-	LogDestination string
+	Source          string           `json:"source"`
+	Sources         []string         `json:"sources,omitempty"`
+	Destination     string           `json:"destination"`
+	Srt_files       []SubtitleFile   `json:"srt_files"`
+	Audio_files     []AudioFile      `json:"audio_files,omitempty"`
+	Crf             int              `json:"crf"`
+	Autocrop        bool             `json:"autocrop"`
+	Video_filters   string           `json:"video_filters"`
+	Audio_filters   string           `json:"audio_filters"`
+	Audio           *AudioSettings   `json:"audio,omitempty"`
+	Codec           string           `json:"codec"`
+	Start           float64          `json:"start,omitempty"`
+	End             float64          `json:"end,omitempty"`
+	Duration        float64          `json:"duration,omitempty"`
+	Streams         *StreamSelection `json:"stream_selection,omitempty"`
+	Profile         string           `json:"profile,omitempty"`
+	Outputs         []Rendition      `json:"outputs,omitempty"`
+	Packaging       *Packaging       `json:"packaging,omitempty"`
+	Container       string           `json:"container,omitempty"`
+	Metadata        *MetadataOptions `json:"metadata,omitempty"`
+	Job_type        string           `json:"job_type,omitempty"`
+	Artifacts       *Artifacts       `json:"artifacts,omitempty"`
+	Scan_type       string           `json:"scan_type,omitempty"`
+	Deinterlacer    string           `json:"deinterlacer,omitempty"`
+	Scan_analysis   *ScanAnalysis    `json:"scan_analysis,omitempty"`
+	Max_width       int              `json:"max_width,omitempty"`
+	Max_height      int              `json:"max_height,omitempty"`
+	Tonemap         *ToneMapping     `json:"tonemap,omitempty"`
+	Hdr_policy      string           `json:"hdr_policy,omitempty"`
+	Crop_alignment  int              `json:"crop_alignment,omitempty"`
+	Crop_confidence float64          `json:"crop_confidence,omitempty"`
+	Crop_fallback   string           `json:"crop_fallback,omitempty"`
+	Crop_analysis   *CropAnalysis    `json:"crop_analysis,omitempty"`
+	Aspect_policy   string           `json:"aspect_policy,omitempty"`
+	Review_crop     bool             `json:"review_crop,omitempty"`
+	Frame_rate_mode string           `json:"frame_rate_mode,omitempty"`
+	Frame_rate      string           `json:"frame_rate,omitempty"`
+	LogDestination  string
+}
+
+// Rendition is an additional output encoded from the same decode of the source
+// as the request's destination. Unset fields inherit the value of the request.
+// The request's video filters, including the autocrop filter, apply to every
+// rendition before its own Video_filters.
+type Rendition struct {
+	Destination   string       `json:"destination"`
+	Codec         string       `json:"codec,omitempty"`
+	Crf           int          `json:"crf,omitempty"`
+	Video_filters string       `json:"video_filters,omitempty"`
+	Tonemap       *ToneMapping `json:"tonemap,omitempty"`
+}
+
+// ToneMapping converts an output of an HDR source to SDR with bt709 color.
+// Algorithm is one of the algorithms of ffmpeg's tonemap filter and defaults
+// to hable, Peak overrides the peak luminance of the source in cd/m². Outputs
+// of SDR sources are left unchanged.
+type ToneMapping struct {
+	Algorithm string  `json:"algorithm,omitempty"`
+	Peak      float64 `json:"peak,omitempty"`
+}
+
+// Packaging writes the request as an adaptive streaming presentation instead
+// of a single file. Destination is then a directory and the destination of
+// each rendition names its variant. Format is hls or dash, hls segments are
+// fmp4 unless Segment_type is ts.
+type Packaging struct {
+	Format          string `json:"format"`
+	Segment_type    string `json:"segment_type,omitempty"`
+	Segment_seconds int    `json:"segment_seconds,omitempty"`
+}
+
+// MetadataOptions controls the global tags, chapters and cover art of the
+// output. Tags are set on the output, a tag with an empty value is removed and
+// Clear_tags drops every global tag of the source first. Chapters are copied
+// unless dropped or imported from an ffmetadata Chapters_file. Cover art is
+// kept unless dropped or replaced by Cover_image, a jpeg or png file.
+type MetadataOptions struct {
+	Tags          map[string]string `json:"tags,omitempty"`
+	Clear_tags    bool              `json:"clear_tags,omitempty"`
+	Chapters      string            `json:"chapters,omitempty"`
+	Chapters_file string            `json:"chapters_file,omitempty"`
+	Cover_art     string            `json:"cover_art,omitempty"`
+	Cover_image   string            `json:"cover_image,omitempty"`
+}
+
+// Artifacts are images and clips generated from the destination once it has
+// been written. They are stored in Directory, which defaults to a directory
+// named after the destination with an _artifacts suffix.
+type Artifacts struct {
+	Directory  string            `json:"directory,omitempty"`
+	Thumbnails *ThumbnailOptions `json:"thumbnails,omitempty"`
+	Sprite     *SpriteOptions    `json:"sprite,omitempty"`
+	Preview    *PreviewOptions   `json:"preview,omitempty"`
+}
+
+// ThumbnailOptions extracts a still at each of the Timestamps, in seconds, and
+// Scene_count stills picked at scene changes. Width defaults to the width of
+// the video.
+type ThumbnailOptions struct {
+	Timestamps  []float64 `json:"timestamps,omitempty"`
+	Scene_count int       `json:"scene_count,omitempty"`
+	Width       int       `json:"width,omitempty"`
+}
+
+// SpriteOptions tiles a frame every Interval seconds into sheets of Columns by
+// Rows tiles, each Width pixels wide, and indexes them with a WebVTT file for
+// scrub bar previews.
+type SpriteOptions struct {
+	Interval float64 `json:"interval,omitempty"`
+	Columns  int     `json:"columns,omitempty"`
+	Rows     int     `json:"rows,omitempty"`
+	Width    int     `json:"width,omitempty"`
+}
+
+// PreviewOptions cuts an animated preview of Duration seconds starting at
+// Start. Format is gif, webp or mp4.
+type PreviewOptions struct {
+	Start    float64 `json:"start,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	Width    int     `json:"width,omitempty"`
+	Format   string  `json:"format,omitempty"`
+}
+
+// Artifact is a file generated from the destination of a job.
+type Artifact struct {
+	Kind      string  `json:"kind"`
+	Path      string  `json:"path"`
+	Timestamp float64 `json:"timestamp,omitempty"`
+}
+
+// SubtitleFile is an external subtitle file muxed into the output. Offset is
+// in seconds and delays the subtitles when positive. The format is taken from
+// the file extension, srt, ass, ssa, vtt and sup files are supported.
+type SubtitleFile struct {
+	Path     string  `json:"path"`
+	Language string  `json:"language,omitempty"`
+	Title    string  `json:"title,omitempty"`
+	Default  bool    `json:"default,omitempty"`
+	Forced   bool    `json:"forced,omitempty"`
+	Offset   float64 `json:"offset,omitempty"`
+}
+
+// AudioFile is an external audio track, such as a commentary or a dub, muxed
+// into the output after the source's audio. Delay is in seconds and delays the
+// track when positive. Codec accepts the same values as AudioSettings and
+// defaults to copy.
+type AudioFile struct {
+	Path     string  `json:"path"`
+	Language string  `json:"language,omitempty"`
+	Title    string  `json:"title,omitempty"`
+	Delay    float64 `json:"delay,omitempty"`
+	Codec    string  `json:"codec,omitempty"`
+	Bitrate  string  `json:"bitrate,omitempty"`
+	Default  bool    `json:"default,omitempty"`
+}
+
+// AudioSettings describes how the audio tracks of a job are encoded. Tracks
+// matching one of the Rules use that rule's settings, all other tracks use the
+// top level Codec, Bitrate and Channel_layout.
+type AudioSettings struct {
+	Codec          string      `json:"codec" yaml:"codec"`
+	Bitrate        string      `json:"bitrate,omitempty" yaml:"bitrate,omitempty"`
+	Channel_layout string      `json:"channel_layout,omitempty" yaml:"channel_layout,omitempty"`
+	Rules          []AudioRule `json:"rules,omitempty" yaml:"rules,omitempty"`
+}
+
+// AudioRule applies its encoder settings to every source audio track that
+// satisfies all of the populated Match_* fields. Match_codecs accepts the
+// keyword "lossless" in addition to ffprobe codec names.
+type AudioRule struct {
+	Match_codecs     []string `json:"match_codecs,omitempty" yaml:"match_codecs,omitempty"`
+	Match_language   string   `json:"match_language,omitempty" yaml:"match_language,omitempty"`
+	Match_commentary bool     `json:"match_commentary,omitempty" yaml:"match_commentary,omitempty"`
+	Codec            string   `json:"codec" yaml:"codec"`
+	Bitrate          string   `json:"bitrate,omitempty" yaml:"bitrate,omitempty"`
+	Channel_layout   string   `json:"channel_layout,omitempty" yaml:"channel_layout,omitempty"`
+}
+
+// StreamSelection chooses the source audio and subtitle streams kept in the
+// output by ordered language lists. Languages are ISO 639-2 codes or one of the
+// keywords "original" and "all". Undetermined streams are kept only when
+// Keep_undetermined is set and no other stream of that type matched.
+type StreamSelection struct {
+	Audio_languages    []string `json:"audio_languages" yaml:"audio_languages"`
+	Subtitle_languages []string `json:"subtitle_languages" yaml:"subtitle_languages"`
+	Keep_undetermined  bool     `json:"keep_undetermined,omitempty" yaml:"keep_undetermined,omitempty"`
+	Default_audio      string   `json:"default_audio,omitempty" yaml:"default_audio,omitempty"`
+	Default_subtitle   string   `json:"default_subtitle,omitempty" yaml:"default_subtitle,omitempty"`
+	Forced_subtitle    string   `json:"forced_subtitle,omitempty" yaml:"forced_subtitle,omitempty"`
+}
+
+// Profile is a named set of defaults declared in the service configuration.
+// Requests naming a profile inherit any setting they do not specify themselves.
+type Profile struct {
+	Audio         *AudioSettings   `yaml:"audio,omitempty"`
+	Audio_filters string           `yaml:"audio_filters,omitempty"`
+	Streams       *StreamSelection `yaml:"stream_selection,omitempty"`
+	Max_width     int              `yaml:"max_width,omitempty"`
+	Max_height    int              `yaml:"max_height,omitempty"`
 }
 
 type ColorInfoWrapper struct {
@@ -0,0 +1,173 @@
+// Copyright 2022 GearnsC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package ffwrap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidRequest is wrapped by the errors of Validate so that callers can
+// tell a request refused for its settings from a failure to check it.
+var ErrInvalidRequest = errors.New("invalid transcode request")
+
+// Validate checks a request for combinations of settings that ffmpeg would
+// reject so the job can be refused when it is submitted instead of failing
+// once it reaches a transcoder slot.
+func (tr TranscodeRequest) Validate() error {
+	if err := tr.validateCodecs(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	as := AudioSettings{Codec: "copy"}
+	if tr.Audio != nil {
+		as = *tr.Audio
+	}
+	if err := as.validate(tr.Audio_filters); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	seen := map[string]bool{tr.Destination: true}
+	for _, r := range tr.Outputs {
+		if err := r.validate(seen); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+	}
+	if err := tr.validateTrim(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if err := tr.validateScan(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if err := tr.validateCrop(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if err := tr.validateCropReview(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if err := tr.validateScale(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if err := tr.validateToneMapping(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if err := tr.validateHdrPolicy(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if err := tr.validateFrameRate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if err := tr.validateJobType(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if tr.Metadata != nil {
+		if tr.Packaging != nil {
+			return fmt.Errorf("%w: metadata options cannot be combined with packaging", ErrInvalidRequest)
+		}
+		for _, o := range tr.renditions() {
+			if err := tr.Metadata.validate(o.outputContainer()); err != nil {
+				return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+			}
+		}
+	}
+	if tr.Artifacts != nil {
+		if tr.Packaging != nil {
+			return fmt.Errorf("%w: artifacts are generated from a single output file and cannot be combined with packaging", ErrInvalidRequest)
+		}
+		if err := tr.Artifacts.validate(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+	}
+	if err := tr.validateContainer(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+	}
+	if tr.Packaging != nil {
+		if err := tr.Packaging.validate(tr); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+	} else {
+		for _, o := range tr.renditions() {
+			for _, sf := range o.Srt_files {
+				if sf.Path == "" {
+					continue
+				}
+				if err := sf.validate(o.outputContainer()); err != nil {
+					return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+				}
+			}
+		}
+	}
+	for _, af := range tr.Audio_files {
+		if err := af.validate(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+	}
+	if tr.Streams != nil {
+		if err := tr.Streams.validate(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidRequest, err)
+		}
+	}
+	return nil
+}
+
+// ApplyProfile fills in any setting the request leaves unspecified from the
+// given profile. Copies keep the resolution of the source and ignore the
+// profile's resolution cap.
+func (tr *TranscodeRequest) ApplyProfile(p Profile) {
+	if tr.Audio == nil && p.Audio != nil {
+		a := *p.Audio
+		tr.Audio = &a
+	}
+	if tr.Audio_filters == "" {
+		tr.Audio_filters = p.Audio_filters
+	}
+	if tr.Streams == nil && p.Streams != nil {
+		sel := *p.Streams
+		tr.Streams = &sel
+	}
+	if tr.Max_width == 0 && tr.Max_height == 0 && !strings.EqualFold(tr.Codec, "copy") {
+		tr.Max_width, tr.Max_height = p.Max_width, p.Max_height
+	}
+}
+
+// validateJobType checks the job type and, for metadata jobs, that nothing
+// would require re-encoding or additional outputs.
+func (tr TranscodeRequest) validateJobType() error {
+	if len(tr.Sources) > 0 && !strings.EqualFold(tr.Job_type, JobTypeConcat) {
+		return fmt.Errorf("sources are only joined by concat jobs")
+	}
+	switch strings.ToLower(tr.Job_type) {
+	case "":
+		return nil
+	case JobTypeConcat:
+		return tr.validateConcat()
+	case JobTypeMetadata:
+	default:
+		return fmt.Errorf("unsupported job type %q", tr.Job_type)
+	}
+	switch {
+	case tr.Metadata == nil:
+		return fmt.Errorf("metadata jobs require metadata options")
+	case tr.Source == tr.Destination:
+		return fmt.Errorf("metadata jobs can't rewrite the source in place")
+	case !strings.EqualFold(tr.Codec, "copy"):
+		return fmt.Errorf("metadata jobs can't encode video")
+	case tr.Video_filters != "" || tr.Audio_filters != "" || tr.Autocrop, tr.frameRateMode() != "":
+		return fmt.Errorf("metadata jobs can't apply filters")
+	case tr.Audio != nil, tr.Streams != nil, len(tr.Srt_files) > 0, len(tr.Audio_files) > 0:
+		return fmt.Errorf("metadata jobs copy every stream of the source unchanged")
+	case len(tr.Outputs) > 0, tr.Packaging != nil:
+		return fmt.Errorf("metadata jobs write a single output")
+	}
+	return nil
+}
@@ -29,6 +29,7 @@ import (
 
 	"github.com/gitgerby/transcode-factory/internal/pkg/config"
 	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap"
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap/codec"
 	"github.com/gitgerby/transcode-factory/internal/pkg/priority"
 
 	"github.com/google/logger"
@@ -46,10 +47,13 @@ type JobState string
 const (
 	JOB_SUBMITTED        = "submitted"
 	JOB_METADATA         = "probing source metadata"
+	JOB_SCANANALYSIS     = "detecting interlacing and telecine"
 	JOB_BUILDVIDEOFILTER = "constructing video filter graph"
 	JOB_BUILDAUDIOFILTER = "constructing audio filter graph"
+	JOB_CROPREVIEW       = "awaiting crop approval"
 	JOB_PENDINGTRANSCODE = "waiting for transcoder slot"
 	JOB_TRANSCODING      = "copying or transcoding media"
+	JOB_ARTIFACTS        = "generating thumbnails and previews"
 	JOB_SUCCESS          = "completed successfully"
 	JOB_FAILED           = "job failed"
 	JOB_CANCELLED        = "job cancelled before completion"
@@ -59,7 +63,9 @@ type TranscodeJob struct {
 	Id            int
 	JobDefinition ffwrap.TranscodeRequest
 	SourceMeta    ffwrap.MediaMetadata
+	Inventory     *ffwrap.FfprobeOutput
 	State         JobState
+	CropPreviews  []ffwrap.Artifact
 }
 
 var (
@@ -142,6 +148,11 @@ func launchApi() {
 		bulkAddHandler(w, r, wsHub.refresh)
 	})
 	http.HandleFunc("/logstream", logStream)
+	http.HandleFunc("GET /jobs/{id}/probe", probeHandler)
+	http.HandleFunc("GET /jobs/{id}/artifacts", artifactsHandler)
+	http.HandleFunc("POST /jobs/{id}/crop", cropReviewHandler)
+	http.HandleFunc("GET /jobs/{id}/crop/previews/{n}", cropPreviewHandler)
+	http.HandleFunc("GET /codecs", codecsHandler)
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/statusz", http.StatusFound)
 	})
@@ -182,6 +193,22 @@ func initDbTables(db *sql.DB) error {
 		status INTEGER
 	);
 
+  CREATE TABLE IF NOT EXISTS completed_renditions (
+    id INTEGER,
+    destination TEXT,
+    codec TEXT,
+    status TEXT,
+    PRIMARY KEY (id, destination)
+  );
+
+  CREATE TABLE IF NOT EXISTS artifacts (
+    id INTEGER,
+    kind TEXT,
+    path TEXT,
+    timestamp REAL,
+    PRIMARY KEY (id, path)
+  );
+
   CREATE TABLE IF NOT EXISTS source_metadata (
 		id INTEGER PRIMARY KEY,
 		codec TEXT,
@@ -199,6 +226,68 @@ func initDbTables(db *sql.DB) error {
     `); err != nil {
 		return err
 	}
+	if err := migrateDbTables(db); err != nil {
+		return err
+	}
+	return restoreHeldCrops(db)
+}
+
+// addedColumns lists the columns introduced after a table was first released,
+// they are added to existing databases by migrateDbTables.
+var addedColumns = []struct {
+	table, column, definition string
+}{
+	{"transcode_queue", "audio_settings", "BLOB"},
+	{"transcode_queue", "stream_selection", "BLOB"},
+	{"transcode_queue", "audio_files", "BLOB"},
+	{"source_metadata", "inventory", "BLOB"},
+	{"transcode_queue", "outputs", "BLOB"},
+	{"transcode_queue", "packaging", "BLOB"},
+	{"transcode_queue", "container", "TEXT"},
+	{"transcode_queue", "metadata", "BLOB"},
+	{"transcode_queue", "job_type", "TEXT"},
+	{"transcode_queue", "artifacts", "BLOB"},
+	{"transcode_queue", "trim_start", "REAL"},
+	{"transcode_queue", "trim_end", "REAL"},
+	{"transcode_queue", "trim_duration", "REAL"},
+	{"log_files", "duration", "REAL"},
+	{"transcode_queue", "sources", "BLOB"},
+	{"transcode_queue", "scan_type", "TEXT"},
+	{"transcode_queue", "deinterlacer", "TEXT"},
+	{"transcode_queue", "scan_analysis", "BLOB"},
+	{"transcode_queue", "max_width", "INTEGER"},
+	{"transcode_queue", "max_height", "INTEGER"},
+	{"transcode_queue", "tonemap", "BLOB"},
+	{"transcode_queue", "hdr_policy", "TEXT"},
+	{"source_metadata", "hdr_format", "TEXT"},
+	{"transcode_queue", "crop_alignment", "INTEGER"},
+	{"transcode_queue", "crop_confidence", "REAL"},
+	{"transcode_queue", "crop_fallback", "TEXT"},
+	{"transcode_queue", "crop_analysis", "BLOB"},
+	{"transcode_queue", "aspect_policy", "TEXT"},
+	{"transcode_queue", "review_crop", "INTEGER"},
+	{"transcode_queue", "frame_rate_mode", "TEXT"},
+	{"transcode_queue", "frame_rate", "TEXT"},
+	{"source_metadata", "frame_rate", "TEXT"},
+	{"source_metadata", "vfr", "INTEGER"},
+}
+
+// migrateDbTables adds any column in addedColumns that is missing from an
+// existing database.
+func migrateDbTables(db *sql.DB) error {
+	for _, c := range addedColumns {
+		var n int
+		r := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column)
+		if err := r.Scan(&n); err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", c.table, err)
+		}
+		if n > 0 {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.column, err)
+		}
+	}
 	return nil
 }
 
@@ -266,6 +355,13 @@ func mainLoop() {
 				if err := finishJob(&tj, nil); err != nil {
 					logger.Fatalf("failed to cleanup job: %q", err)
 				}
+				return nil
+			}
+			if err := generateArtifacts(&tj); err != nil {
+				if errors.Is(err, context.Canceled) {
+					return err
+				}
+				logger.Errorf("job id %d: failed to generate artifacts: %v", tj.Id, err)
 			}
 			updateJobStatus(tj.Id, JOB_SUCCESS)
 			tj.State = JOB_SUCCESS
@@ -303,11 +399,30 @@ func cropManager() {
 				logger.Errorf("job id %d: failed to determine source metadata: %q", tj.Id, err)
 			}
 
+			if tj.JobDefinition.DetectsScan() {
+				updateJobStatus(tj.Id, JOB_SCANANALYSIS)
+				err = analyzeScan(&tj)
+				if errors.Is(err, context.Canceled) {
+					return err
+				} else if err != nil {
+					logger.Errorf("job id %d: failed to detect scan type: %q", tj.Id, err)
+				}
+				updateJobStatus(tj.Id, JOB_BUILDVIDEOFILTER)
+			}
+
 			err = compileVF(&tj)
-			if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return err
+			} else if errors.Is(err, errCropHeld) {
+				// the job stays active until its crop is reviewed
+				logger.Warningf("job id %d: %v", tj.Id, err)
+				updateJobStatus(tj.Id, JOB_CROPREVIEW)
+				return nil
+			} else if err != nil {
 				logger.Errorf("job id %d: failed to compile vf: %q", tj.Id, err)
+			} else {
+				updateJobStatus(tj.Id, JOB_PENDINGTRANSCODE)
 			}
-			updateJobStatus(tj.Id, JOB_PENDINGTRANSCODE)
 			err = deactivateJob(tj.Id)
 			if err != nil {
 				logger.Errorf("job id %d: failed to deactivate job: %q", tj.Id, err)
@@ -343,9 +458,11 @@ func copyManager() {
 				logger.Errorf("failed to update job %d status: %q", tj.Id, err)
 				return nil
 			}
-			if err := createDestinationParent(tj.JobDefinition.Destination); err != nil {
-				logger.Errorf("failed to create destination directory: %v", err)
-				return nil
+			for _, d := range tj.JobDefinition.Destinations() {
+				if err := createDestinationParent(d); err != nil {
+					logger.Errorf("failed to create destination directory: %v", err)
+					return nil
+				}
 			}
 			if err := updateSourceMetadata(&tj); err != nil {
 				logger.Errorf("failed to update source metadata for job %d: %v", tj.Id, err)
@@ -360,15 +477,23 @@ func copyManager() {
 				return nil
 			}
 
-			args, err := ffwrap.FfmpegTranscode(ctx, tj.JobDefinition)
+			args, err := ffwrap.FfmpegTranscode(ctx, tj.JobDefinition, tj.Inventory)
 			if err != nil {
 				if errors.Is(err, context.Canceled) {
 					return err
 				}
 				logger.Errorf("job id %d: failed to run ffmpeg copy with err: %v", tj.Id, err)
+				tj.State = JOB_FAILED
 				if err := finishJob(&tj, []string{}); err != nil {
 					logger.Errorf("failed to cleanup job: %q", err)
 				}
+				return nil
+			}
+			if err := generateArtifacts(&tj); err != nil {
+				if errors.Is(err, context.Canceled) {
+					return err
+				}
+				logger.Errorf("job id %d: failed to generate artifacts: %v", tj.Id, err)
 			}
 			tj.State = JOB_SUCCESS
 			finishJob(&tj, args)
@@ -402,6 +527,9 @@ func main() {
 	if err != nil {
 		logger.Fatalf("failed to parse config: %q", err)
 	}
+	if err := codec.RegisterTemplates(tfConfig.Codecs); err != nil {
+		logger.Fatalf("failed to register codec templates: %q", err)
+	}
 
 	svcConfig := &service.Config{
 		Name:        "TranscodeFactory",
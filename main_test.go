@@ -1,7 +1,9 @@
 package main
 
 import (
+	"database/sql"
 	"io"
+	"testing"
 
 	"github.com/google/logger"
 )
@@ -9,3 +11,37 @@ import (
 func init() {
 	logger.Init("transcode-factory", true, true, io.Discard)
 }
+
+func TestMigrateDbTables(t *testing.T) {
+	mdb, err := sql.Open("sqlite", inMemoryDatabase)
+	if err != nil {
+		t.Fatalf("failed to open temp memory database: %v", err)
+	}
+	t.Cleanup(func() { mdb.Close() })
+
+	// tables as created before any column in addedColumns existed
+	if _, err := mdb.Exec(`
+		CREATE TABLE transcode_queue (id INTEGER PRIMARY KEY AUTOINCREMENT, source TEXT);
+		CREATE TABLE completed_jobs (id INTEGER PRIMARY KEY, source TEXT);
+		CREATE TABLE source_metadata (id INTEGER PRIMARY KEY, codec TEXT);
+		CREATE TABLE log_files (id INTEGER PRIMARY KEY, logfile TEXT);
+	`); err != nil {
+		t.Fatalf("failed to create legacy tables: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := migrateDbTables(mdb); err != nil {
+			t.Fatalf("migrateDbTables() run %d: %v", i, err)
+		}
+	}
+
+	for _, c := range addedColumns {
+		var n int
+		if err := mdb.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", c.table, c.column).Scan(&n); err != nil {
+			t.Fatalf("failed to inspect %s: %v", c.table, err)
+		}
+		if n != 1 {
+			t.Errorf("column %s.%s missing after migration", c.table, c.column)
+		}
+	}
+}
@@ -15,12 +15,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"time"
 
@@ -32,26 +33,26 @@ import (
 // pullNextCrop retrieves the next crop job from the queue.
 //
 // It selects a job that is not yet completed or active, requires cropping
-// (autocrop = 1), has not been cropped yet (crop_complete != 1), and does not
-// use the 'copy' codec. The job details, including the source and video filters,
-// are populated and returned as a TranscodeJob struct.
+// (autocrop = 1) or a scan type filter, has not been through the video filter
+// stage yet or held for review (crop_complete = 0), and encodes the video of
+// at least one of its renditions. The job details, including the source, video
+// filters, scan type and crop settings, are populated and returned as a
+// TranscodeJob struct.
 func pullNextCrop() (TranscodeJob, error) {
 	niq := `
-  SELECT id, source, video_filters
+  SELECT id, source, video_filters, IFNULL(autocrop, 1), IFNULL(scan_type, ''), IFNULL(deinterlacer, ''), IFNULL(trim_start, 0), IFNULL(trim_end, 0), IFNULL(trim_duration, 0), IFNULL(max_width, 0), IFNULL(max_height, 0), IFNULL(crop_alignment, 0), IFNULL(crop_confidence, 0), IFNULL(crop_fallback, ''), IFNULL(aspect_policy, ''), IFNULL(review_crop, 0)
   FROM transcode_queue
 	WHERE id NOT IN (SELECT id FROM completed_jobs)
 		AND id NOT IN (SELECT id FROM active_jobs)
-		AND autocrop = 1
-		AND crop_complete != 1
-		AND codec != 'copy'
+		AND ` + filterStageCondition + `
+		AND crop_complete = 0
+		AND NOT ` + copyCondition + `
 	ORDER BY id ASC
 	LIMIT 1;`
 
-	tj := TranscodeJob{
-		JobDefinition: ffwrap.TranscodeRequest{Autocrop: true},
-	}
+	var tj TranscodeJob
 	r := db.QueryRow(niq)
-	err := r.Scan(&tj.Id, &tj.JobDefinition.Source, &tj.JobDefinition.Video_filters)
+	err := r.Scan(&tj.Id, &tj.JobDefinition.Source, &tj.JobDefinition.Video_filters, &tj.JobDefinition.Autocrop, &tj.JobDefinition.Scan_type, &tj.JobDefinition.Deinterlacer, &tj.JobDefinition.Start, &tj.JobDefinition.End, &tj.JobDefinition.Duration, &tj.JobDefinition.Max_width, &tj.JobDefinition.Max_height, &tj.JobDefinition.Crop_alignment, &tj.JobDefinition.Crop_confidence, &tj.JobDefinition.Crop_fallback, &tj.JobDefinition.Aspect_policy, &tj.JobDefinition.Review_crop)
 	if err == sql.ErrNoRows {
 		return TranscodeJob{}, err
 	} else if err != nil {
@@ -60,24 +61,33 @@ func pullNextCrop() (TranscodeJob, error) {
 	return tj, nil
 }
 
-// pullNextTranscode retrieves the next transcode job from the queue.
-//
-// It selects a job that is not yet completed, a copy, or active and is not
-// waiting for autocrop. The job details returned as a TranscodeJob struct.
-func pullNextTranscode() (TranscodeJob, error) {
-	niq := `
-  SELECT id, source, destination, IFNULL(crf,18) as crf, srt_files, IFNULL(autocrop,1) as autocrop, video_filters, audio_filters, codec
-  FROM transcode_queue
-  WHERE id NOT IN (SELECT id FROM completed_jobs)
-	AND id NOT IN (SELECT id FROM active_jobs)
-	AND ((autocrop = 1 AND crop_complete = 1) OR ((autocrop = 0) AND (LOWER(codec) != 'copy')))
-  ORDER BY id ASC
-  LIMIT 1;`
+// filterStageCondition matches the jobs whose video filters are completed by
+// the crop manager: autocropped jobs, jobs whose scan type is detected or
+// needs a filter and jobs with a resolution cap.
+const filterStageCondition = `(autocrop = 1 OR LOWER(IFNULL(scan_type, '')) IN ('auto', 'interlaced', 'telecined') OR IFNULL(max_width, 0) > 0 OR IFNULL(max_height, 0) > 0)`
 
-	r := db.QueryRow(niq)
+// copyCondition matches the jobs that copy the video of every rendition, the
+// renditions without a codec take the codec of the job.
+const copyCondition = `(LOWER(codec) = 'copy' AND NOT EXISTS (SELECT 1 FROM json_each(CAST(IFNULL(outputs, '[]') AS TEXT)) WHERE LOWER(IFNULL(json_extract(value, '$.codec'), '')) NOT IN ('', 'copy')))`
+
+// queuedJobColumns are the transcode_queue columns read by scanQueuedJob.
+const queuedJobColumns = `id, source, destination, IFNULL(crf,18) as crf, srt_files, IFNULL(autocrop,1) as autocrop, video_filters, audio_filters, codec, audio_settings, stream_selection, audio_files, outputs, packaging, IFNULL(container, '') as container, metadata, IFNULL(job_type, '') as job_type, artifacts, IFNULL(trim_start, 0) as trim_start, IFNULL(trim_end, 0) as trim_end, IFNULL(trim_duration, 0) as trim_duration, sources, IFNULL(scan_type, '') as scan_type, IFNULL(deinterlacer, '') as deinterlacer, scan_analysis, IFNULL(max_width, 0) as max_width, IFNULL(max_height, 0) as max_height, tonemap, IFNULL(hdr_policy, '') as hdr_policy, IFNULL(crop_alignment, 0) as crop_alignment, IFNULL(crop_confidence, 0) as crop_confidence, IFNULL(crop_fallback, '') as crop_fallback, crop_analysis, IFNULL(aspect_policy, '') as aspect_policy, IFNULL(frame_rate_mode, '') as frame_rate_mode, IFNULL(frame_rate, '') as frame_rate`
+
+// unmarshalBlob decodes an optional JSON column into v, a NULL column leaves v untouched.
+func unmarshalBlob(name string, b []byte, v any) {
+	if len(b) == 0 {
+		return
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		logger.Errorf("failed to unmarshal %s: %q", name, err)
+	}
+}
+
+// scanQueuedJob populates a TranscodeJob from a row selected with queuedJobColumns.
+func scanQueuedJob(r *sql.Row) (TranscodeJob, error) {
 	var tj TranscodeJob
-	var subs []byte
-	err := r.Scan(&tj.Id, &tj.JobDefinition.Source, &tj.JobDefinition.Destination, &tj.JobDefinition.Crf, &subs, &tj.JobDefinition.Autocrop, &tj.JobDefinition.Video_filters, &tj.JobDefinition.Audio_filters, &tj.JobDefinition.Codec)
+	var subs, audio, selection, audioFiles, outputs, packaging, metadata, artifacts, sources, scanAnalysis, tonemap, cropAnalysis []byte
+	err := r.Scan(&tj.Id, &tj.JobDefinition.Source, &tj.JobDefinition.Destination, &tj.JobDefinition.Crf, &subs, &tj.JobDefinition.Autocrop, &tj.JobDefinition.Video_filters, &tj.JobDefinition.Audio_filters, &tj.JobDefinition.Codec, &audio, &selection, &audioFiles, &outputs, &packaging, &tj.JobDefinition.Container, &metadata, &tj.JobDefinition.Job_type, &artifacts, &tj.JobDefinition.Start, &tj.JobDefinition.End, &tj.JobDefinition.Duration, &sources, &tj.JobDefinition.Scan_type, &tj.JobDefinition.Deinterlacer, &scanAnalysis, &tj.JobDefinition.Max_width, &tj.JobDefinition.Max_height, &tonemap, &tj.JobDefinition.Hdr_policy, &tj.JobDefinition.Crop_alignment, &tj.JobDefinition.Crop_confidence, &tj.JobDefinition.Crop_fallback, &cropAnalysis, &tj.JobDefinition.Aspect_policy, &tj.JobDefinition.Frame_rate_mode, &tj.JobDefinition.Frame_rate)
 	if err == sql.ErrNoRows {
 		return TranscodeJob{}, err
 	} else if err != nil {
@@ -88,33 +98,51 @@ func pullNextTranscode() (TranscodeJob, error) {
 	if err != nil {
 		logger.Errorf("failed to unmarshal srt files: %q", err)
 	}
+	unmarshalBlob("audio settings", audio, &tj.JobDefinition.Audio)
+	unmarshalBlob("stream selection", selection, &tj.JobDefinition.Streams)
+	unmarshalBlob("audio files", audioFiles, &tj.JobDefinition.Audio_files)
+	unmarshalBlob("outputs", outputs, &tj.JobDefinition.Outputs)
+	unmarshalBlob("packaging", packaging, &tj.JobDefinition.Packaging)
+	unmarshalBlob("metadata", metadata, &tj.JobDefinition.Metadata)
+	unmarshalBlob("artifacts", artifacts, &tj.JobDefinition.Artifacts)
+	unmarshalBlob("sources", sources, &tj.JobDefinition.Sources)
+	unmarshalBlob("scan analysis", scanAnalysis, &tj.JobDefinition.Scan_analysis)
+	unmarshalBlob("tonemap", tonemap, &tj.JobDefinition.Tonemap)
+	unmarshalBlob("crop analysis", cropAnalysis, &tj.JobDefinition.Crop_analysis)
 	return tj, nil
 }
 
+// pullNextTranscode retrieves the next transcode job from the queue.
+//
+// It selects a job that is not yet completed, a copy, or active and is not
+// waiting for autocrop or scan detection. The job details returned as a
+// TranscodeJob struct.
+func pullNextTranscode() (TranscodeJob, error) {
+	niq := `
+  SELECT ` + queuedJobColumns + `
+  FROM transcode_queue
+  WHERE id NOT IN (SELECT id FROM completed_jobs)
+	AND id NOT IN (SELECT id FROM active_jobs)
+	AND ((` + filterStageCondition + ` AND crop_complete = 1) OR (NOT ` + filterStageCondition + ` AND NOT ` + copyCondition + `))
+  ORDER BY id ASC
+  LIMIT 1;`
+
+	return scanQueuedJob(db.QueryRow(niq))
+}
+
+// pullNextCopy retrieves the next job copying the video of every rendition,
+// jobs encoding any rendition are left to the transcode manager.
 func pullNextCopy() (TranscodeJob, error) {
 	niq := `
-  SELECT id, source, destination, IFNULL(crf,18) as crf, srt_files, IFNULL(autocrop,1) as autocrop, video_filters, audio_filters, codec
+  SELECT ` + queuedJobColumns + `
   FROM transcode_queue
   WHERE id NOT IN (SELECT id FROM completed_jobs)
 	AND id NOT IN (SELECT id FROM active_jobs)
-	AND LOWER(codec) = 'copy'
+	AND ` + copyCondition + `
   ORDER BY id ASC
   LIMIT 1;`
 
-	r := db.QueryRow(niq)
-	var tj TranscodeJob
-	var subs []byte
-	err := r.Scan(&tj.Id, &tj.JobDefinition.Source, &tj.JobDefinition.Destination, &tj.JobDefinition.Crf, &subs, &tj.JobDefinition.Autocrop, &tj.JobDefinition.Video_filters, &tj.JobDefinition.Audio_filters, &tj.JobDefinition.Codec)
-	if err == sql.ErrNoRows {
-		return TranscodeJob{}, err
-	} else if err != nil {
-		return TranscodeJob{}, fmt.Errorf("db query error: %w", err)
-	}
-	err = json.Unmarshal(subs, &tj.JobDefinition.Srt_files)
-	if err != nil {
-		logger.Errorf("failed to unmarshal srt files: %q", err)
-	}
-	return tj, nil
+	return scanQueuedJob(db.QueryRow(niq))
 }
 
 func deactivateJob(id int) error {
@@ -144,9 +172,9 @@ func querySourceTable(id int) (ffwrap.MediaMetadata, error) {
 	}
 	defer tx.Rollback()
 	// IFNULL --> 8k resolution this ensures crops will trigger on basically any video if we don't detect the correct size
-	r := tx.QueryRow("SELECT codec, IFNULL(width,7680), IFNULL(height,4320) FROM source_metadata WHERE id = ?", id)
+	r := tx.QueryRow("SELECT codec, IFNULL(width,7680), IFNULL(height,4320), IFNULL(hdr_format, ''), IFNULL(frame_rate, ''), IFNULL(vfr, 0) FROM source_metadata WHERE id = ?", id)
 	var m ffwrap.MediaMetadata
-	err = r.Scan(&m.Codec, &m.Width, &m.Height)
+	err = r.Scan(&m.Codec, &m.Width, &m.Height, &m.Hdr_format, &m.Frame_rate, &m.Vfr)
 	if err == sql.ErrNoRows {
 		return ffwrap.MediaMetadata{}, err
 	} else if err != nil {
@@ -155,6 +183,21 @@ func querySourceTable(id int) (ffwrap.MediaMetadata, error) {
 	return m, tx.Commit()
 }
 
+// queryInventory returns the stored ffprobe inventory of a job's source, or
+// sql.ErrNoRows when the source has not been probed.
+func queryInventory(id int) (*ffwrap.FfprobeOutput, error) {
+	var b []byte
+	err := db.QueryRow("SELECT inventory FROM source_metadata WHERE id = ? AND inventory IS NOT NULL", id).Scan(&b)
+	if err != nil {
+		return nil, err
+	}
+	var inv ffwrap.FfprobeOutput
+	if err := json.Unmarshal(b, &inv); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal inventory: %w", err)
+	}
+	return &inv, nil
+}
+
 // updateSourceMetadata queries the database for existing ffprobe results; if
 // none are found it runs ffprobe and populates the database and the provided
 // struct.
@@ -162,6 +205,11 @@ func updateSourceMetadata(tj *TranscodeJob) error {
 	m, err := querySourceTable(tj.Id)
 	if err == nil {
 		tj.SourceMeta = m
+		if inv, err := queryInventory(tj.Id); err == nil {
+			tj.Inventory = inv
+		} else if err != sql.ErrNoRows {
+			logger.Errorf("job id %d: %v", tj.Id, err)
+		}
 		return nil
 	} else if err != sql.ErrNoRows {
 		return fmt.Errorf("querying source table failed: %q", err)
@@ -188,57 +236,156 @@ func updateSourceMetadata(tj *TranscodeJob) error {
 		return fmt.Errorf("failed to query source file for index %d: %q", tj.Id, err)
 	}
 
-	fc, err := ffwrap.ProbeMetadata(ctx, s)
+	inv, err := ffwrap.ProbeSource(ctx, s)
+	if err != nil {
+		return fmt.Errorf("metadata probe returned: %w", err)
+	}
+	fc, err := inv.MediaMetadata()
 	if err != nil {
 		return fmt.Errorf("metadata probe returned: %q", err)
 	}
+	ib, err := json.Marshal(inv)
+	if err != nil {
+		return fmt.Errorf("failed to marshal inventory: %q", err)
+	}
+	if hdr, err := ffwrap.ProbeHdr(ctx, s, inv); err != nil {
+		logger.Errorf("job id %d: failed to detect hdr format: %v", tj.Id, err)
+	} else {
+		fc.Hdr_format = hdr.String()
+	}
+	if !fc.Vfr {
+		if vfr, err := ffwrap.DetectVfr(ctx, s); err != nil {
+			logger.Errorf("job id %d: failed to detect variable frame rate: %v", tj.Id, err)
+		} else {
+			fc.Vfr = vfr
+		}
+	}
 
-	_, err = tx.Exec("UPDATE source_metadata SET codec = ?, width = ?, height = ?, duration = ? WHERE id = ?", fc.Codec, fc.Width, fc.Height, fc.Duration, tj.Id)
+	_, err = tx.Exec("UPDATE source_metadata SET codec = ?, width = ?, height = ?, duration = ?, inventory = ?, hdr_format = ?, frame_rate = ?, vfr = ? WHERE id = ?", fc.Codec, fc.Width, fc.Height, fc.Duration, ib, fc.Hdr_format, fc.Frame_rate, fc.Vfr, tj.Id)
 	if err != nil {
 		return fmt.Errorf("failed to update source metadata: %q", err)
 	}
+	tj.Inventory = &inv
 	tj.SourceMeta.Width = fc.Width
 	tj.SourceMeta.Height = fc.Height
 	tj.SourceMeta.Codec = fc.Codec
 	tj.SourceMeta.Duration = fc.Duration
+	tj.SourceMeta.Hdr_format = fc.Hdr_format
+	tj.SourceMeta.Frame_rate = fc.Frame_rate
+	tj.SourceMeta.Vfr = fc.Vfr
 	logger.Infof("job id %d:source metadata: %#v", tj.Id, tj.SourceMeta)
 	return tx.Commit()
 }
 
-// compileVF builds the appropriate video filter string based on the provided filter string
-// and the autocrop setting if set to true.
+// analyzeScan detects whether the source of a job is progressive, interlaced
+// or telecined when the request asks for it and persists the result as the
+// scan type of the job.
+func analyzeScan(tj *TranscodeJob) error {
+	if !tj.JobDefinition.DetectsScan() {
+		return nil
+	}
+	duration, err := ffwrap.ParseDuration(tj.SourceMeta.Duration)
+	if err != nil {
+		duration = 0
+	}
+	analysis, err := ffwrap.DetectScanType(ctx, tj.JobDefinition.Source, tj.JobDefinition.Start, tj.JobDefinition.OutputDuration(duration))
+	if err != nil {
+		return err
+	}
+	tj.JobDefinition.Scan_type = analysis.Classify()
+	tj.JobDefinition.Scan_analysis = &analysis
+	logger.Infof("job id %d: source scan type is %s (%s)", tj.Id, tj.JobDefinition.Scan_type, &analysis)
+
+	a, err := json.Marshal(analysis)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("UPDATE transcode_queue SET scan_type = ?, scan_analysis = ? WHERE id = ?", tj.JobDefinition.Scan_type, a, tj.Id)
+	if err != nil {
+		return fmt.Errorf("failed to persist scan type: %q", err)
+	}
+	return nil
+}
+
+// errCropHeld is returned by compileVF when the detected crop is not trusted
+// and the job is held for review instead of being queued for transcoding.
+var errCropHeld = errors.New("crop needs review, job held")
+
+// analyzeCrop detects the crop of the source of a job in windows spread across
+// its trimmed duration and persists the analysis, including the timeline of
+// its aspect ratios. It returns the crop filter the job's crop policy applies
+// and whether the job is held for review.
+func analyzeCrop(tj *TranscodeJob) (string, bool, error) {
+	duration, err := ffwrap.ParseDuration(tj.SourceMeta.Duration)
+	if err != nil {
+		duration = 0
+	}
+	analysis, err := ffwrap.DetectCrop(ctx, tj.JobDefinition.Source, tj.JobDefinition.Start, tj.JobDefinition.OutputDuration(duration))
+	if err != nil {
+		return "", false, err
+	}
+	analysis = tj.JobDefinition.SelectCrop(analysis, tj.SourceMeta.Width, tj.SourceMeta.Height, tj.Inventory.SampleAspectRatio())
+	tj.JobDefinition.Crop_analysis = &analysis
+	logger.Infof("job id %d: detected %s", tj.Id, &analysis)
+
+	a, err := json.Marshal(analysis)
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := db.Exec("UPDATE transcode_queue SET crop_analysis = ? WHERE id = ?", a, tj.Id); err != nil {
+		return "", false, fmt.Errorf("failed to persist crop analysis: %q", err)
+	}
+	filter, hold := tj.JobDefinition.CropPolicy(analysis)
+	return filter, hold, nil
+}
+
+// compileVF builds the appropriate video filter string based on the provided filter string,
+// the filter converting the scan type to progressive, the autocrop setting if set to true
+// and the resolution cap, which is applied to the cropped frames.
+// The scan filter runs first so that crop detection and cropping see whole frames.
+// Jobs whose crop policy holds an untrusted crop, and jobs requesting crop
+// review, are held for review instead and errCropHeld is returned.
 func compileVF(tj *TranscodeJob) error {
 	var cropFilter string
 	if tj.JobDefinition.Autocrop {
-		var err error
-		cropFilter, err = ffwrap.DetectCrop(ctx, tj.JobDefinition.Source)
+		filter, hold, err := analyzeCrop(tj)
 		if err != nil {
 			return err
 		}
+		if hold || tj.JobDefinition.Review_crop {
+			return holdCrop(tj)
+		}
+		cropFilter = filter
 	}
+	return buildVF(tj, cropFilter)
+}
 
-	// parse the crop filter
-	cropSlice := strings.Split(cropFilter, ":")
-	if len(cropSlice) < 2 {
-		return fmt.Errorf("splitting crop filter %q for parsing failed", cropFilter)
-	}
-	cropWidth, err := strconv.Atoi(cropSlice[0])
-	if err != nil {
-		cropWidth = 0
-	}
-	cropHeight, err := strconv.Atoi(cropSlice[1])
-	if err != nil {
-		cropHeight = 0
+// buildVF joins the scan filter, the crop filter, the scale filter fitting the
+// cropped frames within the resolution cap and the job's own video filters,
+// persists them and marks the video filter stage complete.
+func buildVF(tj *TranscodeJob, cropFilter string) error {
+	width, height := tj.SourceMeta.Width, tj.SourceMeta.Height
+	if cropFilter != "" {
+		cropWidth, cropHeight, err := ffwrap.CropSize(cropFilter)
+		if err != nil {
+			return err
+		}
+		// only add the crop filter if it's actually going to reduce the number of pixels running through the pipeline.
+		if cropWidth == width && cropHeight == height {
+			cropFilter = ""
+		} else {
+			width, height = cropWidth, cropHeight
+		}
 	}
+	scaleFilter := ffwrap.ScaleFilter(width, height, tj.Inventory.SampleAspectRatio(), tj.JobDefinition.Max_width, tj.JobDefinition.Max_height)
 
-	// only add the crop filter if it's actually going to reduce the number of pixels running through the pipeline.
-	if cropWidth != tj.SourceMeta.Width || cropHeight != tj.SourceMeta.Height {
-		if tj.JobDefinition.Video_filters != "" && cropFilter != "" {
-			tj.JobDefinition.Video_filters = strings.Join([]string{cropFilter, tj.JobDefinition.Video_filters}, ",")
-		} else if cropFilter != "" {
-			tj.JobDefinition.Video_filters = cropFilter
+	var filters []string
+	for _, f := range []string{ffwrap.ScanFilter(tj.JobDefinition.Scan_type, tj.JobDefinition.Deinterlacer), cropFilter, scaleFilter, tj.JobDefinition.Video_filters} {
+		if f != "" {
+			filters = append(filters, f)
 		}
 	}
+	tj.JobDefinition.Video_filters = strings.Join(filters, ",")
 
 	tx, err := db.Begin()
 	if err != nil {
@@ -257,6 +404,129 @@ func compileVF(tj *TranscodeJob) error {
 	return tx.Commit()
 }
 
+// holdCrop renders frames with the detected crop drawn on them, registers them
+// as crop preview artifacts and marks the job held for review
+// (crop_complete = 2). Failing to render the previews does not prevent the
+// hold. It returns errCropHeld.
+func holdCrop(tj *TranscodeJob) error {
+	var detected string
+	if tj.JobDefinition.Crop_analysis != nil {
+		detected = tj.JobDefinition.Crop_analysis.Filter
+	}
+	duration, err := ffwrap.ParseDuration(tj.SourceMeta.Duration)
+	if err != nil {
+		duration = 0
+	}
+	previews, err := tj.JobDefinition.RenderCropPreviews(ctx, detected, duration)
+	if errors.Is(err, context.Canceled) {
+		return err
+	} else if err != nil {
+		logger.Errorf("job id %d: failed to render crop previews: %v", tj.Id, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %q", err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM artifacts WHERE id = ? AND kind = ?", tj.Id, ffwrap.ArtifactCropPreview); err != nil {
+		return fmt.Errorf("failed to remove crop previews: %q", err)
+	}
+	for _, p := range previews {
+		_, err := tx.Exec(`
+		INSERT OR REPLACE INTO artifacts (id, kind, path, timestamp)
+		VALUES(?, ?, ?, ?)
+		`, tj.Id, p.Kind, p.Path, p.Timestamp)
+		if err != nil {
+			return fmt.Errorf("failed to register crop preview %q: %v", p.Path, err)
+		}
+	}
+	if _, err := tx.Exec("UPDATE transcode_queue SET crop_complete = 2 WHERE id = ?", tj.Id); err != nil {
+		return fmt.Errorf("failed to hold job: %q", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %s", errCropHeld, tj.JobDefinition.Crop_analysis)
+}
+
+// pullHeldCrop retrieves a job held for crop review with the settings its
+// video filters are built from, or sql.ErrNoRows when the job is not held.
+func pullHeldCrop(id int) (TranscodeJob, error) {
+	q := `
+  SELECT id, source, destination, video_filters, IFNULL(scan_type, ''), IFNULL(deinterlacer, ''), IFNULL(trim_start, 0), IFNULL(trim_end, 0), IFNULL(trim_duration, 0), IFNULL(max_width, 0), IFNULL(max_height, 0), crop_analysis
+  FROM transcode_queue
+	WHERE id = ?
+		AND id NOT IN (SELECT id FROM completed_jobs)
+		AND crop_complete = 2`
+
+	var tj TranscodeJob
+	var analysis []byte
+	err := db.QueryRow(q, id).Scan(&tj.Id, &tj.JobDefinition.Source, &tj.JobDefinition.Destination, &tj.JobDefinition.Video_filters, &tj.JobDefinition.Scan_type, &tj.JobDefinition.Deinterlacer, &tj.JobDefinition.Start, &tj.JobDefinition.End, &tj.JobDefinition.Duration, &tj.JobDefinition.Max_width, &tj.JobDefinition.Max_height, &analysis)
+	if err == sql.ErrNoRows {
+		return TranscodeJob{}, err
+	} else if err != nil {
+		return TranscodeJob{}, fmt.Errorf("db query error: %w", err)
+	}
+	tj.JobDefinition.Autocrop = true
+	unmarshalBlob("crop analysis", analysis, &tj.JobDefinition.Crop_analysis)
+	return tj, nil
+}
+
+// reviewCrop applies an operator's decision to a job held for crop review,
+// builds its video filters and releases it to the transcoder.
+func reviewCrop(tj *TranscodeJob, review ffwrap.CropReview) error {
+	var detected string
+	if tj.JobDefinition.Crop_analysis != nil {
+		detected = tj.JobDefinition.Crop_analysis.Filter
+	}
+	if err := buildVF(tj, review.Filter(detected)); err != nil {
+		return err
+	}
+	logger.Infof("job id %d: crop review %q, video filters %q", tj.Id, review.Action, tj.JobDefinition.Video_filters)
+	if err := updateJobStatus(tj.Id, JOB_PENDINGTRANSCODE); err != nil {
+		return err
+	}
+	return deactivateJob(tj.Id)
+}
+
+// queryCropPreviews returns the crop previews registered for a job.
+func queryCropPreviews(q querier, id int) ([]ffwrap.Artifact, error) {
+	rows, err := q.Query("SELECT kind, path, timestamp FROM artifacts WHERE id = ? AND kind = ? ORDER BY path", id, ffwrap.ArtifactCropPreview)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var previews []ffwrap.Artifact
+	for rows.Next() {
+		var a ffwrap.Artifact
+		if err := rows.Scan(&a.Kind, &a.Path, &a.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan crop preview: %w", err)
+		}
+		previews = append(previews, a)
+	}
+	return previews, rows.Err()
+}
+
+// querier runs queries on the database or within a transaction.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// restoreHeldCrops marks the jobs held for crop review as awaiting approval,
+// the active jobs are cleared whenever the service starts.
+func restoreHeldCrops(db *sql.DB) error {
+	_, err := db.Exec(`
+	INSERT OR IGNORE INTO active_jobs (id, job_state)
+	SELECT id, ? FROM transcode_queue
+	WHERE crop_complete = 2
+		AND id NOT IN (SELECT id FROM completed_jobs)`, JOB_CROPREVIEW)
+	if err != nil {
+		return fmt.Errorf("failed to restore jobs held for crop review: %w", err)
+	}
+	return nil
+}
+
 func createDestinationParent(path string) error {
 	// make sure the dest directory exists or create it
 	logger.Infof("making path: %q", filepath.Dir(path))
@@ -267,8 +537,36 @@ func createDestinationParent(path string) error {
 	return nil
 }
 
+// measureLightLevel measures the content light level of the job's source when
+// it is needed and stores it with the inventory, so retries of the job don't
+// measure the source again. The measurement decodes the whole source, it runs
+// in the job's transcoder slot outside of any transaction.
+func measureLightLevel(tj *TranscodeJob) error {
+	inv := tj.Inventory
+	if inv == nil || inv.Light_level != nil || strings.EqualFold(tj.JobDefinition.Job_type, ffwrap.JobTypeConcat) {
+		return nil
+	}
+	if err := ffwrap.MeasureLightLevel(ctx, tj.JobDefinition, tj.JobDefinition.Source, inv); err != nil || inv.Light_level == nil {
+		return err
+	}
+	ib, err := json.Marshal(inv)
+	if err != nil {
+		logger.Errorf("job id %d: failed to marshal inventory: %v", tj.Id, err)
+		return nil
+	}
+	if _, err := db.Exec("UPDATE source_metadata SET inventory = ? WHERE id = ?", ib, tj.Id); err != nil {
+		logger.Errorf("job id %d: failed to store content light level: %v", tj.Id, err)
+	}
+	return nil
+}
+
 func transcodeMedia(tj *TranscodeJob) ([]string, error) {
-	if err := createDestinationParent(tj.JobDefinition.Destination); err != nil {
+	for _, d := range tj.JobDefinition.Destinations() {
+		if err := createDestinationParent(d); err != nil {
+			return nil, err
+		}
+	}
+	if err := measureLightLevel(tj); err != nil {
 		return nil, err
 	}
 	err := registerLogFile(tj)
@@ -276,7 +574,67 @@ func transcodeMedia(tj *TranscodeJob) ([]string, error) {
 		return nil, err
 	}
 	// run the transcoder
-	return ffwrap.FfmpegTranscode(ctx, tj.JobDefinition)
+	return ffwrap.FfmpegTranscode(ctx, tj.JobDefinition, tj.Inventory)
+}
+
+// generateArtifacts writes the artifacts requested for a job from its
+// destination and registers every artifact that was written.
+func generateArtifacts(tj *TranscodeJob) error {
+	a := tj.JobDefinition.Artifacts
+	if a == nil {
+		return nil
+	}
+	if err := updateJobStatus(tj.Id, JOB_ARTIFACTS); err != nil {
+		logger.Errorf("failed to update job status: %v", err)
+	}
+	artifacts, genErr := ffwrap.GenerateArtifacts(ctx, *a, tj.JobDefinition.Destination)
+	for _, art := range artifacts {
+		_, err := db.Exec(`
+		INSERT OR REPLACE INTO artifacts (id, kind, path, timestamp)
+		VALUES(?, ?, ?, ?)
+		`, tj.Id, art.Kind, art.Path, art.Timestamp)
+		if err != nil {
+			return fmt.Errorf("failed to register artifact %q: %v", art.Path, err)
+		}
+	}
+	return genErr
+}
+
+// queryArtifacts returns the artifacts registered for a job, or sql.ErrNoRows
+// when there is no such job.
+func queryArtifacts(id int) ([]ffwrap.Artifact, error) {
+	rows, err := db.Query("SELECT kind, path, timestamp FROM artifacts WHERE id = ? ORDER BY kind, path", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	artifacts := []ffwrap.Artifact{}
+	for rows.Next() {
+		var a ffwrap.Artifact
+		if err := rows.Scan(&a.Kind, &a.Path, &a.Timestamp); err != nil {
+			return nil, fmt.Errorf("failed to scan artifact: %w", err)
+		}
+		artifacts = append(artifacts, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(artifacts) > 0 {
+		return artifacts, nil
+	}
+	var n int
+	err = db.QueryRow(`
+	SELECT COUNT(*) FROM (
+		SELECT id FROM transcode_queue WHERE id = ?
+		UNION SELECT id FROM completed_jobs WHERE id = ?
+	)`, id, id).Scan(&n)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return artifacts, nil
 }
 
 func finishJob(tj *TranscodeJob, args []string) error {
@@ -284,6 +642,10 @@ func finishJob(tj *TranscodeJob, args []string) error {
 	INSERT INTO completed_jobs (id, source, destination, autocrop, ffmpegargs, status)
 	VALUES(?, ?, ?, ?, ?, ?)
 	`
+	cr := `
+	INSERT OR REPLACE INTO completed_renditions (id, destination, codec, status)
+	VALUES(?, ?, ?, ?)
+	`
 	rm := `
 	DELETE FROM transcode_queue WHERE id = ?;
 	DELETE FROM active_jobs WHERE id = ?;
@@ -306,6 +668,12 @@ func finishJob(tj *TranscodeJob, args []string) error {
 	if err != nil {
 		return fmt.Errorf("failed to add completion record: %v", err)
 	}
+	for _, r := range renditionStates(tj) {
+		_, err = tx.Exec(cr, tj.Id, r.destination, r.codec, r.state)
+		if err != nil {
+			return fmt.Errorf("failed to add rendition completion record: %v", err)
+		}
+	}
 	_, err = tx.Exec(rm, tj.Id, tj.Id)
 	if err != nil {
 		return fmt.Errorf("failed to remove job records: %v", err)
@@ -314,8 +682,64 @@ func finishJob(tj *TranscodeJob, args []string) error {
 	return tx.Commit()
 }
 
+type renditionState struct {
+	destination string
+	codec       string
+	state       JobState
+}
+
+// renditionStates returns the final state of every output of the job. As all
+// outputs are written by one ffmpeg run a successful job only counts as a
+// success for the outputs that were actually written.
+func renditionStates(tj *TranscodeJob) []renditionState {
+	jd := tj.JobDefinition
+	dests := jd.Destinations()
+	states := []renditionState{{dests[0], jd.Codec, tj.State}}
+	for i, r := range jd.Outputs {
+		c := r.Codec
+		if c == "" {
+			c = jd.Codec
+		}
+		states = append(states, renditionState{dests[i+1], c, tj.State})
+	}
+	if tj.State != JOB_SUCCESS {
+		return states
+	}
+	for i, s := range states {
+		if fi, err := os.Stat(s.destination); err != nil || fi.Size() == 0 {
+			logger.Errorf("job id %d: rendition %q was not written", tj.Id, s.destination)
+			states[i].state = JOB_FAILED
+		}
+	}
+	return states
+}
+
+// outputDuration returns the expected duration of the job's output in
+// seconds, or 0 when the duration of the source is unknown. Concat jobs last
+// as long as all of their sources.
+func outputDuration(tj *TranscodeJob) float64 {
+	if strings.EqualFold(tj.JobDefinition.Job_type, ffwrap.JobTypeConcat) {
+		d, err := ffwrap.ConcatDuration(ctx, tj.JobDefinition.Sources)
+		if err != nil {
+			logger.Errorf("job id %d: %v", tj.Id, err)
+			return 0
+		}
+		return d
+	}
+	d := tj.SourceMeta.Duration
+	if tj.Inventory != nil {
+		d = tj.Inventory.Format.Duration
+	}
+	source, err := ffwrap.ParseDuration(d)
+	if err != nil {
+		return 0
+	}
+	return tj.JobDefinition.OutputDuration(source)
+}
+
 // registerLogFile registers a log file path for a given job ID.
-// It inserts or replaces the file path in the 'log_files' table.
+// It inserts or replaces the file path in the 'log_files' table along with the
+// expected duration of the output, used to report the progress of the job.
 func registerLogFile(tj *TranscodeJob) error {
 	fp := filepath.Base(tj.JobDefinition.Destination)
 	tj.JobDefinition.LogDestination = filepath.Join(encodeLogDir, fmt.Sprintf("%s_%d.log", fp, time.Now().UnixNano()))
@@ -326,9 +750,9 @@ func registerLogFile(tj *TranscodeJob) error {
 	}
 
 	_, err = db.Exec(`
-		INSERT OR REPLACE INTO log_files(id, logfile)
-		VALUES(?,?)
-	`, tj.Id, tj.JobDefinition.LogDestination)
+		INSERT OR REPLACE INTO log_files(id, logfile, duration)
+		VALUES(?,?,?)
+	`, tj.Id, tj.JobDefinition.LogDestination, outputDuration(tj))
 	if err != nil {
 		return err
 	}
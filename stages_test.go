@@ -2,7 +2,10 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap"
@@ -111,7 +114,7 @@ func TestPullNextTranscode(t *testing.T) {
 				JobDefinition: ffwrap.TranscodeRequest{
 					Source:        "/path/to/source1.mkv",
 					Destination:   "/path/to/destination1.mkv",
-					Srt_files:     []string{"srt_file1"},
+					Srt_files:     []ffwrap.SubtitleFile{{Path: "srt_file1"}},
 					Crf:           18,
 					Codec:         "libx265",
 					Video_filters: "",
@@ -131,7 +134,7 @@ func TestPullNextTranscode(t *testing.T) {
 				JobDefinition: ffwrap.TranscodeRequest{
 					Source:        "/path/to/source2.mkv",
 					Destination:   "/path/to/destination2.mkv",
-					Srt_files:     []string{"srt_file2"},
+					Srt_files:     []ffwrap.SubtitleFile{{Path: "srt_file2"}},
 					Crf:           18,
 					Codec:         "libx265",
 					Video_filters: "",
@@ -140,6 +143,27 @@ func TestPullNextTranscode(t *testing.T) {
 			},
 			expectedError: nil,
 		},
+		{
+			desc: "copy with an encoded rendition",
+			setup: func() {
+				insertQueuedJob(t, 1, "copy")
+				setOutputs(t, 1, []ffwrap.Rendition{{Destination: "/path/to/720p.mkv", Codec: "libx265"}})
+			},
+			expectedResult: TranscodeJob{
+				Id: 1,
+				JobDefinition: ffwrap.TranscodeRequest{
+					Source:        "/path/to/source1.mkv",
+					Destination:   "/path/to/destination1.mkv",
+					Srt_files:     []ffwrap.SubtitleFile{{Path: "srt_file1"}},
+					Crf:           18,
+					Codec:         "copy",
+					Outputs:       []ffwrap.Rendition{{Destination: "/path/to/720p.mkv", Codec: "libx265"}},
+					Video_filters: "",
+					Autocrop:      false,
+				},
+			},
+			expectedError: nil,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -197,6 +221,34 @@ func TestPullNextCrop(t *testing.T) {
 			},
 			expectedError: nil,
 		},
+		{
+			desc: "interlaced source without autocrop",
+			setup: func() {
+				insertQueuedJob(t, 1, "libx265")
+				if _, err := db.Exec("UPDATE transcode_queue SET scan_type = 'interlaced' WHERE id = 1"); err != nil {
+					t.Fatalf("failed to set scan type: %v", err)
+				}
+			},
+			expectedResult: TranscodeJob{
+				Id: 1,
+				JobDefinition: ffwrap.TranscodeRequest{
+					Source:    "/path/to/source1.mkv",
+					Scan_type: "interlaced",
+				},
+			},
+			expectedError: nil,
+		},
+		{
+			desc: "crop held for review",
+			setup: func() {
+				insertQueuedCrop(t, 1, "libx265")
+				if _, err := db.Exec("UPDATE transcode_queue SET crop_complete = 2 WHERE id = 1"); err != nil {
+					t.Fatalf("failed to hold job: %v", err)
+				}
+			},
+			expectedResult: TranscodeJob{},
+			expectedError:  sql.ErrNoRows,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -218,6 +270,18 @@ func TestPullNextCrop(t *testing.T) {
 	}
 }
 
+// setOutputs stores the renditions of a queued job as the add handler does.
+func setOutputs(t *testing.T, id int, outputs []ffwrap.Rendition) {
+	t.Helper()
+	b, err := json.Marshal(outputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE transcode_queue SET outputs = ? WHERE id = ?", b, id); err != nil {
+		t.Fatalf("failed to set outputs: %v", err)
+	}
+}
+
 func TestPullNextCopy(t *testing.T) {
 	odb := db
 	oh := wsHub
@@ -249,7 +313,7 @@ func TestPullNextCopy(t *testing.T) {
 				JobDefinition: ffwrap.TranscodeRequest{
 					Source:        "/path/to/source1.mkv",
 					Destination:   "/path/to/destination1.mkv",
-					Srt_files:     []string{"srt_file1"},
+					Srt_files:     []ffwrap.SubtitleFile{{Path: "srt_file1"}},
 					Crf:           18,
 					Codec:         "copy",
 					Video_filters: "",
@@ -269,9 +333,39 @@ func TestPullNextCopy(t *testing.T) {
 				JobDefinition: ffwrap.TranscodeRequest{
 					Source:        "/path/to/source2.mkv",
 					Destination:   "/path/to/destination2.mkv",
-					Srt_files:     []string{"srt_file2"},
+					Srt_files:     []ffwrap.SubtitleFile{{Path: "srt_file2"}},
+					Crf:           18,
+					Codec:         "copy",
+					Video_filters: "",
+					Autocrop:      false,
+				},
+			},
+			expectedError: nil,
+		},
+		{
+			desc: "copy with an encoded rendition",
+			setup: func() {
+				insertQueuedJob(t, 1, "copy")
+				setOutputs(t, 1, []ffwrap.Rendition{{Destination: "/path/to/720p.mkv", Codec: "libx265"}})
+			},
+			expectedResult: TranscodeJob{},
+			expectedError:  sql.ErrNoRows,
+		},
+		{
+			desc: "copy with copied renditions",
+			setup: func() {
+				insertQueuedJob(t, 1, "copy")
+				setOutputs(t, 1, []ffwrap.Rendition{{Destination: "/path/to/copy.mkv"}})
+			},
+			expectedResult: TranscodeJob{
+				Id: 1,
+				JobDefinition: ffwrap.TranscodeRequest{
+					Source:        "/path/to/source1.mkv",
+					Destination:   "/path/to/destination1.mkv",
+					Srt_files:     []ffwrap.SubtitleFile{{Path: "srt_file1"}},
 					Crf:           18,
 					Codec:         "copy",
+					Outputs:       []ffwrap.Rendition{{Destination: "/path/to/copy.mkv"}},
 					Video_filters: "",
 					Autocrop:      false,
 				},
@@ -351,3 +445,55 @@ func TestQuerySourceTable(t *testing.T) {
 		db.Close()
 	}
 }
+
+func TestRenditionStates(t *testing.T) {
+	dir := t.TempDir()
+	written := filepath.Join(dir, "2160p.mkv")
+	if err := os.WriteFile(written, []byte("media"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "1080p.mkv")
+
+	tj := TranscodeJob{
+		Id: 1,
+		JobDefinition: ffwrap.TranscodeRequest{
+			Destination: written,
+			Codec:       "libx265",
+			Outputs:     []ffwrap.Rendition{{Destination: missing}, {Destination: written + ".mp4", Codec: "libsvtav1"}},
+		},
+	}
+
+	testCases := []struct {
+		desc     string
+		state    JobState
+		expected []renditionState
+	}{
+		{
+			desc:  "success only for written outputs",
+			state: JOB_SUCCESS,
+			expected: []renditionState{
+				{written, "libx265", JOB_SUCCESS},
+				{missing, "libx265", JOB_FAILED},
+				{written + ".mp4", "libsvtav1", JOB_FAILED},
+			},
+		},
+		{
+			desc:  "failed job fails every output",
+			state: JOB_FAILED,
+			expected: []renditionState{
+				{written, "libx265", JOB_FAILED},
+				{missing, "libx265", JOB_FAILED},
+				{written + ".mp4", "libsvtav1", JOB_FAILED},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			tj.State = tc.state
+			diff := cmp.Diff(tc.expected, renditionStates(&tj), cmp.AllowUnexported(renditionState{}))
+			if diff != "" {
+				t.Errorf("%q: unexpected rendition states: %s", tc.desc, diff)
+			}
+		})
+	}
+}
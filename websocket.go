@@ -3,10 +3,13 @@ package main
 import (
 	"database/sql"
 	"io"
+	"math"
 	"os"
+	"regexp"
 	"strings"
 	"time"
 
+	"github.com/gitgerby/transcode-factory/internal/pkg/ffwrap"
 	"github.com/google/logger"
 	"github.com/gorilla/websocket"
 )
@@ -23,10 +26,14 @@ const (
 )
 
 type statusMessage struct {
-	LogMessages   map[int]string `json:"LogMessages"`
-	RefreshNeeded bool           `json:"RefreshNeeded"`
+	LogMessages   map[int]string  `json:"LogMessages"`
+	Progress      map[int]float64 `json:"Progress"`
+	RefreshNeeded bool            `json:"RefreshNeeded"`
 }
 
+// progressRegex extracts the position of the output from an ffmpeg stats line.
+var progressRegex = regexp.MustCompile(`time=(\d+:\d{2}:\d{2}(?:\.\d+)?)`)
+
 // Client is a middleman between the websocket connection and the hub.
 type Client struct {
 	hub *Hub
@@ -127,6 +134,7 @@ func (h *Hub) run() {
 			// drain the queue
 			r := message.RefreshNeeded
 			l := message.LogMessages
+			p := message.Progress
 			ql := len(h.broadcast)
 			for i := 0; i < ql; i++ {
 				nm := <-h.broadcast
@@ -135,11 +143,13 @@ func (h *Hub) run() {
 				}
 				if len(nm.LogMessages) > 0 {
 					l = nm.LogMessages
+					p = nm.Progress
 				}
 			}
 			// send only the most relevant message
 			message.RefreshNeeded = r
 			message.LogMessages = l
+			message.Progress = p
 			for client := range h.clients {
 				select {
 				case client.send <- message:
@@ -155,7 +165,7 @@ func (h *Hub) run() {
 // feedSockets collects and collates messages to be sent to websocket clients.
 func (h *Hub) feedSockets() {
 	pstmt, err := db.Prepare(`
-	SELECT id, logfile
+	SELECT id, logfile, IFNULL(duration, 0)
 	FROM log_files
 	WHERE id IN (SELECT id FROM active_jobs)
 	`)
@@ -191,7 +201,7 @@ func (h *Hub) feedSockets() {
 				continue
 			}
 
-			wsu.LogMessages, err = processLogRows(lf)
+			wsu.LogMessages, wsu.Progress, err = processLogRows(lf)
 			if err != nil {
 				logger.Errorf("could not get log tails: %v", err)
 			}
@@ -208,15 +218,18 @@ func (h *Hub) feedSockets() {
 // and stores the message in a map indexed by the job ID. If any error occurs during this process,
 // it logs the error and continues processing the remaining rows. If there are no errors, it returns
 // the map of log messages indexed by job ID; otherwise, it returns an error if one occurred.
-func processLogRows(rows *sql.Rows) (map[int]string, error) {
+// The progress of every job with a known output duration is returned alongside the messages.
+func processLogRows(rows *sql.Rows) (map[int]string, map[int]float64, error) {
 	var logMessages = make(map[int]string)
+	var progress = make(map[int]float64)
 	row := struct {
-		id   int
-		file string
+		id       int
+		file     string
+		duration float64
 	}{}
 	var err error
 	for rows.Next() {
-		err = rows.Scan(&row.id, &row.file)
+		err = rows.Scan(&row.id, &row.file, &row.duration)
 		if err != nil && err != sql.ErrNoRows {
 			logger.Errorf("failed to scan for log files: %v", err)
 			continue
@@ -228,12 +241,29 @@ func processLogRows(rows *sql.Rows) (map[int]string, error) {
 			continue
 		}
 		logMessages[row.id] = m
+		if p, ok := logProgress(m, row.duration); ok {
+			progress[row.id] = p
+		}
 	}
 	if rows.Err() != nil && rows.Err() != sql.ErrNoRows {
 		logger.Errorf("failed processing log rows: %v", err)
-		return nil, err
+		return nil, nil, err
+	}
+	return logMessages, progress, nil
+}
+
+// logProgress returns the percentage of an output of duration seconds written
+// according to an ffmpeg stats line.
+func logProgress(line string, duration float64) (float64, bool) {
+	m := progressRegex.FindStringSubmatch(line)
+	if m == nil || duration <= 0 {
+		return 0, false
+	}
+	t, err := ffwrap.ParseDuration(m[1])
+	if err != nil {
+		return 0, false
 	}
-	return logMessages, nil
+	return math.Round(min(t/duration, 1)*1000) / 10, true
 }
 
 // tailLog reads the last line from a given file path. It opens the file, moves to the end, and then reads backwards until it finds a newline character or reaches the buffer size limit.
@@ -106,3 +106,25 @@ func TestTailLog(t *testing.T) {
 		}
 	}
 }
+
+func TestLogProgress(t *testing.T) {
+	tt := []struct {
+		desc     string
+		line     string
+		duration float64
+		expected float64
+		ok       bool
+	}{
+		{desc: "halfway", line: "frame= 1440 fps= 24 q=28.0 size=   10240kB time=00:01:00.00 bitrate=1398.1kbits/s speed=1.0x", duration: 120, expected: 50, ok: true},
+		{desc: "trimmed output", line: "size=   10240kB time=00:00:20.00 bitrate=1398.1kbits/s", duration: 30, expected: 66.7, ok: true},
+		{desc: "past the end", line: "time=00:02:05.50 bitrate=1398.1kbits/s", duration: 120, expected: 100, ok: true},
+		{desc: "unknown duration", line: "time=00:01:00.00 bitrate=1398.1kbits/s", duration: 0},
+		{desc: "no stats", line: "Press [q] to stop", duration: 120},
+	}
+	for _, tc := range tt {
+		got, ok := logProgress(tc.line, tc.duration)
+		if ok != tc.ok || got != tc.expected {
+			t.Errorf("%q: logProgress() = %v, %v want %v, %v", tc.desc, got, ok, tc.expected, tc.ok)
+		}
+	}
+}